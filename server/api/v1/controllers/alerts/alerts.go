@@ -0,0 +1,74 @@
+package alerts
+
+import (
+	"strconv"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of the alert list
+const (
+	defaultAlertsLimit = 50
+	maxAlertsLimit     = 200
+)
+
+// GetAlertsHandler returns the traffic alerts of the user, newest first.
+// ?unread=true only returns alerts that haven't been marked as read.
+func GetAlertsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "Unauthorized")
+			return
+		}
+
+		unreadOnly := false
+		if unread := c.Query("unread"); unread != "" {
+			v, err := strconv.ParseBool(unread)
+			if err != nil {
+				response.SendBadRequestError(c, "Invalid unread filter: "+unread)
+				return
+			}
+			unreadOnly = v
+		}
+		limit := defaultAlertsLimit
+		if l := c.Query("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n <= 0 {
+				response.SendBadRequestError(c, "Invalid limit: "+l)
+				return
+			}
+			limit = min(n, maxAlertsLimit)
+		}
+
+		alerts, err := services.ListAlerts(uid, unreadOnly, limit)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"alerts": alerts})
+	}
+}
+
+// MarkAlertReadHandler marks an alert as read
+func MarkAlertReadHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "Unauthorized")
+			return
+		}
+
+		if err := services.MarkAlertRead(uid, c.Param("id")); err != nil {
+			if err == services.ErrAlertNotFound {
+				response.SendNotFoundError(c, "Alert not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Alert marked as read"})
+	}
+}
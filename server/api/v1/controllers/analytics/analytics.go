@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"os"
 	"sync"
-	"time"
 
 	"github.com/RishiKendai/sot/pkg/config/response"
 	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -52,18 +52,35 @@ func GetAnalyticsHandler() gin.HandlerFunc {
 			return
 		}
 
-		// Get date range from query parameters
-		startDate := c.Query("start_date")
-		endDate := c.Query("end_date")
-
-		if startDate == "" {
-			startDate = time.Now().AddDate(0, 0, -30).Format("2006-01-02") // Default to last 30 days
+		tz, err := services.ResolveTimeZone(c.Query("tz"), userUID.(string))
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
 		}
-		if endDate == "" {
-			endDate = time.Now().Format("2006-01-02")
+		compare := c.Query("compare")
+		if err := services.ValidateCompareMode(compare); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
 		}
 
-		analytics, err := getAnalyticsSummary(userUID.(string), startDate, endDate)
+		// Optionally only the links of a folder
+		folder := c.Query("folder")
+		if folder != "" {
+			if _, err := services.GetFolder(userUID.(string), folder); err != nil {
+				if err == services.ErrFolderNotFound {
+					response.SendNotFoundError(c, "Folder not found")
+					return
+				}
+				response.SendServerError(c, err)
+				return
+			}
+		}
+
+		// Get date range from query parameters, as calendar days in the requested time zone.
+		// Defaults to the last 30 days.
+		startDate, endDate := services.DefaultDateRange(c.Query("start_date"), c.Query("end_date"), tz)
+
+		analytics, err := GetAnalyticsSummary(userUID.(string), "", folder, startDate, endDate, tz, compare)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
@@ -100,7 +117,25 @@ func GetLinkAnalyticsHandler() gin.HandlerFunc {
 			return
 		}
 
-		analytics, err := getLinkAnalytics(shortLink)
+		tz, err := services.ResolveTimeZone(c.Query("tz"), userUID.(string))
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		compare := c.Query("compare")
+		if err := services.ValidateCompareMode(compare); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		// Without a date range the link's whole history is returned; comparisons need one
+		startDate, endDate := c.Query("start_date"), c.Query("end_date")
+		if compare != "" {
+			startDate, endDate = services.DefaultDateRange(startDate, endDate, tz)
+		}
+
+		analytics, err := getLinkAnalytics(shortLink, startDate, endDate, tz, compare)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
@@ -110,8 +145,10 @@ func GetLinkAnalyticsHandler() gin.HandlerFunc {
 	}
 }
 
-// getAnalyticsSummary gets comprehensive analytics summary
-func getAnalyticsSummary(userUID, startDate, endDate string) (*AnalyticsSummary, error) {
+// GetAnalyticsSummary gets comprehensive analytics summary of all links of the user, or of a
+// single link when shortLink is set or of the links of a folder when folderID is set. The
+// account-wide summary also breaks clicks down by folder. It also backs the scheduled email reports.
+func GetAnalyticsSummary(userUID, shortLink, folderID, startDate, endDate, timeZone, compare string) (*AnalyticsSummary, error) {
 	var (
 		wg        sync.WaitGroup
 		mu        sync.Mutex
@@ -119,6 +156,12 @@ func getAnalyticsSummary(userUID, startDate, endDate string) (*AnalyticsSummary,
 		errs      []error
 	)
 
+	scope, err := services.NewStatsScope(userUID, shortLink, startDate, endDate, timeZone)
+	if err != nil {
+		return nil, err
+	}
+	scope.FolderID = folderID
+
 	// Channels for collecting results
 	topLinksCh := make(chan []TopPerformingLink, 1)
 	recentActivityCh := make(chan []RecentActivity, 1)
@@ -126,15 +169,47 @@ func getAnalyticsSummary(userUID, startDate, endDate string) (*AnalyticsSummary,
 
 	// 1. Top Performing Links
 	wg.Add(1)
-	go fetchTopPerformingLinks(&wg, topLinksCh, &mu, &errs, userUID, startDate, endDate)
+	go fetchTopPerformingLinks(&wg, topLinksCh, &mu, &errs, scope)
 
 	// 2. Recent Links
 	wg.Add(1)
-	go fetchRecentActivity(&wg, recentActivityCh, &mu, &errs, userUID, startDate, endDate)
+	go fetchRecentActivity(&wg, recentActivityCh, &mu, &errs, scope)
 
 	// 3. Analytics Stats
 	wg.Add(1)
-	go fetchAnalyticsStats(&wg, analyticsStatsCh, &mu, &errs, userUID, startDate, endDate)
+	go fetchAnalyticsStats(&wg, analyticsStatsCh, &mu, &errs, scope)
+
+	// 4. Comparison window
+	if compare != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comparison, err := services.FetchComparison(scope, compare)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			analytics.Comparison = comparison
+		}()
+	}
+
+	// 5. Clicks per folder
+	if shortLink == "" && folderID == "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			folders, err := services.FetchFolderClicks(scope)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			analytics.Folders = folders
+		}()
+	}
 
 	wg.Wait()
 
@@ -150,7 +225,7 @@ func getAnalyticsSummary(userUID, startDate, endDate string) (*AnalyticsSummary,
 }
 
 // getLinkAnalytics gets analytics for a specific link
-func getLinkAnalytics(shortLink string) (map[string]interface{}, error) {
+func getLinkAnalytics(shortLink, startDate, endDate, timeZone, compare string) (map[string]interface{}, error) {
 	analytics := make(map[string]interface{})
 
 	// Get link details to build full_short_link
@@ -173,98 +248,51 @@ func getLinkAnalytics(shortLink string) (map[string]interface{}, error) {
 		analytics["full_short_link"] = fullShortLink
 	}
 
-	// Get total clicks for this link
-	totalClicks, err := postgres.CountDocuments("SELECT COUNT(*) FROM analytics WHERE short_link = $1", shortLink)
+	scope, err := services.NewStatsScope(userUID, shortLink, startDate, endDate, timeZone)
 	if err != nil {
 		return nil, err
 	}
-	analytics["total_clicks"] = totalClicks
 
-	// Get unique visitors for this link
-	uniqueVisitors, err := postgres.CountDocuments("SELECT COUNT(DISTINCT ip_address) FROM analytics WHERE short_link = $1", shortLink)
+	// Get total clicks and unique visitors for this link
+	totals, err := services.FetchClickTotals(scope)
 	if err != nil {
 		return nil, err
 	}
-	analytics["unique_visitors"] = uniqueVisitors
-
-	// Get browser distribution
-	browserStats := make(map[string]int64)
-	browserRows, err := postgres.FindMany("SELECT browser, COUNT(*) FROM analytics WHERE short_link = $1 AND browser != 'Unknown' GROUP BY browser", shortLink)
-	if err == nil {
-		defer browserRows.Close()
-		for browserRows.Next() {
-			var browser string
-			var count int64
-			if err := browserRows.Scan(&browser, &count); err == nil {
-				browserStats[browser] = count
-			}
-		}
-	}
-	analytics["browser_stats"] = browserStats
-
-	// Get OS distribution
-	osStats := make(map[string]int64)
-	osRows, err := postgres.FindMany("SELECT operating_system, COUNT(*) FROM analytics WHERE short_link = $1 AND operating_system != 'Unknown' GROUP BY operating_system", shortLink)
-	if err == nil {
-		defer osRows.Close()
-		for osRows.Next() {
-			var os string
-			var count int64
-			if err := browserRows.Scan(&os, &count); err == nil {
-				osStats[os] = count
-			}
-		}
+	analytics["total_clicks"] = totals.TotalClicks
+	analytics["unique_visitors"] = totals.UniqueVisitors
+
+	// Get browser, OS, country and device distribution
+	breakdowns := []struct {
+		key       string
+		dimension string
+		exclude   []string
+	}{
+		{"browser_stats", "browser", []string{"Unknown", ""}},
+		{"os_stats", "os", []string{"Unknown", ""}},
+		{"country_stats", "country", []string{"Unknown", ""}},
+		{"device_stats", "device", []string{""}},
 	}
-	analytics["os_stats"] = osStats
-
-	// Get country distribution
-	countryStats := make(map[string]int64)
-	countryRows, err := postgres.FindMany("SELECT country, COUNT(*) FROM analytics WHERE short_link = $1 AND country != 'Unknown' GROUP BY country", shortLink)
-	if err == nil {
-		defer countryRows.Close()
-		for countryRows.Next() {
-			var country string
-			var count int64
-			if err := countryRows.Scan(&country, &count); err == nil {
-				countryStats[country] = count
-			}
-		}
-	}
-	analytics["country_stats"] = countryStats
-
-	// Get device distribution
-	deviceStats := make(map[string]int64)
-	deviceRows, err := postgres.FindMany("SELECT device_type, COUNT(*) FROM analytics WHERE short_link = $1 GROUP BY device_type", shortLink)
-	if err == nil {
-		defer deviceRows.Close()
-		for deviceRows.Next() {
-			var device string
-			var count int64
-			if err := deviceRows.Scan(&device, &count); err == nil {
-				deviceStats[device] = count
-			}
+	for _, b := range breakdowns {
+		stats, err := services.FetchDimensionStats(scope, b.dimension, b.exclude...)
+		if err != nil {
+			return nil, err
 		}
+		analytics[b.key] = services.DimensionMap(stats)
 	}
-	analytics["device_stats"] = deviceStats
 
 	// Get QR code vs direct link stats
-	qrStats := make(map[string]int64)
-	qrRows, err := postgres.FindMany("SELECT is_qr_code, COUNT(*) FROM analytics WHERE short_link = $1 GROUP BY is_qr_code", shortLink)
-	if err == nil {
-		defer qrRows.Close()
-		for qrRows.Next() {
-			var isQR bool
-			var count int64
-			if err := qrRows.Scan(&isQR, &count); err == nil {
-				key := "QR Code"
-				if !isQR {
-					key = "Direct Link"
-				}
-				qrStats[key] = count
-			}
+	analytics["qr_stats"] = map[string]int64{
+		"QR Code":     totals.QRClicks,
+		"Direct Link": totals.TotalClicks - totals.QRClicks,
+	}
+
+	if compare != "" {
+		comparison, err := services.FetchComparison(scope, compare)
+		if err != nil {
+			return nil, err
 		}
+		analytics["comparison"] = comparison
 	}
-	analytics["qr_stats"] = qrStats
 
 	return analytics, nil
 }
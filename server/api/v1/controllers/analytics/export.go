@@ -0,0 +1,131 @@
+package analytics
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ExportAnalyticsHandler exports the account's clicks or daily stats
+func ExportAnalyticsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		exportAnalytics(c, c.GetString("uid"), "")
+	}
+}
+
+// ExportLinkAnalyticsHandler exports the clicks or daily stats of a single link
+func ExportLinkAnalyticsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		shortLink := c.Param("shortLink")
+
+		row, err := postgres.FindOne("SELECT short_link FROM links WHERE short_link = $1 AND user_uid = $2", shortLink, uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if err := row.Scan(&shortLink); err != nil {
+			if err == sql.ErrNoRows {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		exportAnalytics(c, uid, shortLink)
+	}
+}
+
+// exportAnalytics streams the export in the response, or queues a job when async=true
+// or the range is too long to stream
+func exportAnalytics(c *gin.Context, userUID, shortLink string) {
+	if userUID == "" {
+		response.SendUnAuthorizedError(c, "User not authenticated")
+		return
+	}
+
+	tz, err := services.ResolveTimeZone(c.Query("tz"), userUID)
+	if err != nil {
+		response.SendBadRequestError(c, err.Error())
+		return
+	}
+	startDate, endDate := services.DefaultDateRange(c.Query("start_date"), c.Query("end_date"), tz)
+
+	opts := services.ExportOptions{
+		UserUID:   userUID,
+		ShortLink: shortLink,
+		Kind:      c.DefaultQuery("kind", services.ExportClicks),
+		Format:    c.DefaultQuery("format", services.ExportCSV),
+		StartDate: startDate,
+		EndDate:   endDate,
+		TimeZone:  tz,
+	}
+	days, err := opts.Validate()
+	if err != nil {
+		response.SendBadRequestError(c, err.Error())
+		return
+	}
+
+	if c.Query("async") == "true" || days > services.ExportSyncMaxDays {
+		job, err := services.CreateExportJob(opts)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendAcceptedJSON(c, job)
+		return
+	}
+
+	c.Header("Content-Type", opts.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, opts.FileName()))
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so errors can only be logged and the stream cut short
+	if _, err := services.WriteExport(c.Request.Context(), c.Writer, opts); err != nil {
+		log.Printf("Export for %s failed: %v", userUID, err)
+	}
+}
+
+// GetExportJobHandler returns the status of an async export, including its download token once done
+func GetExportJobHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := services.GetExportJob(c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			if err == services.ErrExportNotFound {
+				response.SendNotFoundError(c, "Export not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, job)
+	}
+}
+
+// DownloadExportHandler serves the file of a finished async export
+func DownloadExportHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		job, path, err := services.ExportDownload(uid, c.Param("token"))
+		if err != nil {
+			if err == services.ErrExportNotFound {
+				response.SendNotFoundError(c, "Export not found or expired")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		opts := job.Options(uid)
+		c.Header("Content-Type", opts.ContentType())
+		c.FileAttachment(path, opts.FileName())
+	}
+}
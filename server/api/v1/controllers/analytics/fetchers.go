@@ -6,9 +6,9 @@ import (
 	"os"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
 )
 
 // UserSubdomainSettings represents user subdomain configuration
@@ -100,26 +100,12 @@ func buildShortLinkURLsBatchForAnalytics(topLinks []TopPerformingLink, recentAct
 	return nil
 }
 
-func fetchTopPerformingLinks(wg *sync.WaitGroup, ch chan<- []TopPerformingLink, mu *sync.Mutex, errs *[]error, userUID string, startDate, endDate string) {
+func fetchTopPerformingLinks(wg *sync.WaitGroup, ch chan<- []TopPerformingLink, mu *sync.Mutex, errs *[]error, scope services.StatsScope) {
 	defer wg.Done()
 	defer close(ch)
 	topLinks := []TopPerformingLink{}
 
-	r, err := postgres.FindMany(`
-		SELECT
-			a.short_link,
-			l.original_link,
-			COUNT(*) AS total_clicks,
-			COUNT(*) FILTER (WHERE is_qr_code = TRUE) AS total_qr_clicks,
-			COUNT(*) FILTER (WHERE COALESCE(referrer, '') = '') AS direct_clicks
-		FROM analytics a
-		JOIN links l ON a.short_link = l.short_link
-		WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-		GROUP BY a.short_link, l.original_link
-		ORDER BY total_clicks DESC
-		LIMIT 5;
-	`, userUID, startDate, endDate)
-
+	links, err := services.FetchTopLinks(scope, 5)
 	if err != nil {
 		mu.Lock()
 		*errs = append(*errs, err)
@@ -127,20 +113,18 @@ func fetchTopPerformingLinks(wg *sync.WaitGroup, ch chan<- []TopPerformingLink,
 		return
 	}
 
-	for r.Next() {
-		var topLink TopPerformingLink
-		err := r.Scan(&topLink.ShortLink, &topLink.OriginalLink, &topLink.TotalClicks, &topLink.QRClicks, &topLink.DirectClicks)
-		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
-			continue
-		}
-		topLinks = append(topLinks, topLink)
+	for _, l := range links {
+		topLinks = append(topLinks, TopPerformingLink{
+			ShortLink:    l.ShortLink,
+			OriginalLink: l.OriginalLink,
+			TotalClicks:  l.TotalClicks,
+			QRClicks:     l.QRClicks,
+			DirectClicks: l.DirectClicks,
+		})
 	}
 
 	// Build full short link URLs efficiently in batch
-	if err := buildShortLinkURLsBatchForAnalytics(topLinks, []RecentActivity{}, userUID); err != nil {
+	if err := buildShortLinkURLsBatchForAnalytics(topLinks, []RecentActivity{}, scope.UserUID); err != nil {
 		mu.Lock()
 		*errs = append(*errs, err)
 		mu.Unlock()
@@ -150,10 +134,14 @@ func fetchTopPerformingLinks(wg *sync.WaitGroup, ch chan<- []TopPerformingLink,
 	ch <- topLinks
 }
 
-func fetchRecentActivity(wg *sync.WaitGroup, ch chan<- []RecentActivity, mu *sync.Mutex, errs *[]error, userUID, startDate, endDate string) {
+func fetchRecentActivity(wg *sync.WaitGroup, ch chan<- []RecentActivity, mu *sync.Mutex, errs *[]error, scope services.StatsScope) {
 	defer wg.Done()
 	defer close(ch)
 	recentActivities := []RecentActivity{}
+	userUID := scope.UserUID
+
+	// The date range is in the scope's time zone, so filter on the exact instants
+	from, to, _ := scope.Bounds()
 
 	r, err := postgres.FindMany(`
 		SELECT
@@ -172,10 +160,12 @@ func fetchRecentActivity(wg *sync.WaitGroup, ch chan<- []RecentActivity, mu *syn
 			a.click_timestamp AS click_time
 		FROM analytics a
 		JOIN links l ON a.short_link = l.short_link
-		WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-		ORDER BY a.click_date DESC
+		WHERE l.user_uid = $1 AND a.click_timestamp >= $2 AND a.click_timestamp < $3
+			AND ($4 = '' OR a.short_link = $4)
+			AND ($5 = '' OR l.folder_id::text = $5)
+		ORDER BY a.click_timestamp DESC
 		LIMIT 5;
-	`, userUID, startDate, endDate)
+	`, userUID, from, to, scope.ShortLink, scope.FolderID)
 
 	if err != nil {
 		mu.Lock()
@@ -207,306 +197,151 @@ func fetchRecentActivity(wg *sync.WaitGroup, ch chan<- []RecentActivity, mu *syn
 	ch <- recentActivities
 }
 
-func fetchAnalyticsStats(wg *sync.WaitGroup, ch chan<- AnalyticsStats, mu *sync.Mutex, errs *[]error, userUID, startDate, endDate string) {
+func fetchAnalyticsStats(wg *sync.WaitGroup, ch chan<- AnalyticsStats, mu *sync.Mutex, errs *[]error, scope services.StatsScope) {
 	defer wg.Done()
 	defer close(ch)
 	var analyticsStats AnalyticsStats
 
 	var wg2 sync.WaitGroup
-	hrCh := make(chan map[int]int64, 1)
-	dyCh := make(chan map[string]int64, 1)
-	wkCh := make(chan map[int]int64, 1)
-	moCh := make(chan map[string]int64, 1)
-	osCh := make(chan map[string]int64, 1)
-	dvCh := make(chan map[string]int64, 1)
-	brCh := make(chan map[string]int64, 1)
-	geoCh := make(chan []GeographicData, 1)
+	addErr := func(err error) {
+		mu.Lock()
+		*errs = append(*errs, err)
+		mu.Unlock()
+	}
 
+	// Each goroutine fills a different field, so no locking is needed until wg2.Wait
 	// 3. Hourly Clicks
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(hrCh)
-		hourlyStats := make(map[int]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.hour_of_day, COUNT(*) FROM analytics a 
-			JOIN links l ON a.short_link = l.short_link 
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-			GROUP BY a.hour_of_day ORDER BY a.hour_of_day
-	`, userUID, startDate, endDate)
-
+		hourlyStats, err := services.FetchHourlyStats(scope)
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-
-		for r.Next() {
-			var hour int
-			var count int64
-			err := r.Scan(&hour, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			hourlyStats[hour] = count
-		}
-		hrCh <- hourlyStats
+		analyticsStats.HourlyStats = hourlyStats
 	}()
 	// 4. Daily Clicks
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(dyCh)
-		dailyStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.click_date, COUNT(*) FROM analytics a 
-			JOIN links l ON a.short_link = l.short_link 
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-			GROUP BY a.click_date ORDER BY a.click_date
-	`, userUID, startDate, endDate)
-
+		dailyStats, err := services.FetchDailyStats(scope)
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-
-		for r.Next() {
-			var date time.Time
-			var count int64
-			err := r.Scan(&date, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			dailyStats[date.Format("2006-01-02")] = count
-		}
-		dyCh <- dailyStats
+		analyticsStats.DailyStats = dailyStats
 	}()
 	// 5. Weekly Clicks
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(wkCh)
-		weeklyStats := make(map[int]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.day_of_week, COUNT(*) FROM analytics a 
-			JOIN links l ON a.short_link = l.short_link 
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-			GROUP BY a.day_of_week ORDER BY a.day_of_week
-	`, userUID, startDate, endDate)
-
+		weeklyStats, err := services.FetchWeekdayStats(scope)
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-
-		for r.Next() {
-			var day int
-			var count int64
-			err := r.Scan(&day, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			weeklyStats[day] = count
-		}
-		wkCh <- weeklyStats
+		analyticsStats.WeeklyStats = weeklyStats
 	}()
 	// 6. Monthly Clicks
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(moCh)
-		monthlyStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.month, COUNT(*) FROM analytics a
-			JOIN links l ON a.short_link = l.short_link
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-			GROUP BY a.month ORDER BY a.month
-	`, userUID, startDate, endDate)
-
+		monthlyStats, err := services.FetchMonthlyStats(scope)
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		for r.Next() {
-			var month string
-			var count int64
-			err := r.Scan(&month, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			monthlyStats[month] = count
-		}
-		moCh <- monthlyStats
+		analyticsStats.MonthlyStats = monthlyStats
 	}()
 	// 7. OS Stats
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(osCh)
-		osStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.operating_system, COUNT(*) FROM analytics a 
-			JOIN links l ON a.short_link = l.short_link 
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3 AND a.operating_system != 'Unknown'
-			GROUP BY a.operating_system ORDER BY COUNT(*) DESC
-		`, userUID, startDate, endDate)
-
+		osStats, err := services.FetchDimensionStats(scope, "os", "Unknown", "")
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-
-		for r.Next() {
-			var os string
-			var count int64
-			err := r.Scan(&os, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			osStats[os] = count
-		}
-		osCh <- osStats
+		analyticsStats.OSStats = services.DimensionMap(osStats)
 	}()
 	// 8. Device Stats
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(dvCh)
-		deviceStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.device_type, COUNT(*) FROM analytics a 
-			JOIN links l ON a.short_link = l.short_link 
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-			GROUP BY a.device_type ORDER BY COUNT(*) DESC
-		`, userUID, startDate, endDate)
-
+		deviceStats, err := services.FetchDimensionStats(scope, "device", "")
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-
-		for r.Next() {
-			var device string
-			var count int64
-			err := r.Scan(&device, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			deviceStats[device] = count
-		}
-		dvCh <- deviceStats
+		analyticsStats.DeviceStats = services.DimensionMap(deviceStats)
 	}()
 	// 9. Browser Stats
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(brCh)
-		browserStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.browser, COUNT(*) FROM analytics a
-			JOIN links l ON a.short_link = l.short_link
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3
-			GROUP BY a.browser ORDER BY COUNT(*) DESC
-		`, userUID, startDate, endDate)
-
+		browserStats, err := services.FetchDimensionStats(scope, "browser", "")
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-
-		for r.Next() {
-			var browser string
-			var count int64
-			err := r.Scan(&browser, &count)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			browserStats[browser] = count
+		analyticsStats.BrowserStats = services.DimensionMap(browserStats)
+	}()
+	// 10. Geographic Stats
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		countryStats, err := services.FetchDimensionStats(scope, "country", "Unknown", "")
+		if err != nil {
+			addErr(err)
+			return
+		}
+		geographicStats := make([]GeographicData, 0, len(countryStats))
+		for _, dc := range countryStats {
+			geographicStats = append(geographicStats, GeographicData{
+				Country:     dc.Value,
+				CountryCode: dc.Code,
+				ClickCount:  dc.Clicks,
+			})
 		}
-		brCh <- browserStats
+		analyticsStats.GeographicData = geographicStats
 	}()
-	// 9. Geographic Stats
+	// 11. Referrer Stats
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
-		defer close(geoCh)
-		geographicStats := []GeographicData{}
-		r, err := postgres.FindMany(`
-			SELECT a.country, a.country_code, COUNT(*) as click_count
-			FROM analytics a
-			JOIN links l ON a.short_link = l.short_link
-			WHERE l.user_uid = $1 AND a.click_date BETWEEN $2 AND $3 AND a.country != 'Unknown'
-			GROUP BY a.country, a.country_code
-			ORDER BY COUNT(*) DESC
-		`, userUID, startDate, endDate)
-
+		referrerStats, err := services.FetchDimensionStats(scope, "referrer", "")
 		if err != nil {
-			mu.Lock()
-			*errs = append(*errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
+		if len(referrerStats) > topReferrersLimit {
+			referrerStats = referrerStats[:topReferrersLimit]
+		}
+		topReferrers := make([]ReferrerData, 0, len(referrerStats))
+		for _, dc := range referrerStats {
+			topReferrers = append(topReferrers, ReferrerData{
+				Host:       dc.Value,
+				ClickCount: dc.Clicks,
+			})
+		}
+		analyticsStats.TopReferrers = topReferrers
+	}()
 
-		for r.Next() {
-			var gd GeographicData
-			err := r.Scan(&gd.Country, &gd.CountryCode, &gd.ClickCount)
-			if err != nil {
-				mu.Lock()
-				*errs = append(*errs, err)
-				mu.Unlock()
-				continue
-			}
-			geographicStats = append(geographicStats, gd)
+	// 12. Referrer Source Stats
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		sourceStats, err := services.FetchDimensionStats(scope, "source", "")
+		if err != nil {
+			addErr(err)
+			return
 		}
-		geoCh <- geographicStats
+		analyticsStats.SourceStats = services.DimensionMap(sourceStats)
 	}()
 
 	wg2.Wait()
 
-	//  Collect results
-	analyticsStats.HourlyStats = <-hrCh
-	analyticsStats.DailyStats = <-dyCh
-	analyticsStats.WeeklyStats = <-wkCh
-	analyticsStats.MonthlyStats = <-moCh
-	analyticsStats.OSStats = <-osCh
-	analyticsStats.DeviceStats = <-dvCh
-	analyticsStats.BrowserStats = <-brCh
-	analyticsStats.GeographicData = <-geoCh
-
 	if len(*errs) > 0 {
 		return
 	}
@@ -1,5 +1,7 @@
 package analytics
 
+import "github.com/RishiKendai/sot/pkg/services"
+
 type TopPerformingLink struct {
 	ShortLink     string `json:"short_link"`
 	FullShortLink string `json:"full_short_link"` // Added field for complete short link URL
@@ -28,6 +30,8 @@ type AnalyticsStats struct {
 	DeviceStats    map[string]int64 `json:"device_stats"`
 	BrowserStats   map[string]int64 `json:"browser_stats"`
 	GeographicData []GeographicData `json:"geographic_data"`
+	TopReferrers   []ReferrerData   `json:"top_referrers"`
+	SourceStats    map[string]int64 `json:"source_stats"`
 }
 
 type GeographicData struct {
@@ -36,8 +40,18 @@ type GeographicData struct {
 	ClickCount  int64  `json:"click_count"`
 }
 
+// Number of referrer hosts returned in top_referrers
+const topReferrersLimit = 10
+
+type ReferrerData struct {
+	Host       string `json:"host"`
+	ClickCount int64  `json:"click_count"`
+}
+
 type AnalyticsSummary struct {
-	TopPerformingLinks []TopPerformingLink `json:"top_performing_links"`
-	RecentActivity     []RecentActivity    `json:"recent_activity"`
-	AnalyticsStats     AnalyticsStats      `json:"analytics_stats"`
+	TopPerformingLinks []TopPerformingLink     `json:"top_performing_links"`
+	RecentActivity     []RecentActivity        `json:"recent_activity"`
+	AnalyticsStats     AnalyticsStats          `json:"analytics_stats"`
+	Comparison         *services.Comparison    `json:"comparison,omitempty"`
+	Folders            []services.FolderClicks `json:"folders,omitempty"`
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/RishiKendai/sot/pkg/config/response"
 	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -55,91 +56,74 @@ func Dashboard() gin.HandlerFunc {
 		}
 		uid := uid_raw.(string)
 
-		dashboard = fetchDashboardData(uid)
+		tz, err := services.ResolveTimeZone(c.Query("tz"), uid)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		dashboard, err = fetchDashboardData(uid, tz)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
 
 		response.SendJSON(c, dashboard)
 	}
 }
 
-func fetchDashboardData(uid string) DashboardStruct {
+func fetchDashboardData(uid, timeZone string) (DashboardStruct, error) {
 	var wg sync.WaitGroup
 	var dashboard DashboardStruct
+	var totalsErr error
 
 	// channels for collecting results
 	totalClicksCh := make(chan DashboardCount, 1)
 	recentLinkStatsCh := make(chan Stats, 1)
 
+	scope, err := services.NewStatsScope(uid, "", "", "", timeZone)
+	if err != nil {
+		return dashboard, fmt.Errorf("failed to build stats scope: %w", err)
+	}
+
 	// Get total clicks, QR clicks, direct clicks, and unique visitors
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		query := `SELECT
-					COUNT(*) AS total_clicks,
-					COUNT(*) FILTER (WHERE is_qr_code = TRUE) AS total_qr_clicks,
-					COUNT(*) FILTER (WHERE COALESCE(referrer, '') = '') AS direct_clicks,
-					COUNT(DISTINCT ip_address) AS unique_visitors
-					FROM analytics a
-					JOIN links l ON a.short_link = l.short_link
-					WHERE l.user_uid = $1;
-					`
-		row, _ := postgres.FindOne(query, uid)
-		var count DashboardCount
-		row.Scan(&count.TotalClicks, &count.TotalQrClicks, &count.DirectClicks, &count.UniqueVisitors)
-		totalClicksCh <- count
+		totals, err := services.FetchClickTotals(scope)
+		if err != nil {
+			// The totals are the dashboard's headline, so zeros would be misleading
+			totalsErr = fmt.Errorf("failed to get click totals: %w", err)
+		}
+		totalClicksCh <- DashboardCount{
+			TotalClicks:    totals.TotalClicks,
+			TotalQrClicks:  totals.QRClicks,
+			DirectClicks:   totals.DirectClicks,
+			UniqueVisitors: totals.UniqueVisitors,
+		}
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		query := `WITH recent AS (
-					SELECT   a.*,
-					l.user_uid AS link_user_uid,
-					l.uid AS link_uid,
+		// Most recently clicked link
+		query := `SELECT
+					l.user_uid,
+					l.uid,
 					l.original_link,
+					l.short_link,
 					l.is_custom_backoff,
-					l.created_at AS link_created_at,
+					l.created_at,
 					l.expiry_date,
 					l.password,
 					l.is_flagged,
 					l.updated_at,
 					l.tags,
-					l.deleted AS link_deleted
+					l.deleted
 					FROM analytics a
 					JOIN links l ON a.short_link = l.short_link
 					WHERE l.user_uid = $1
 					ORDER BY a.click_timestamp DESC
-					LIMIT 1
-					),
-					recent_link AS (
-					SELECT short_link FROM recent
-					),
-					clicks AS (
-					SELECT * FROM analytics WHERE short_link = (SELECT short_link FROM recent_link)
-					)
-
-					SELECT
-					recent.link_user_uid,
-					recent.link_uid,
-					recent.original_link,
-					recent.short_link,
-					recent.is_custom_backoff,
-					recent.link_created_at,
-					recent.expiry_date,
-					recent.password,
-					recent.is_flagged,
-					recent.updated_at,
-					recent.tags,
-					recent.deleted,
-
-					(SELECT COUNT(*) FROM clicks) AS total_clicks,
-					COALESCE((SELECT day_of_week FROM clicks GROUP BY day_of_week ORDER BY COUNT(*) DESC LIMIT 1), 7) AS top_day_of_week,
-					COALESCE((SELECT city FROM clicks GROUP BY city ORDER BY COUNT(*) DESC LIMIT 1), 'N/A') AS top_city,
-					COALESCE((SELECT country FROM clicks GROUP BY country ORDER BY COUNT(*) DESC LIMIT 1), 'N/A') AS top_country,
-					COALESCE((SELECT browser FROM clicks GROUP BY browser ORDER BY COUNT(*) DESC LIMIT 1), 'N/A') AS top_browser,
-					COALESCE((SELECT operating_system FROM clicks GROUP BY operating_system ORDER BY COUNT(*) DESC LIMIT 1), 'N/A') AS top_os,
-					COALESCE((SELECT device_type FROM clicks GROUP BY device_type ORDER BY COUNT(*) DESC LIMIT 1), 'N/A') AS top_device
-
-					FROM recent
 					LIMIT 1;
 					`
 		row, err := postgres.FindOne(query, uid)
@@ -179,14 +163,7 @@ func fetchDashboardData(uid string) DashboardStruct {
 			&stats.Is_flagged,
 			&stats.Updated_at,
 			&tagsJSON,
-			&stats.Deleted,
-			&stats.TotalClicks,
-			&stats.Top_day_of_week,
-			&stats.Top_city,
-			&stats.Top_country,
-			&stats.Top_browser,
-			&stats.Top_os,
-			&stats.Top_device); scanErr != nil {
+			&stats.Deleted); scanErr != nil {
 			if scanErr == sql.ErrNoRows {
 				fmt.Println("No rows found")
 				recentLinkStatsCh <- stats
@@ -204,6 +181,44 @@ func fetchDashboardData(uid string) DashboardStruct {
 			stats.Tags = []string{}
 		}
 
+		// Totals and top values of the recent link come from the rollups
+		linkScope := scope
+		linkScope.ShortLink = stats.Short_link
+		totals, err := services.FetchClickTotals(linkScope)
+		if err != nil {
+			fmt.Printf("Error getting link totals: %v\n", err)
+		}
+		stats.TotalClicks = totals.TotalClicks
+
+		weekdays, err := services.FetchWeekdayStats(linkScope)
+		if err != nil {
+			fmt.Printf("Error getting weekday stats: %v\n", err)
+		}
+		var topDayClicks int64
+		for day, clicks := range weekdays {
+			if clicks > topDayClicks || (clicks == topDayClicks && int64(day) < stats.Top_day_of_week) {
+				stats.Top_day_of_week = int64(day)
+				topDayClicks = clicks
+			}
+		}
+
+		for _, top := range []struct {
+			dimension string
+			target    *string
+		}{
+			{"city", &stats.Top_city},
+			{"country", &stats.Top_country},
+			{"browser", &stats.Top_browser},
+			{"os", &stats.Top_os},
+			{"device", &stats.Top_device},
+		} {
+			value, err := services.TopDimensionValue(linkScope, top.dimension, "N/A")
+			if err != nil {
+				fmt.Printf("Error getting top %s: %v\n", top.dimension, err)
+			}
+			*top.target = value
+		}
+
 		// Build full short link URL
 		if stats.Short_link != "" {
 			fullShortLink, err := buildShortLinkURL(stats.User_uid, stats.Short_link)
@@ -221,6 +236,9 @@ func fetchDashboardData(uid string) DashboardStruct {
 	wg.Wait()
 	close(totalClicksCh)
 	close(recentLinkStatsCh)
+	if totalsErr != nil {
+		return dashboard, totalsErr
+	}
 
 	count := <-totalClicksCh
 	dashboard.TotalClicks = count.TotalClicks
@@ -230,5 +248,5 @@ func fetchDashboardData(uid string) DashboardStruct {
 	stats := <-recentLinkStatsCh
 	dashboard.Stats = stats
 
-	return dashboard
+	return dashboard, nil
 }
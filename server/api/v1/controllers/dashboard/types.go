@@ -13,7 +13,7 @@ type DashboardStruct struct {
 	TotalClicks    int64 `json:"total_clicks"`
 	QRCodeClicks   int64 `json:"qr_clicks"`
 	DirectClicks   int64 `json:"direct_clicks"`
-	UniqueVisitors int64 `json:"unique_visitors"`
+	UniqueVisitors int64 `json:"unique_visitors"` // Distinct visitors per link and UTC day, summed
 	Stats          Stats `json:"stats"`
 }
 
@@ -0,0 +1,111 @@
+package folders
+
+import (
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GetFoldersHandler lists the folders of the user with how many links each holds
+func GetFoldersHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		folders, err := services.ListFolders(c.GetString("uid"))
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"folders": folders})
+	}
+}
+
+// GetFolderHandler returns a single folder of the user
+func GetFolderHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		folder, err := services.GetFolder(c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			if err == services.ErrFolderNotFound {
+				response.SendNotFoundError(c, "Folder not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, folder)
+	}
+}
+
+func CreateFolderHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload FolderPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		folder := services.Folder{Name: payload.Name, Description: payload.Description, StartDate: payload.StartDate, EndDate: payload.EndDate}
+		if err := folder.Validate(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		folder, err := services.CreateFolder(c.GetString("uid"), folder)
+		if err != nil {
+			if err == services.ErrFolderExists {
+				response.SendConflictError(c, "A folder with this name already exists")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"folder":  folder,
+			"message": "Folder created",
+		})
+	}
+}
+
+func UpdateFolderHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload FolderPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		folder := services.Folder{ID: c.Param("id"), Name: payload.Name, Description: payload.Description, StartDate: payload.StartDate, EndDate: payload.EndDate}
+		if err := folder.Validate(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		folder, err := services.UpdateFolder(c.GetString("uid"), folder)
+		if err != nil {
+			switch err {
+			case services.ErrFolderNotFound:
+				response.SendNotFoundError(c, "Folder not found")
+			case services.ErrFolderExists:
+				response.SendConflictError(c, "A folder with this name already exists")
+			default:
+				response.SendServerError(c, err)
+			}
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"folder":  folder,
+			"message": "Folder updated",
+		})
+	}
+}
+
+// DeleteFolderHandler deletes a folder, keeping its links outside any folder
+func DeleteFolderHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := services.DeleteFolder(c.GetString("uid"), c.Param("id")); err != nil {
+			if err == services.ErrFolderNotFound {
+				response.SendNotFoundError(c, "Folder not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Folder deleted"})
+	}
+}
@@ -2,87 +2,54 @@ package links
 
 import (
 	"database/sql"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
 )
 
-func fetchLinkAnalytics(shortLink, userUID string) LinkAnalytics {
+// fetchLinkAnalytics returns the analytics of the scope's link, compared with the previous period
+// when compare is set
+func fetchLinkAnalytics(scope services.StatsScope, compare string) (LinkAnalytics, error) {
 	var (
-		la   LinkAnalytics
-		wg   sync.WaitGroup
-		mu   sync.Mutex
-		errs []error
+		la        LinkAnalytics
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		errs      []error
+		shortLink = scope.ShortLink
+		userUID   = scope.UserUID
 	)
-	statCh := make(chan struct {
-		TotalClicks    int
-		UniqueVisitors int
-		DirectClicks   int
-		QR_clicks      int
-	}, 1)
+	// Clicks per country and referrer, to compute their conversion rates
+	var countryClicks, referrerClicks map[string]int64
 	lcsCh := make(chan struct {
 		LastClickedAt    time.Time
 		LastClickBrowser string
 		LastClickDevice  string
 		LastClickFrom    string
 	}, 1)
-	hrCh := make(chan map[int]int64, 1)
-	dyCh := make(chan map[string]int64, 1)
-	wkCh := make(chan map[int]int64, 1)
-	moCh := make(chan map[string]int64, 1)
-	osCh := make(chan map[string]int64, 1)
-	devCh := make(chan map[string]int64, 1)
-	brCh := make(chan map[string]int64, 1)
-	geoCh := make(chan []GeographicData, 1)
 
-	/*
-		ShortLink      string           `json:"short_link"`
-		TotalClicks    int              `json:"total_clicks"`
-		UniqueVisitors int              `json:"unique_visitors"`
-		DirectClicks   int              `json:"direct_clicks"`
-		QR_clicks       int              `json:"qr_clicks"`
+	addErr := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
 
-	*/
+	// Each goroutine fills a different field, so no locking is needed until wg.Wait
 	// 0. Total Clicks & Unique visitors and directClick and qrClicks
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(statCh)
-		var totalClicks, uniqueVisitors, directClicks, qrClicks int
-		r, err := postgres.FindOne(`
-				SELECT 
-				COUNT(*) AS total_clicks,
-				COUNT(DISTINCT ip_address) AS unique_visitors, 
-				COUNT(*) FILTER (WHERE is_qr_code = TRUE) AS qr_clicks,
-				COUNT(*) FILTER (WHERE COALESCE(referrer, '') = '') AS direct_clicks
-				FROM analytics a
-				WHERE a.short_link = $1
-		`, shortLink)
+		totals, err := services.FetchClickTotals(scope)
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
-			return
-		}
-		err = r.Scan(&totalClicks, &uniqueVisitors, &qrClicks, &directClicks)
-		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		statCh <- struct {
-			TotalClicks    int
-			UniqueVisitors int
-			DirectClicks   int
-			QR_clicks      int
-		}{
-			TotalClicks:    totalClicks,
-			UniqueVisitors: uniqueVisitors,
-			DirectClicks:   directClicks,
-			QR_clicks:      qrClicks,
-		}
+		la.TotalClicks = int(totals.TotalClicks)
+		la.UniqueVisitors = int(totals.UniqueVisitors)
+		la.DirectClicks = int(totals.DirectClicks)
+		la.QR_clicks = int(totals.QRClicks)
 	}()
 
 	wg.Add(1)
@@ -157,278 +124,209 @@ func fetchLinkAnalytics(shortLink, userUID string) LinkAnalytics {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(hrCh)
-		hourlyStats := make(map[int]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.hour_of_day, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1
-			GROUP BY a.hour_of_day
-			ORDER BY a.hour_of_day ASC
-	`, shortLink)
+		hourlyStats, err := services.FetchHourlyStats(scope)
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var hour int
-			var count int64
-			err := r.Scan(&hour, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			hourlyStats[hour] = count
-		}
-		hrCh <- hourlyStats
+		la.HourlyStats = hourlyStats
 	}()
 
 	// 2. Daily Clicks
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(dyCh)
-		dailyStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.click_date, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1
-			GROUP BY a.click_date
-			ORDER BY a.click_date ASC
-	`, shortLink)
+		dailyStats, err := services.FetchDailyStats(scope)
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var date time.Time
-			var count int64
-			err := r.Scan(&date, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			dailyStats[date.Format("2006-01-02")] = count
-		}
-		dyCh <- dailyStats
+		la.DailyStats = dailyStats
 	}()
 
 	// 3. Weekly Clicks
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(wkCh)
-		weeklyStats := make(map[int]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.day_of_week, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1
-			GROUP BY a.day_of_week
-			ORDER BY a.day_of_week ASC
-	`, shortLink)
+		weeklyStats, err := services.FetchWeekdayStats(scope)
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var day int
-			var count int64
-			err := r.Scan(&day, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			weeklyStats[day] = count
-		}
-		wkCh <- weeklyStats
+		la.WeeklyStats = weeklyStats
 	}()
 
 	// 4. Monthly Clicks
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(moCh)
-		monthlyStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.month, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1
-			GROUP BY a.month
-			ORDER BY a.month ASC
-	`, shortLink)
+		monthlyStats, err := services.FetchMonthlyStats(scope)
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var month string
-			var count int64
-			err := r.Scan(&month, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			monthlyStats[month] = count
-		}
-		moCh <- monthlyStats
+		la.MonthlyStats = monthlyStats
 	}()
 
 	// 5. OS Stats
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(osCh)
-		osStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.operating_system, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1 AND a.operating_system IS NOT NULL AND a.operating_system != 'Unknown'
-			GROUP BY a.operating_system
-			ORDER BY a.operating_system ASC
-	`, shortLink)
+		osStats, err := services.FetchDimensionStats(scope, "os", "Unknown", "")
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var os string
-			var count int64
-			err := r.Scan(&os, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			osStats[os] = count
-		}
-		osCh <- osStats
+		la.OSStats = services.DimensionMap(osStats)
 	}()
 
 	// 6. Device Stats
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(devCh)
-		devStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.device_type, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1
-			GROUP BY a.device_type
-			ORDER BY a.device_type ASC
-	`, shortLink)
+		devStats, err := services.FetchDimensionStats(scope, "device", "")
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var device string
-			var count int64
-			err := r.Scan(&device, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			devStats[device] = count
-		}
-		devCh <- devStats
+		la.DeviceStats = services.DimensionMap(devStats)
 	}()
 
 	// 7. Browser Stats
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(brCh)
-		brStats := make(map[string]int64)
-		r, err := postgres.FindMany(`
-			SELECT a.browser, COUNT(*) FROM analytics a
-			WHERE a.short_link = $1
-			GROUP BY a.browser
-			ORDER BY a.browser ASC
-	`, shortLink)
+		brStats, err := services.FetchDimensionStats(scope, "browser", "")
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var browser string
-			var count int64
-			err := r.Scan(&browser, &count)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			brStats[browser] = count
+		la.BrowserStats = services.DimensionMap(brStats)
+	}()
+
+	// 8. Geographic Stats
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		countryStats, err := services.FetchDimensionStats(scope, "country", "Unknown", "")
+		if err != nil {
+			addErr(err)
+			return
+		}
+		countryClicks = services.DimensionMap(countryStats)
+		geoStats := make([]GeographicData, 0, len(countryStats))
+		for _, dc := range countryStats {
+			geoStats = append(geoStats, GeographicData{
+				Country:     dc.Value,
+				CountryCode: dc.Code,
+				ClickCount:  dc.Clicks,
+			})
 		}
-		brCh <- brStats
+		la.GeographicData = geoStats
 	}()
 
-	// 9. Geographic Stats
+	// 9. Referrer Stats
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(geoCh)
-		geoStats := make([]GeographicData, 0)
-		r, err := postgres.FindMany(`
-			SELECT a.country, a.country_code, COUNT(*) as click_count
-			FROM analytics a
-			WHERE a.short_link = $1 AND a.country IS NOT NULL AND a.country <> 'Unknown' AND a.country <> ''
-			GROUP BY a.country , a.country_code
-			ORDER BY COUNT(*) DESC
-	`, shortLink)
+		referrerStats, err := services.FetchDimensionStats(scope, "referrer", "")
 		if err != nil {
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
+			addErr(err)
 			return
 		}
-		defer r.Close()
-		for r.Next() {
-			var gd GeographicData
-			err := r.Scan(&gd.Country, &gd.CountryCode, &gd.ClickCount)
-			if err != nil {
-				mu.Lock()
-				errs = append(errs, err)
-				mu.Unlock()
-				continue
-			}
-			geoStats = append(geoStats, gd)
+		referrerClicks = services.DimensionMap(referrerStats)
+		if len(referrerStats) > topReferrersLimit {
+			referrerStats = referrerStats[:topReferrersLimit]
+		}
+		topReferrers := make([]ReferrerData, 0, len(referrerStats))
+		for _, dc := range referrerStats {
+			topReferrers = append(topReferrers, ReferrerData{
+				Host:       dc.Value,
+				ClickCount: dc.Clicks,
+			})
+		}
+		la.TopReferrers = topReferrers
+	}()
+
+	// 10. Referrer Source Stats
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sourceStats, err := services.FetchDimensionStats(scope, "source", "")
+		if err != nil {
+			addErr(err)
+			return
 		}
-		geoCh <- geoStats
+		la.SourceStats = services.DimensionMap(sourceStats)
 	}()
 
+	// 11. Conversions
+	var countryConversions, referrerConversions []services.ConversionCount
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		totals, err := services.FetchConversionTotals(scope)
+		if err != nil {
+			addErr(err)
+			return
+		}
+		la.Conversions = totals.Conversions
+		la.Revenue = totals.Revenue
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stats, err := services.FetchConversionStats(scope, "country")
+		if err != nil {
+			addErr(err)
+			return
+		}
+		countryConversions = stats
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stats, err := services.FetchConversionStats(scope, "referrer")
+		if err != nil {
+			addErr(err)
+			return
+		}
+		referrerConversions = stats
+	}()
+
+	// 12. Baseline brought from the shortener the link was imported from
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		imported, err := services.FetchImportBaseline(userUID, shortLink)
+		if err != nil {
+			addErr(err)
+			return
+		}
+		la.Imported = imported
+	}()
+
+	// 13. Comparison window
+	if compare != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comparison, err := services.FetchComparison(scope, compare)
+			if err != nil {
+				addErr(err)
+				return
+			}
+			la.Comparison = comparison
+		}()
+	}
+
 	wg.Wait()
 
 	// Collect results
 	la.ShortLink = shortLink
+	la.ConversionRate = services.ConversionRate(la.Conversions, int64(la.TotalClicks))
+	la.CountryConversions = conversionData(countryConversions, countryClicks)
+	la.ReferrerConversions = conversionData(referrerConversions, referrerClicks)
 
 	// Build full short link URL
 	fullShortLink, err := buildShortLinkURL(userUID, shortLink)
@@ -439,31 +337,31 @@ func fetchLinkAnalytics(shortLink, userUID string) LinkAnalytics {
 		la.FullShortLink = fullShortLink
 	}
 
-	if val, ok := <-statCh; ok {
-		la.TotalClicks = val.TotalClicks
-		la.UniqueVisitors = val.UniqueVisitors
-		la.DirectClicks = val.DirectClicks
-		la.QR_clicks = val.QR_clicks
-	}
 	if val, ok := <-lcsCh; ok {
 		la.LastClickedAt = val.LastClickedAt
 		la.LastClickBrowser = val.LastClickBrowser
 		la.LastClickDevice = val.LastClickDevice
 		la.LastClickFrom = val.LastClickFrom
 	}
-	la.HourlyStats = <-hrCh
-	la.DailyStats = <-dyCh
-	la.WeeklyStats = <-wkCh
-	la.MonthlyStats = <-moCh
-	la.OSStats = <-osCh
-	la.DeviceStats = <-devCh
-	la.BrowserStats = <-brCh
-	la.GeographicData = <-geoCh
 
 	if len(errs) > 0 {
-		// fmt.Println("Errors in fetchLinkAnalytics: ", errs) // Removed fmt import
-		return la
+		return la, fmt.Errorf("multiple errors: %v", errs)
 	}
+	return la, nil
+}
 
-	return la
+// conversionData pairs the conversions of each country or referrer with its clicks
+func conversionData(stats []services.ConversionCount, clicks map[string]int64) []ConversionData {
+	data := make([]ConversionData, 0, len(stats))
+	for _, cc := range stats {
+		data = append(data, ConversionData{
+			Value:          cc.Value,
+			Code:           cc.Code,
+			Clicks:         clicks[cc.Value],
+			Conversions:    cc.Conversions,
+			ConversionRate: services.ConversionRate(cc.Conversions, clicks[cc.Value]),
+			Revenue:        cc.Revenue,
+		})
+	}
+	return data
 }
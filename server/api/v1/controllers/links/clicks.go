@@ -0,0 +1,85 @@
+package links
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GetLinkClicksHandler returns the individual click events of a link, newest first
+func GetLinkClicksHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		uid := c.GetString("uid")
+
+		row, err := postgres.FindOne("SELECT short_link FROM links WHERE short_link = $1 AND user_uid = $2", id, uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		var shortLink string
+		if err := row.Scan(&shortLink); err != nil {
+			if err == sql.ErrNoRows {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		filter, err := clickFilterFromQuery(c, uid)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		filter.ShortLink = shortLink
+
+		page, err := services.FetchClicks(filter)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, page)
+	}
+}
+
+// clickFilterFromQuery reads the click event filters from the query string
+func clickFilterFromQuery(c *gin.Context, uid string) (services.ClickFilter, error) {
+	tz, err := services.ResolveTimeZone(c.Query("tz"), uid)
+	if err != nil {
+		return services.ClickFilter{}, err
+	}
+
+	filter := services.ClickFilter{
+		UserUID:      uid,
+		StartDate:    c.Query("start_date"),
+		EndDate:      c.Query("end_date"),
+		TimeZone:     tz,
+		Country:      c.Query("country"),
+		Device:       c.Query("device"),
+		Browser:      c.Query("browser"),
+		OS:           c.Query("os"),
+		ReferrerHost: c.Query("referrer"),
+		Cursor:       c.Query("cursor"),
+	}
+	if qr := c.Query("qr"); qr != "" {
+		isQR, err := strconv.ParseBool(qr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid qr filter: %s", qr)
+		}
+		filter.QR = &isQR
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", limit)
+		}
+		filter.Limit = n
+	}
+	return filter, filter.Validate()
+}
@@ -0,0 +1,127 @@
+package links
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/RishiKendai/sot/service/counter"
+)
+
+// ErrAliasInUse is returned when the custom back half of a new link is taken
+var ErrAliasInUse = errors.New("alias is already in use")
+
+// CreatedLink is a link stored by CreateLink. FlagReason explains why an unsafe URL was flagged.
+type CreatedLink struct {
+	Uid         string
+	ShortLink   string
+	OriginalURL string
+	ExpiryDate  time.Time
+	IsFlagged   bool
+	FlagReason  string
+}
+
+// CreateLink stores a link of the user, generating a back half unless a custom one is given.
+// URLs failing the safety check are stored flagged rather than rejected.
+func CreateLink(uid string, payload CreateShortURLPayload) (CreatedLink, error) {
+	if payload.Original_url == "" {
+		return CreatedLink{}, errors.New("URL is required")
+	}
+	if err := services.ValidateDestination(payload.Original_url); err != nil {
+		return CreatedLink{}, err
+	}
+
+	// Check if URL is malicious or wrong site
+	isSafe, reason := CheckURLSafety(payload.Original_url)
+	if !isSafe {
+		payload.Is_flagged = true
+	}
+
+	// Use custom backoff (alias) if provided, else generate
+	sc := payload.Custom_backoff
+	isCustomBackoff := false
+	if sc == "" {
+		counterVal := counter.NextCounter()
+		sc = encodeBase62Fixed(counterVal, 7)
+	} else {
+		isCustomBackoff = true
+	}
+
+	folderID, err := services.LinkFolder(uid, payload.Folder_id)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+	notes, err := services.CleanLinkNotes(payload.Notes)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	isAvailable, err := IsAliasAvailable(sc, "")
+	if err != nil {
+		return CreatedLink{}, err
+	}
+	if !isAvailable {
+		return CreatedLink{}, ErrAliasInUse
+	}
+
+	expiry := payload.Expiry_date
+	if expiry.IsZero() {
+		expiry = time.Now().Add(30 * 24 * time.Hour).UTC() // default 30 days, force UTC
+	} else {
+		expiry = expiry.UTC()
+	}
+	tags := payload.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	// The alias may have been taken since the availability check
+	row, err := postgres.FindOne(`
+		INSERT INTO links (user_uid, original_link, short_link, expiry_date, password, is_flagged, is_custom_backoff, tags, folder_id, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
+		ON CONFLICT (short_link) DO NOTHING
+		RETURNING uid
+	`, uid, payload.Original_url, sc, expiry, payload.Password, payload.Is_flagged, isCustomBackoff, tags, folderID, notes)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+	var linkUID string
+	if err := row.Scan(&linkUID); err != nil {
+		if err == sql.ErrNoRows {
+			return CreatedLink{}, ErrAliasInUse
+		}
+		return CreatedLink{}, err
+	}
+
+	// Cache the link until it expires, or 5 hours for links already expired
+	redisExpiry := time.Hour * 5
+	if diff := time.Until(expiry); diff > 0 {
+		redisExpiry = diff
+	}
+	rdb.RC.Set(sc, payload.Original_url, &redisExpiry)
+	captureMetadata(sc, payload.Original_url)
+
+	services.EmitLinkEvent(uid, services.EventLinkCreated, services.LinkEvent{
+		ShortLink:   sc,
+		OriginalURL: payload.Original_url,
+		ExpiryDate:  &expiry,
+		Tags:        payload.Tags,
+	})
+
+	return CreatedLink{
+		Uid:         linkUID,
+		ShortLink:   sc,
+		OriginalURL: payload.Original_url,
+		ExpiryDate:  expiry,
+		IsFlagged:   payload.Is_flagged,
+		FlagReason:  reason,
+	}, nil
+}
+
+// ShortLinkURL returns the full short URL of a link of the user, honouring their subdomain settings
+func ShortLinkURL(userUID, shortLink string) (string, error) {
+	return buildShortLinkURL(userUID, shortLink)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/RishiKendai/sot/pkg/config/env"
@@ -26,45 +27,11 @@ func CreateShortURLHandler() gin.HandlerFunc {
 			response.SendBadRequestError(c, "Invalid request body")
 			return
 		}
-		// Ensure expiry_date is always UTC
-		if !payload.Expiry_date.IsZero() {
-			payload.Expiry_date = payload.Expiry_date.UTC()
-		}
 		if payload.Original_url == "" {
 			response.SendBadRequestError(c, "URL is required")
 			return
 		}
 
-		// Check if URL is malicious or wrong site
-		isSafe, _ := CheckURLSafety(payload.Original_url)
-		if !isSafe {
-			payload.Is_flagged = true
-			// Optionally, you can return an error here if you want to block malicious URLs
-			// response.SendBadRequestError(c, "URL is flagged")
-			// return
-		}
-
-		// Use custom backoff (alias) if provided, else generate
-		sc := payload.Custom_backoff
-		isCustomBackoff := false
-		if sc == "" {
-			counterVal := counter.NextCounter()
-			sc = encodeBase62Fixed(counterVal, 7)
-		} else {
-			isCustomBackoff = true
-		}
-
-		isAvailable, err := IsAliasAvailable(sc, "")
-		if err != nil {
-			response.SendServerError(c, err)
-			return
-		}
-
-		if !isAvailable {
-			response.SendConflictError(c, "Alias is already in use")
-			return
-		}
-
 		uidRaw, exists := c.Get("uid")
 		if !exists {
 			response.SendServerError(c, nil)
@@ -72,32 +39,30 @@ func CreateShortURLHandler() gin.HandlerFunc {
 		}
 		uid := uidRaw.(string)
 
-		// Prepare query and args for optional fields
-		query := "INSERT INTO links (user_uid, original_link, short_link, expiry_date, password, is_flagged, is_custom_backoff, tags) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
-		expiry := payload.Expiry_date
-		if expiry.IsZero() {
-			expiry = time.Now().Add(30 * 24 * time.Hour).UTC() // default 30 days, force UTC
-		} else {
-			expiry = expiry.UTC()
+		if _, err := services.CleanLinkNotes(payload.Notes); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
 		}
-		_, err = postgres.InsertOne(query, uid, payload.Original_url, sc, expiry, payload.Password, payload.Is_flagged, isCustomBackoff, payload.Tags)
+		link, err := CreateLink(uid, payload)
 		if err != nil {
+			if err == ErrAliasInUse {
+				response.SendConflictError(c, "Alias is already in use")
+				return
+			}
+			if err == services.ErrFolderNotFound {
+				response.SendBadRequestError(c, "Folder not found")
+				return
+			}
+			if err == services.ErrInvalidDestination {
+				response.SendBadRequestError(c, err.Error())
+				return
+			}
 			response.SendServerError(c, err)
 			return
 		}
 
-		// Set Redis with custom expiry if provided, else default 5 hours
-		redisExpiry := time.Hour * 5
-		if !expiry.IsZero() {
-			diff := time.Until(expiry)
-			if diff > 0 {
-				redisExpiry = diff
-			}
-		}
-		rdb.RC.Set(sc, payload.Original_url, &redisExpiry)
-
 		response.SendJSON(c, bson.M{
-			"short_code": sc,
+			"short_code": link.ShortLink,
 		})
 	}
 }
@@ -125,6 +90,12 @@ func QuickShortURL(uid, l string) (string, error) {
 	// Set Redis with default expiry of 5 hours
 	duration := time.Hour * 5
 	rdb.RC.Set(sc, l, &duration)
+
+	services.EmitLinkEvent(uid, services.EventLinkCreated, services.LinkEvent{
+		ShortLink:   sc,
+		OriginalURL: l,
+		ExpiryDate:  &exp_date,
+	})
 	return sc, nil
 }
 
@@ -146,6 +117,17 @@ func CheckAliasAvailabilityHandler() gin.HandlerFunc {
 	}
 }
 
+// linkColumns are the links columns scanned into a Link, in scan order
+const linkColumns = "user_uid, uid, original_link, short_link, is_custom_backoff, created_at, expiry_date, password, is_flagged, updated_at, tags, deleted, folder_id, title, description, notes"
+
+// redirectLinkQuery selects a link to forward to, whether it has retargeting pixels and whether
+// its account tracks conversions
+const redirectLinkQuery = `SELECT l.user_uid, l.uid, l.original_link, l.short_link, l.is_custom_backoff, l.created_at,
+	l.expiry_date, l.password, l.is_flagged, l.updated_at, l.tags, l.deleted,
+	EXISTS (SELECT 1 FROM link_pixels lp WHERE lp.link_uid = l.uid),
+	COALESCE((SELECT u.conversion_tracking FROM users u WHERE u.uid = l.user_uid), FALSE)
+	FROM links l`
+
 func RedirectHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sot := c.Param("sot") // or "short" depending on your route
@@ -159,13 +141,14 @@ func RedirectHandler() gin.HandlerFunc {
 		// Get link details from database
 		var link Link
 		var tagsJSON []byte
-		sqlRow, err := postgres.FindOne("SELECT * FROM links WHERE short_link = $1", sot)
+		var hasPixels, trackConversions bool
+		sqlRow, err := postgres.FindOne(redirectLinkQuery+" WHERE l.short_link = $1", sot)
 		if err != nil {
 			fmt.Println("Error:", err)
 			response.SendServerError(c, err)
 			return
 		}
-		err = sqlRow.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted)
+		err = sqlRow.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted, &hasPixels, &trackConversions)
 		if err != nil {
 			fmt.Println("Error not found: ", err)
 			if err == sql.ErrNoRows {
@@ -202,12 +185,16 @@ func RedirectHandler() gin.HandlerFunc {
 
 		// Track analytics with QR code information
 		referrer := c.Request.Header.Get("Referer")
-		services.PushAnalytics(sot, ip, ua, isQR, referrer)
+		destination := link.Original_url
+		dnt := doNotTrack(c)
+		clickID, err := services.PushAnalytics(link.User_uid, sot, ip, ua, isQR, referrer, dnt)
+		withClickID := err == nil && !dnt && trackConversions
+		if withClickID {
+			// The destination reports conversions against this click
+			destination = services.AppendClickID(destination, clickID)
+		}
 
-		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", 5*60))
-		c.Header("Content-Security-Policy", "referer always;")
-		c.Header("Referrer-Policy", "unsafe-url")
-		c.Redirect(http.StatusMovedPermanently, link.Original_url)
+		forward(c, link.Uid, hasPixels, destination, withClickID)
 		// c.Redirect(http.StatusPermanentRedirect, link.Original_url)
 		// c.Redirect(http.StatusTemporaryRedirect, link.Original_url)
 	}
@@ -234,31 +221,46 @@ func GetLinksHandler() gin.HandlerFunc {
 		}
 		offset := (page - 1) * pageSize
 
-		// Get total count
-		var total int
-		totalRow, err := postgres.FindOne("SELECT COUNT(*) FROM links WHERE user_uid = $1 AND deleted = false", uid)
+		// Optionally only the links of a folder or with some tags
+		filter, err := services.ParseLinkFilter(c.Request.URL.Query())
 		if err != nil {
-			response.SendServerError(c, err)
+			response.SendBadRequestError(c, err.Error())
 			return
 		}
-		err = totalRow.Scan(&total)
-		if err != nil {
-			response.SendServerError(c, err)
-			return
+		listing := filter.Build(uid)
+		where, args := listing.Where, listing.Args
+
+		// Get total count, unless paging by cursor
+		var total *int
+		if filter.HasCursor() {
+			page, offset = 0, 0
+		} else {
+			totalRow, err := postgres.FindOne("SELECT COUNT(*) FROM links WHERE "+where, args...)
+			if err != nil {
+				response.SendServerError(c, err)
+				return
+			}
+			total = new(int)
+			if err := totalRow.Scan(total); err != nil {
+				response.SendServerError(c, err)
+				return
+			}
 		}
 
 		// Get paginated links
-		query := "SELECT * FROM links WHERE user_uid = $1 AND deleted = false ORDER BY created_at DESC LIMIT $2 OFFSET $3"
-		sqlRows, err := postgres.FindMany(query, uid, pageSize, offset)
+		query := fmt.Sprintf("SELECT %s, %s FROM links WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d", linkColumns, listing.SortKey, where, listing.OrderBy, len(args)+1, len(args)+2)
+		sqlRows, err := postgres.FindMany(query, append(args, pageSize+1, offset)...)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
 		var links []Link
+		var keys []services.LinkKey
 		for sqlRows.Next() {
 			var link Link
+			var key services.LinkKey
 			var tagsJSON []byte
-			err = sqlRows.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted)
+			err = sqlRows.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted, &link.Folder_id, &link.Title, &link.Description, &link.Notes, &key.Sort)
 			if err != nil {
 				response.SendServerError(c, err)
 				return
@@ -275,24 +277,29 @@ func GetLinksHandler() gin.HandlerFunc {
 			} else {
 				link.Tags = []string{}
 			}
+			key.UID = link.Uid
 			links = append(links, link)
+			keys = append(keys, key)
 		}
-
-		// Build full short link URLs efficiently in batch
-		if err := buildShortLinkURLsBatch(links); err != nil {
+		if err = sqlRows.Err(); err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+		links, next, prev := services.PageLinks(filter, links, keys, pageSize, offset > 0)
 
-		if err = sqlRows.Err(); err != nil {
+		// Build full short link URLs efficiently in batch
+		if err := buildShortLinkURLsBatch(links); err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+
 		paginated := PaginatedLinksResponse{
-			Links:    links,
-			Total:    total,
-			Page:     page,
-			PageSize: pageSize,
+			Links:      links,
+			Total:      total,
+			Page:       page,
+			PageSize:   pageSize,
+			NextCursor: next,
+			PrevCursor: prev,
 		}
 		response.SendJSON(c, paginated)
 	}
@@ -301,7 +308,7 @@ func GetLinksHandler() gin.HandlerFunc {
 func SearchLinksHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uid := c.GetString("uid")
-		query := c.Query("q")
+		query := strings.TrimSpace(c.Query("q"))
 		if query == "" {
 			response.SendBadRequestError(c, "Missing search query")
 			return
@@ -324,33 +331,47 @@ func SearchLinksHandler() gin.HandlerFunc {
 		}
 		offset := (page - 1) * pageSize
 
-		// Get total count for search
-		totalQuery := `SELECT COUNT(*) FROM links WHERE user_uid = $1 AND (short_link ILIKE $2 OR original_link ILIKE $2) AND deleted = false`
-		var total int
-		totalRow, err := postgres.FindOne(totalQuery, uid, "%"+query+"%")
+		// Optionally only the links of a folder or with some tags. The filter reads the query too.
+		filter, err := services.ParseLinkFilter(c.Request.URL.Query())
 		if err != nil {
-			response.SendServerError(c, err)
+			response.SendBadRequestError(c, err.Error())
 			return
 		}
-		err = totalRow.Scan(&total)
-		if err != nil {
-			response.SendServerError(c, err)
-			return
+		listing := filter.Build(uid)
+		where, args := listing.Where, listing.Args
+
+		// Get total count for search, unless paging by cursor
+		var total *int
+		if filter.HasCursor() {
+			page, offset = 0, 0
+		} else {
+			totalRow, err := postgres.FindOne("SELECT COUNT(*) FROM links WHERE "+where, args...)
+			if err != nil {
+				response.SendServerError(c, err)
+				return
+			}
+			total = new(int)
+			if err := totalRow.Scan(total); err != nil {
+				response.SendServerError(c, err)
+				return
+			}
 		}
 
 		// Get paginated search results
-		searchQuery := `SELECT * FROM links WHERE user_uid = $1 AND (short_link ILIKE $2 OR original_link ILIKE $2) AND deleted = false ORDER BY created_at DESC LIMIT $3 OFFSET $4`
-		sqlRows, err := postgres.FindMany(searchQuery, uid, "%"+query+"%", pageSize, offset)
+		searchQuery := fmt.Sprintf("SELECT %s, %s FROM links WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d", linkColumns, listing.SortKey, where, listing.OrderBy, len(args)+1, len(args)+2)
+		sqlRows, err := postgres.FindMany(searchQuery, append(args, pageSize+1, offset)...)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
 		defer sqlRows.Close()
 		var links []Link
+		var keys []services.LinkKey
 		for sqlRows.Next() {
 			var link Link
+			var key services.LinkKey
 			var tagsJSON []byte
-			err = sqlRows.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted)
+			err = sqlRows.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted, &link.Folder_id, &link.Title, &link.Description, &link.Notes, &key.Sort)
 			if err != nil {
 				response.SendServerError(c, err)
 				return
@@ -365,24 +386,45 @@ func SearchLinksHandler() gin.HandlerFunc {
 			} else {
 				link.Tags = []string{}
 			}
+			key.UID = link.Uid
 			links = append(links, link)
+			keys = append(keys, key)
+		}
+		if err = sqlRows.Err(); err != nil {
+			response.SendServerError(c, err)
+			return
 		}
+		links, next, prev := services.PageLinks(filter, links, keys, pageSize, offset > 0)
 
-		// Build full short link URLs efficiently in batch
-		if err := buildShortLinkURLsBatch(links); err != nil {
+		// Highlight why each link matched
+		uids := make([]string, len(links))
+		for i, l := range links {
+			uids[i] = l.Uid
+		}
+		matches, err := services.FetchLinkMatches(uid, query, uids)
+		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+		for i := range links {
+			if m, ok := matches[links[i].Uid]; ok {
+				links[i].Match = &m
+			}
+		}
 
-		if err = sqlRows.Err(); err != nil {
+		// Build full short link URLs efficiently in batch
+		if err := buildShortLinkURLsBatch(links); err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+
 		paginated := PaginatedLinksResponse{
-			Links:    links,
-			Total:    total,
-			Page:     page,
-			PageSize: pageSize,
+			Links:      links,
+			Total:      total,
+			Page:       page,
+			PageSize:   pageSize,
+			NextCursor: next,
+			PrevCursor: prev,
 		}
 		response.SendJSON(c, paginated)
 	}
@@ -412,12 +454,12 @@ func GetLinkHandler() gin.HandlerFunc {
 		// Get link with user ownership check
 		var link Link
 		var tagsJSON []byte
-		sqlRow, err := postgres.FindOne("SELECT * FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", id, uid)
+		sqlRow, err := postgres.FindOne("SELECT "+linkColumns+" FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", id, uid)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
-		err = sqlRow.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted)
+		err = sqlRow.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted, &link.Folder_id, &link.Title, &link.Description, &link.Notes)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				response.SendNotFoundError(c, "Link not found")
@@ -461,6 +503,10 @@ func UpdateLinkHandler() gin.HandlerFunc {
 			response.SendBadRequestError(c, "Invalid request body")
 			return
 		}
+		if err := services.ValidateDestination(payload.Original_url); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
 		// Ensure expiry_date is always UTC
 		if !payload.Expiry_date.IsZero() {
 			payload.Expiry_date = payload.Expiry_date.UTC()
@@ -472,12 +518,12 @@ func UpdateLinkHandler() gin.HandlerFunc {
 		// First, check if the link exists and belongs to the user
 		var existingLink Link
 		var tagsJSON []byte
-		sqlRow, err := postgres.FindOne("SELECT * FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", shortLinkID, uid)
+		sqlRow, err := postgres.FindOne("SELECT "+linkColumns+" FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", shortLinkID, uid)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
-		err = sqlRow.Scan(&existingLink.User_uid, &existingLink.Uid, &existingLink.Original_url, &existingLink.Short_link, &existingLink.Is_custom_backoff, &existingLink.Created_at, &existingLink.Expiry_date, &existingLink.Password, &existingLink.Is_flagged, &existingLink.Updated_at, &tagsJSON, &existingLink.Deleted)
+		err = sqlRow.Scan(&existingLink.User_uid, &existingLink.Uid, &existingLink.Original_url, &existingLink.Short_link, &existingLink.Is_custom_backoff, &existingLink.Created_at, &existingLink.Expiry_date, &existingLink.Password, &existingLink.Is_flagged, &existingLink.Updated_at, &tagsJSON, &existingLink.Deleted, &existingLink.Folder_id, &existingLink.Title, &existingLink.Description, &existingLink.Notes)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				response.SendNotFoundError(c, "Link not found")
@@ -536,13 +582,67 @@ func UpdateLinkHandler() gin.HandlerFunc {
 			expiry = expiry.UTC()
 		}
 
-		// Update the link in database
-		query := "UPDATE links SET original_link = $1, short_link = $2, expiry_date = $3, password = $4, is_flagged = $5, is_custom_backoff = $6, updated_at = NOW(), tags = $7 WHERE short_link = $8 AND user_uid = $9"
-		_, err = postgres.UpdateOne(query, payload.Original_url, newShortLink, expiry, payload.Password, payload.Is_flagged, isCustomBackoff, payload.Tags, existingLink.Short_link, uid)
+		folderID, err := services.LinkFolder(uid, payload.Folder_id)
+		if err != nil {
+			if err == services.ErrFolderNotFound {
+				response.SendBadRequestError(c, "Folder not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		notes, err := services.CleanLinkNotes(payload.Notes)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		// The link and its revision are saved together
+		before := services.LinkState{
+			OriginalURL:     existingLink.Original_url,
+			ShortLink:       existingLink.Short_link,
+			ExpiryDate:      existingLink.Expiry_date,
+			Tags:            existingLink.Tags,
+			Password:        existingLink.Password,
+			IsFlagged:       existingLink.Is_flagged,
+			IsCustomBackoff: existingLink.Is_custom_backoff,
+		}
+		after := services.LinkState{
+			OriginalURL:     payload.Original_url,
+			ShortLink:       newShortLink,
+			ExpiryDate:      expiry,
+			Tags:            payload.Tags,
+			Password:        payload.Password,
+			IsFlagged:       payload.Is_flagged,
+			IsCustomBackoff: isCustomBackoff,
+		}
+		tx, err := postgres.Begin()
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		defer tx.Rollback()
+
+		// Update the link in database. A folder or notes left out are kept, the captured page
+		// metadata only while the destination stays the same.
+		query := `UPDATE links SET original_link = $1, short_link = $2, expiry_date = $3, password = $4, is_flagged = $5, is_custom_backoff = $6, updated_at = NOW(), tags = $7,
+			folder_id = CASE WHEN $12::boolean THEN $10 ELSE folder_id END,
+			notes = CASE WHEN $11::text IS NULL THEN notes ELSE NULLIF($11, '') END,
+			title = CASE WHEN original_link = $1 THEN title END, description = CASE WHEN original_link = $1 THEN description END
+			WHERE short_link = $8 AND user_uid = $9`
+		_, err = tx.Exec(query, payload.Original_url, newShortLink, expiry, payload.Password, payload.Is_flagged, isCustomBackoff, payload.Tags, existingLink.Short_link, uid, folderID, notes, payload.Folder_id != nil)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+		if err := services.RecordLinkRevision(tx, uid, existingLink.Uid, before, after, c.GetString("email"), services.RevisionDashboard); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
 
 		// Update cache
 		redisExpiry := time.Hour * 5
@@ -564,6 +664,20 @@ func UpdateLinkHandler() gin.HandlerFunc {
 
 		// Set new entry in Redis
 		rdb.RC.Set(newShortLink, payload.Original_url, &redisExpiry)
+		if payload.Original_url != existingLink.Original_url {
+			captureMetadata(newShortLink, payload.Original_url)
+		}
+
+		event := services.LinkEvent{
+			ShortLink:   newShortLink,
+			OriginalURL: payload.Original_url,
+			ExpiryDate:  &expiry,
+			Tags:        payload.Tags,
+		}
+		if newShortLink != existingLink.Short_link {
+			event.PreviousShortLink = existingLink.Short_link
+		}
+		services.EmitLinkEvent(uid, services.EventLinkUpdated, event)
 
 		response.SendJSON(c, bson.M{
 			"short_link": newShortLink,
@@ -594,21 +708,17 @@ func DeleteLinkHandler() gin.HandlerFunc {
 			return
 		}
 
-		// Soft delete: set deleted=true
-		_, err = postgres.UpdateOne("UPDATE links SET deleted = TRUE WHERE uid = $1 AND user_uid = $2", shortLinkID, uid)
-		if err != nil {
-			response.SendServerError(c, err)
-			return
-		}
-		// Soft delete: set deleted=true in analytics too
-		_, err = postgres.UpdateOne("UPDATE analytics SET deleted = TRUE WHERE short_link = $1 AND user_uid = $2", sl, uid)
+		// Soft delete: the link moves to the trash until it is restored or purged
+		_, err = postgres.UpdateOne("UPDATE links SET deleted = TRUE, deleted_at = $3 WHERE uid = $1 AND user_uid = $2", shortLinkID, uid, time.Now().UTC())
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+		services.EmitLinkEvent(uid, services.EventLinkDeleted, services.LinkEvent{ShortLink: sl})
 
 		// Remove from Redis cache
-		rdb.RC.Del(shortLinkID)
+		rdb.RC.Del(sl)
+		rdb.RC.Del("links:" + sl)
 
 		response.SendJSON(c, bson.M{
 			"message": "Link deleted successfully",
@@ -647,12 +757,13 @@ func VerifyPasswordHandler() gin.HandlerFunc {
 		// Get link details from database
 		var link Link
 		var tagsJSON []byte
-		sqlRow, err := postgres.FindOne("SELECT * FROM links WHERE short_link = $1 AND deleted = false", shortLink)
+		var hasPixels, trackConversions bool
+		sqlRow, err := postgres.FindOne(redirectLinkQuery+" WHERE l.short_link = $1 AND l.deleted = false", shortLink)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
-		err = sqlRow.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted)
+		err = sqlRow.Scan(&link.User_uid, &link.Uid, &link.Original_url, &link.Short_link, &link.Is_custom_backoff, &link.Created_at, &link.Expiry_date, &link.Password, &link.Is_flagged, &link.Updated_at, &tagsJSON, &link.Deleted, &hasPixels, &trackConversions)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				response.SendNotFoundError(c, "Link not found")
@@ -692,12 +803,16 @@ func VerifyPasswordHandler() gin.HandlerFunc {
 		ip := getClientIP(c)
 		sot := c.Param("sot")
 		referrer := c.Request.Header.Get("Referer")
-		services.PushAnalytics(sot, ip, ua, isQR, referrer)
+		destination := link.Original_url
+		dnt := doNotTrack(c)
+		clickID, err := services.PushAnalytics(link.User_uid, sot, ip, ua, isQR, referrer, dnt)
+		withClickID := err == nil && !dnt && trackConversions
+		if withClickID {
+			// The destination reports conversions against this click
+			destination = services.AppendClickID(destination, clickID)
+		}
 
-		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", 5*60))
-		c.Header("Content-Security-Policy", "referer always;")
-		c.Header("Referrer-Policy", "unsafe-url")
-		c.Redirect(http.StatusMovedPermanently, link.Original_url)
+		forward(c, link.Uid, hasPixels, destination, withClickID)
 	}
 }
 
@@ -738,8 +853,37 @@ func GetLinkAnalyticsHandler() gin.HandlerFunc {
 			response.SendStatus(c, http.StatusGone)
 			return
 		}
-		// if not get analytics from analytics table
-		analytics := fetchLinkAnalytics(sc.ShortLink, sc.UserUID)
+		tz, err := services.ResolveTimeZone(c.Query("tz"), sc.UserUID)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		compare := c.Query("compare")
+		if err := services.ValidateCompareMode(compare); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		// Without a date range the link's whole history is returned; comparisons need one
+		startDate, endDate := c.Query("start_date"), c.Query("end_date")
+		if compare != "" {
+			startDate, endDate = services.DefaultDateRange(startDate, endDate, tz)
+		}
+		// Bad dates are the caller's fault, the rollup watermark failing is ours
+		scope := services.StatsScope{StartDate: startDate, EndDate: endDate, TimeZone: tz}
+		if _, _, err := scope.Bounds(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		scope, err = services.NewStatsScope(sc.UserUID, sc.ShortLink, startDate, endDate, tz)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		analytics, err := fetchLinkAnalytics(scope, compare)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
 		analytics.CreatedOn = sc.CreatedOn
 		analytics.OriginalURL = sc.OriginalLink
 		if sc.Password != nil {
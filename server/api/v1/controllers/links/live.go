@@ -0,0 +1,97 @@
+package links
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Interval of keep-alive comments, short enough for proxies not to drop idle streams
+const liveHeartbeat = 15 * time.Second
+
+// LiveLinkHandler streams the clicks of a single link as Server-Sent Events
+func LiveLinkHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		uid := c.GetString("uid")
+
+		row, err := postgres.FindOne("SELECT short_link FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", id, uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		var shortLink string
+		if err := row.Scan(&shortLink); err != nil {
+			if err == sql.ErrNoRows {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		streamLiveClicks(c, services.LiveLinkChannel(shortLink))
+	}
+}
+
+// LiveAccountHandler streams the clicks of all links of the user as Server-Sent Events
+func LiveAccountHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "User not authenticated")
+			return
+		}
+		streamLiveClicks(c, services.LiveUserChannel(uid))
+	}
+}
+
+// streamLiveClicks relays a pub/sub channel to the client until it disconnects.
+// Each click is sent as a "click" event whose data is a services.LiveClick.
+func streamLiveClicks(c *gin.Context, channel string) {
+	ctx := c.Request.Context()
+	sub := rdb.RC.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	// Wait for the subscription to be confirmed so no click is missed after the headers are sent
+	if _, err := sub.Receive(ctx); err != nil {
+		response.SendServerError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	messages := sub.Channel()
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	// Open the stream right away so clients know they are connected
+	c.SSEvent("ready", channel)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case msg, ok := <-messages:
+			if !ok {
+				return false
+			}
+			c.SSEvent("click", msg.Payload)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		}
+	})
+}
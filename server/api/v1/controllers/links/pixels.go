@@ -0,0 +1,135 @@
+package links
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// interstitialPixel is a pixel as fired by the interstitial page
+type interstitialPixel struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// forward sends the visitor to the destination. Links with retargeting pixels go through the
+// interstitial page, which fires them within services.InterstitialBudget; other links redirect
+// directly. Only web destinations are ever written into the page, since it runs on the short
+// link's origin. A destination carrying a click ID is unique to the click, so it is never cached.
+func forward(c *gin.Context, linkUID string, hasPixels bool, destination string, withClickID bool) {
+	if hasPixels && services.IsWebURL(destination) {
+		pixels, err := services.LinkPixels(linkUID)
+		if err != nil {
+			log.Printf("Failed to load pixels of link %s: %v", linkUID, err)
+		}
+		if len(pixels) > 0 {
+			fire := make([]interstitialPixel, 0, len(pixels))
+			for _, p := range pixels {
+				fire = append(fire, interstitialPixel{Type: p.Type, ID: p.TrackingID})
+			}
+			budget := services.InterstitialBudget
+			c.Header("Cache-Control", "no-store")
+			c.Header("Referrer-Policy", "unsafe-url")
+			response.ServeHTML(c, http.StatusOK, "interstitial.html", gin.H{
+				"Destination": destination,
+				"Pixels":      fire,
+				"BudgetMs":    budget.Milliseconds(),
+				// The meta refresh only matters without JavaScript, so it can wait a little longer
+				"RefreshSeconds": int(budget.Seconds()) + 1,
+			})
+			return
+		}
+	}
+
+	status := http.StatusMovedPermanently
+	if withClickID {
+		status = http.StatusFound
+		c.Header("Cache-Control", "no-store")
+	} else {
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", 5*60))
+	}
+	c.Header("Content-Security-Policy", "referer always;")
+	c.Header("Referrer-Policy", "unsafe-url")
+	c.Redirect(status, destination)
+}
+
+// findLinkUID returns the uid of a link of the user
+func findLinkUID(shortLink, userUID string) (string, error) {
+	row, err := postgres.FindOne("SELECT uid FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", shortLink, userUID)
+	if err != nil {
+		return "", err
+	}
+	var uid string
+	err = row.Scan(&uid)
+	return uid, err
+}
+
+func GetLinkPixelsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		linkUID, err := findLinkUID(c.Param("id"), uid)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		pixels, err := services.LinkPixels(linkUID)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"pixels": pixels})
+	}
+}
+
+func SetLinkPixelsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		var payload LinkPixelsPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+
+		linkUID, err := findLinkUID(c.Param("id"), uid)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		if err := services.SetLinkPixels(uid, linkUID, payload.PixelIDs); err != nil {
+			if err == services.ErrPixelNotFound {
+				response.SendNotFoundError(c, "Pixel not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		pixels, err := services.LinkPixels(linkUID)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"pixels":  pixels,
+			"message": "Link pixels updated",
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package links
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GetTrashHandler lists the deleted links of the user, most recently deleted first
+func GetTrashHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+
+		page := 1
+		pageSize := 10
+		if p := c.Query("page"); p != "" {
+			fmt.Sscanf(p, "%d", &page)
+			if page < 1 {
+				page = 1
+			}
+		}
+		if ps := c.Query("page_size"); ps != "" {
+			fmt.Sscanf(ps, "%d", &pageSize)
+			if pageSize < 1 || pageSize > 100 {
+				pageSize = 10
+			}
+		}
+		offset := (page - 1) * pageSize
+
+		var total int
+		totalRow, err := postgres.FindOne("SELECT COUNT(*) FROM links WHERE user_uid = $1 AND deleted = TRUE", uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if err := totalRow.Scan(&total); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+
+		// Links deleted before deletion times were recorded count from their last update
+		sqlRows, err := postgres.FindMany(`
+			SELECT `+linkColumns+`, COALESCE(deleted_at, updated_at) FROM links
+			WHERE user_uid = $1 AND deleted = TRUE
+			ORDER BY COALESCE(deleted_at, updated_at) DESC
+			LIMIT $2 OFFSET $3
+		`, uid, pageSize, offset)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		defer sqlRows.Close()
+
+		trashed := []TrashedLink{}
+		var links []Link
+		for sqlRows.Next() {
+			var t TrashedLink
+			var tagsJSON []byte
+			err := sqlRows.Scan(&t.User_uid, &t.Uid, &t.Original_url, &t.Short_link, &t.Is_custom_backoff, &t.Created_at, &t.Expiry_date, &t.Password, &t.Is_flagged, &t.Updated_at, &tagsJSON, &t.Deleted, &t.Folder_id, &t.Title, &t.Description, &t.Notes, &t.DeletedAt)
+			if err != nil {
+				response.SendServerError(c, err)
+				return
+			}
+			t.Expiry_date = t.Expiry_date.UTC()
+			t.Tags = []string{}
+			if len(tagsJSON) > 0 {
+				if err := json.Unmarshal(tagsJSON, &t.Tags); err != nil {
+					response.SendServerError(c, err)
+					return
+				}
+			}
+			t.PurgeAt = services.TrashPurgeAt(t.DeletedAt)
+			trashed = append(trashed, t)
+			links = append(links, t.Link)
+		}
+		if err := sqlRows.Err(); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+
+		if err := buildShortLinkURLsBatch(links); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		for i := range trashed {
+			trashed[i].FullShortLink = links[i].FullShortLink
+		}
+
+		response.SendJSON(c, PaginatedTrashResponse{
+			Links:         trashed,
+			Total:         total,
+			Page:          page,
+			PageSize:      pageSize,
+			RetentionDays: services.TrashRetentionDays(),
+		})
+	}
+}
+
+// RestoreLinkHandler moves a link out of the trash and re-caches its destination
+func RestoreLinkHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, err := services.ApplyBulkLinkAction(c.GetString("uid"), services.BulkLinkAction{
+			Action: services.BulkRestore,
+			IDs:    []string{c.Param("id")},
+		})
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if report.Updated == 0 {
+			response.SendNotFoundError(c, "Link not found in trash")
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Link restored"})
+	}
+}
+
+// PurgeLinkHandler permanently deletes a link in the trash, freeing its alias
+func PurgeLinkHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := services.PurgeLink(c.GetString("uid"), c.Param("id")); err != nil {
+			if err == services.ErrLinkNotInTrash {
+				response.SendNotFoundError(c, "Link not found in trash")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Link permanently deleted"})
+	}
+}
+
+// EmptyTrashHandler permanently deletes every link in the trash
+func EmptyTrashHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		purged, err := services.EmptyTrash(c.GetString("uid"))
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"purged": purged})
+	}
+}
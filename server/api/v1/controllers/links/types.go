@@ -1,6 +1,10 @@
 package links
 
-import "time"
+import (
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/services"
+)
 
 type CreateShortURLPayload struct {
 	Original_url   string    `json:"original_url"`
@@ -9,6 +13,8 @@ type CreateShortURLPayload struct {
 	Is_flagged     bool      `json:"is_flagged"`
 	Custom_backoff string    `json:"custom_backoff"`
 	Tags           []string  `json:"tags"`
+	Folder_id      *string   `json:"folder_id"`
+	Notes          *string   `json:"notes"`
 }
 
 type PasswordVerificationPayload struct {
@@ -16,19 +22,39 @@ type PasswordVerificationPayload struct {
 }
 
 type Link struct {
-	User_uid          string    `json:"user_uid"`
-	Uid               string    `json:"uid"`
-	Original_url      string    `json:"original_url"`
-	Short_link        string    `json:"short_link"`
-	FullShortLink     string    `json:"full_short_link"`
-	Created_at        time.Time `json:"created_at"`
-	Expiry_date       time.Time `json:"expiry_date"`
-	Password          *string   `json:"password"`
-	Is_flagged        bool      `json:"is_flagged"`
-	Is_custom_backoff bool      `json:"is_custom_backoff"`
-	Updated_at        time.Time `json:"updated_at"`
-	Tags              []string  `json:"tags"`
-	Deleted           bool      `json:"deleted"`
+	User_uid          string              `json:"user_uid"`
+	Uid               string              `json:"uid"`
+	Original_url      string              `json:"original_url"`
+	Short_link        string              `json:"short_link"`
+	FullShortLink     string              `json:"full_short_link"`
+	Created_at        time.Time           `json:"created_at"`
+	Expiry_date       time.Time           `json:"expiry_date"`
+	Password          *string             `json:"password"`
+	Is_flagged        bool                `json:"is_flagged"`
+	Is_custom_backoff bool                `json:"is_custom_backoff"`
+	Updated_at        time.Time           `json:"updated_at"`
+	Tags              []string            `json:"tags"`
+	Deleted           bool                `json:"deleted"`
+	Folder_id         *string             `json:"folder_id"`
+	Title             *string             `json:"title"`       // captured from the destination page
+	Description       *string             `json:"description"` // captured from the destination page
+	Notes             *string             `json:"notes"`
+	Match             *services.LinkMatch `json:"match,omitempty"` // why a search found the link
+}
+
+// TrashedLink is a deleted link with when it will be purged unless restored
+type TrashedLink struct {
+	Link
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+type PaginatedTrashResponse struct {
+	Links         []TrashedLink `json:"links"`
+	Total         int           `json:"total"`
+	Page          int           `json:"page"`
+	PageSize      int           `json:"page_size"`
+	RetentionDays int           `json:"retention_days"`
 }
 
 type PreviewData struct {
@@ -39,36 +65,53 @@ type PreviewData struct {
 	URL         string `json:"url"`
 }
 
+// LinkPixelsPayload replaces the retargeting pixels of a link; an empty list removes them all
+type LinkPixelsPayload struct {
+	PixelIDs []string `json:"pixel_ids"`
+}
+
+// PaginatedLinksResponse is a page of links. Pages read by cursor have no total or page number.
 type PaginatedLinksResponse struct {
-	Links    []Link `json:"links"`
-	Total    int    `json:"total"`
-	Page     int    `json:"page"`
-	PageSize int    `json:"page_size"`
+	Links      []Link `json:"links"`
+	Total      *int   `json:"total,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 type LinkAnalytics struct {
-	ShortLink           string           `json:"short_link"`
-	FullShortLink       string           `json:"full_short_link"` // Added field for complete short link URL
-	OriginalURL         string           `json:"original_link"`
-	TotalClicks         int              `json:"total_clicks"`
-	UniqueVisitors      int              `json:"unique_visitors"`
-	DirectClicks        int              `json:"direct_clicks"`
-	QR_clicks           int              `json:"qr_clicks"`
-	CreatedOn           time.Time        `json:"created_on"`
-	ExpiriesOn          time.Time        `json:"expiries_on"`
-	IsPasswordProtected bool             `json:"is_password_protected"`
-	LastClickedAt       time.Time        `json:"last_clicked_at"`
-	LastClickBrowser    string           `json:"last_click_browser"`
-	LastClickDevice     string           `json:"last_click_device"`
-	LastClickFrom       string           `json:"last_click_from"`
-	HourlyStats         map[int]int64    `json:"hourly_stats"`
-	DailyStats          map[string]int64 `json:"daily_stats"`
-	WeeklyStats         map[int]int64    `json:"weekly_stats"`
-	MonthlyStats        map[string]int64 `json:"monthly_stats"`
-	OSStats             map[string]int64 `json:"os_stats"`
-	DeviceStats         map[string]int64 `json:"device_stats"`
-	BrowserStats        map[string]int64 `json:"browser_stats"`
-	GeographicData      []GeographicData `json:"geographic_data"`
+	ShortLink           string                   `json:"short_link"`
+	FullShortLink       string                   `json:"full_short_link"` // Added field for complete short link URL
+	OriginalURL         string                   `json:"original_link"`
+	TotalClicks         int                      `json:"total_clicks"`
+	UniqueVisitors      int                      `json:"unique_visitors"` // Distinct visitors per UTC day, summed
+	DirectClicks        int                      `json:"direct_clicks"`
+	QR_clicks           int                      `json:"qr_clicks"`
+	CreatedOn           time.Time                `json:"created_on"`
+	ExpiriesOn          time.Time                `json:"expiries_on"`
+	IsPasswordProtected bool                     `json:"is_password_protected"`
+	LastClickedAt       time.Time                `json:"last_clicked_at"`
+	LastClickBrowser    string                   `json:"last_click_browser"`
+	LastClickDevice     string                   `json:"last_click_device"`
+	LastClickFrom       string                   `json:"last_click_from"`
+	HourlyStats         map[int]int64            `json:"hourly_stats"`
+	DailyStats          map[string]int64         `json:"daily_stats"`
+	WeeklyStats         map[int]int64            `json:"weekly_stats"`
+	MonthlyStats        map[string]int64         `json:"monthly_stats"`
+	OSStats             map[string]int64         `json:"os_stats"`
+	DeviceStats         map[string]int64         `json:"device_stats"`
+	BrowserStats        map[string]int64         `json:"browser_stats"`
+	GeographicData      []GeographicData         `json:"geographic_data"`
+	TopReferrers        []ReferrerData           `json:"top_referrers"`
+	SourceStats         map[string]int64         `json:"source_stats"`
+	Conversions         int64                    `json:"conversions"`
+	ConversionRate      float64                  `json:"conversion_rate"` // percentage of clicks
+	Revenue             map[string]float64       `json:"revenue"`         // keyed by currency
+	CountryConversions  []ConversionData         `json:"country_conversions"`
+	ReferrerConversions []ConversionData         `json:"referrer_conversions"`
+	Comparison          *services.Comparison     `json:"comparison,omitempty"`
+	Imported            *services.ImportBaseline `json:"imported,omitempty"` // clicks and title from the shortener the link was imported from
 }
 
 type GeographicData struct {
@@ -76,3 +119,21 @@ type GeographicData struct {
 	CountryCode string `json:"country_code"`
 	ClickCount  int64  `json:"click_count"`
 }
+
+// Number of referrer hosts returned in top_referrers
+const topReferrersLimit = 10
+
+// ConversionData is the conversions of a country (Code is its country code) or a referrer host
+type ConversionData struct {
+	Value          string             `json:"value"`
+	Code           string             `json:"code,omitempty"`
+	Clicks         int64              `json:"clicks"`
+	Conversions    int64              `json:"conversions"`
+	ConversionRate float64            `json:"conversion_rate"`
+	Revenue        map[string]float64 `json:"revenue"`
+}
+
+type ReferrerData struct {
+	Host       string `json:"host"`
+	ClickCount int64  `json:"click_count"`
+}
@@ -0,0 +1,117 @@
+package settings
+
+import (
+	"errors"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+func GetPixels() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		pixels, err := services.ListPixels(uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"pixels": pixels, "types": services.PixelTypes})
+	}
+}
+
+func CreatePixel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload Pixel
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		pixel := services.Pixel{Name: payload.Name, Type: payload.Type, TrackingID: payload.TrackingID}
+		if err := pixel.Validate(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		pixel, err := services.CreatePixel(uid, pixel)
+		if err != nil {
+			if err == services.ErrPixelExists {
+				response.SendConflictError(c, "A pixel with this tracking ID already exists")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"pixel":   pixel,
+			"message": "Pixel created",
+		})
+	}
+}
+
+func UpdatePixel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload Pixel
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		pixel := services.Pixel{ID: c.Param("id"), Name: payload.Name, Type: payload.Type, TrackingID: payload.TrackingID}
+		if err := pixel.Validate(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		pixel, err := services.UpdatePixel(uid, pixel)
+		if err != nil {
+			switch err {
+			case services.ErrPixelNotFound:
+				response.SendNotFoundError(c, "Pixel not found")
+			case services.ErrPixelExists:
+				response.SendConflictError(c, "A pixel with this tracking ID already exists")
+			default:
+				response.SendServerError(c, err)
+			}
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"pixel":   pixel,
+			"message": "Pixel updated",
+		})
+	}
+}
+
+func DeletePixel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		if err := services.DeletePixel(uid, c.Param("id")); err != nil {
+			if err == services.ErrPixelNotFound {
+				response.SendNotFoundError(c, "Pixel not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Pixel deleted"})
+	}
+}
@@ -0,0 +1,99 @@
+package settings
+
+import (
+	"database/sql"
+	"errors"
+	"net/mail"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+func GetReportSubscriptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		subscriptions, err := services.ListReportSubscriptions(uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"subscriptions": subscriptions})
+	}
+}
+
+func CreateReportSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload ReportSubscription
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		if err := services.ValidateReportFrequency(payload.Frequency); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		if payload.Email != "" {
+			if _, err := mail.ParseAddress(payload.Email); err != nil {
+				response.SendBadRequestError(c, "Invalid email")
+				return
+			}
+		}
+		if payload.ShortLink != "" {
+			row, err := postgres.FindOne("SELECT short_link FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", payload.ShortLink, uid)
+			if err != nil {
+				response.SendServerError(c, err)
+				return
+			}
+			if err := row.Scan(&payload.ShortLink); err != nil {
+				if err == sql.ErrNoRows {
+					response.SendNotFoundError(c, "Link not found")
+					return
+				}
+				response.SendServerError(c, err)
+				return
+			}
+		}
+
+		subscription, err := services.CreateReportSubscription(uid, payload.ShortLink, payload.Frequency, payload.Email)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"subscription": subscription,
+			"message":      "Subscribed to " + payload.Frequency + " reports",
+		})
+	}
+}
+
+func DeleteReportSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		if err := services.DeleteReportSubscription(uid, c.Param("id")); err != nil {
+			if err == services.ErrReportSubscriptionNotFound {
+				response.SendNotFoundError(c, "Report subscription not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Unsubscribed from report"})
+	}
+}
@@ -1,10 +1,12 @@
 package settings
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
 
 	"github.com/RishiKendai/sot/pkg/config/env"
 	"github.com/RishiKendai/sot/pkg/config/response"
@@ -27,22 +29,29 @@ func UpdateProfile() gin.HandlerFunc {
 			response.SendBadRequestError(c, "Invalid request body")
 			return
 		}
+		if profile.Timezone != "" {
+			if err := services.ValidateTimeZone(profile.Timezone); err != nil {
+				response.SendBadRequestError(c, err.Error())
+				return
+			}
+		}
 		// update in postgres and update token
-		result, err := postgres.UpdateOne("UPDATE users SET name = $1 WHERE uid = $2", profile.Name, uid)
+		row, err := postgres.FindOne(
+			"UPDATE users SET name = $1, timezone = COALESCE(NULLIF($2, ''), timezone) WHERE uid = $3 RETURNING COALESCE(timezone, 'UTC')",
+			profile.Name, profile.Timezone, uid,
+		)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
-
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
+		if err := row.Scan(&profile.Timezone); err != nil {
+			if err == sql.ErrNoRows {
+				response.SendBadRequestError(c, "User not found")
+				return
+			}
 			response.SendServerError(c, err)
 			return
 		}
-		if rowsAffected == 0 {
-			response.SendBadRequestError(c, "User not found")
-			return
-		}
 
 		// update in redis
 		rdb.RC.HSet("user:"+uid, map[string]any{
@@ -51,7 +60,8 @@ func UpdateProfile() gin.HandlerFunc {
 
 		// send response
 		response.SendJSON(c, gin.H{
-			"name": profile.Name,
+			"name":     profile.Name,
+			"timezone": profile.Timezone,
 		})
 	}
 }
@@ -218,3 +228,252 @@ func UpdateDomainSettings() gin.HandlerFunc {
 		})
 	}
 }
+
+func GetPrivacySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get payload
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		r, err := postgres.FindOne("SELECT COALESCE(privacy_mode, FALSE) FROM users WHERE uid = $1", uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		var privacyMode bool
+		err = r.Scan(&privacyMode)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+
+		// send response
+		response.SendJSON(c, gin.H{
+			"privacy_mode": privacyMode,
+		})
+	}
+}
+
+func UpdatePrivacySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get payload
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload Privacy
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+
+		// update in postgres
+		res, err := postgres.UpdateOne("UPDATE users SET privacy_mode = $1 WHERE uid = $2", payload.PrivacyMode, uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if rowsAffected == 0 {
+			response.SendBadRequestError(c, "User not found")
+			return
+		}
+
+		// send response
+		response.SendJSON(c, gin.H{
+			"privacy_mode": payload.PrivacyMode,
+			"message":      "Privacy settings updated successfully",
+		})
+	}
+}
+
+func GetConversionSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		r, err := postgres.FindOne("SELECT COALESCE(conversion_tracking, FALSE) FROM users WHERE uid = $1", uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		var tracking bool
+		if err := r.Scan(&tracking); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+
+		response.SendJSON(c, gin.H{
+			"conversion_tracking": tracking,
+		})
+	}
+}
+
+// UpdateConversionSettings turns conversion tracking on or off. Only accounts tracking
+// conversions get the click ID appended to their destinations.
+func UpdateConversionSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload Conversions
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+
+		res, err := postgres.UpdateOne("UPDATE users SET conversion_tracking = $1 WHERE uid = $2", payload.ConversionTracking, uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if rowsAffected == 0 {
+			response.SendBadRequestError(c, "User not found")
+			return
+		}
+
+		response.SendJSON(c, gin.H{
+			"conversion_tracking": payload.ConversionTracking,
+			"message":             "Conversion settings updated successfully",
+		})
+	}
+}
+
+func GetNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		notifications, err := services.GetNotificationSettings(uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, notifications)
+	}
+}
+
+func UpdateNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload Notifications
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		if payload.AlertEmail != "" {
+			if _, err := mail.ParseAddress(payload.AlertEmail); err != nil {
+				response.SendBadRequestError(c, "Invalid alert email")
+				return
+			}
+		}
+
+		notifications, err := services.SaveNotificationSettings(uid, services.NotificationSettings{
+			EmailAlerts: payload.EmailAlerts,
+			AlertEmail:  payload.AlertEmail,
+		})
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+
+		response.SendJSON(c, gin.H{
+			"notifications": notifications,
+			"message":       "Notification settings updated successfully",
+		})
+	}
+}
+
+func GetRetentionSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		policy, err := services.GetRetentionPolicy(uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"retention": policy,
+			"defaults":  services.DefaultRetentionPolicy(),
+		})
+	}
+}
+
+func UpdateRetentionSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload Retention
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+
+		var update *services.RetentionPolicy
+		if !payload.Reset {
+			policy, err := services.GetRetentionPolicy(uid)
+			if err != nil {
+				response.SendServerError(c, err)
+				return
+			}
+			if payload.RawRetentionDays != nil {
+				policy.RawDays = *payload.RawRetentionDays
+			}
+			if payload.RollupRetentionDays != nil {
+				policy.RollupDays = *payload.RollupRetentionDays
+			}
+			if err := policy.Validate(); err != nil {
+				response.SendBadRequestError(c, err.Error())
+				return
+			}
+			update = &policy
+		}
+
+		if err := services.SaveRetentionPolicy(uid, update); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		policy, err := services.GetRetentionPolicy(uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"retention": policy,
+			"message":   "Retention settings updated successfully",
+		})
+	}
+}
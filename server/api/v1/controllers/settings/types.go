@@ -1,10 +1,60 @@
 package settings
 
 type Profile struct {
-	Name string `json:"name" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Timezone string `json:"timezone"` // IANA name, unchanged when empty
 }
 
 type Password struct {
 	OldPassword string `json:"oldPassword" binding:"required"`
 	NewPassword string `json:"newPassword" binding:"required"`
 }
+
+type Privacy struct {
+	PrivacyMode bool `json:"privacy_mode"`
+}
+
+// Conversions turns on appending a click ID to destinations for conversion tracking
+type Conversions struct {
+	ConversionTracking bool `json:"conversion_tracking"`
+}
+
+// Notifications are the email settings of alerts; alert webhooks are endpoints subscribed to alert.* events
+type Notifications struct {
+	EmailAlerts bool   `json:"email_alerts"`
+	AlertEmail  string `json:"alert_email"` // account email when empty
+}
+
+type ReportSubscription struct {
+	Frequency string `json:"frequency" binding:"required"` // daily, weekly or monthly
+	ShortLink string `json:"short_link"`                   // whole account when empty
+	Email     string `json:"email"`                        // account email when empty
+}
+
+// Retention sets how many days analytics are kept; omitted fields keep their current value
+// and reset restores the server defaults
+type Retention struct {
+	RawRetentionDays    *int `json:"raw_retention_days"`
+	RollupRetentionDays *int `json:"rollup_retention_days"`
+	Reset               bool `json:"reset"`
+}
+
+type WebhookEndpoint struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required"`
+}
+
+// WebhookEndpointUpdate changes an endpoint; omitted fields keep their current value
+type WebhookEndpointUpdate struct {
+	URL         *string   `json:"url"`
+	Description *string   `json:"description"`
+	Events      *[]string `json:"events"`
+	Active      *bool     `json:"active"`
+}
+
+type Pixel struct {
+	Name       string `json:"name" binding:"required"`
+	Type       string `json:"type" binding:"required"` // facebook, google, linkedin, twitter or pinterest
+	TrackingID string `json:"tracking_id" binding:"required"`
+}
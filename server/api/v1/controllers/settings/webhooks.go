@@ -0,0 +1,206 @@
+package settings
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+func GetWebhookEndpoints() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		endpoints, err := services.ListWebhookEndpoints(uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"endpoints": endpoints, "events": services.WebhookEvents})
+	}
+}
+
+func CreateWebhookEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload WebhookEndpoint
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		if err := services.ValidateWebhookURL(payload.URL); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		if err := services.ValidateWebhookEvents(payload.Events); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		endpoint, err := services.CreateWebhookEndpoint(uid, payload.URL, payload.Description, payload.Events)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		// The secret is only returned once, when the endpoint is created
+		response.SendJSON(c, gin.H{
+			"endpoint": endpoint,
+			"message":  "Webhook endpoint created",
+		})
+	}
+}
+
+func UpdateWebhookEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+		var payload WebhookEndpointUpdate
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+
+		endpoint, err := services.GetWebhookEndpoint(uid, c.Param("id"))
+		if err != nil {
+			if err == services.ErrWebhookNotFound {
+				response.SendNotFoundError(c, "Webhook endpoint not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		if payload.URL != nil {
+			if err := services.ValidateWebhookURL(*payload.URL); err != nil {
+				response.SendBadRequestError(c, err.Error())
+				return
+			}
+			endpoint.URL = *payload.URL
+		}
+		if payload.Events != nil {
+			if err := services.ValidateWebhookEvents(*payload.Events); err != nil {
+				response.SendBadRequestError(c, err.Error())
+				return
+			}
+			endpoint.Events = *payload.Events
+		}
+		if payload.Description != nil {
+			endpoint.Description = *payload.Description
+		}
+		if payload.Active != nil {
+			endpoint.Active = *payload.Active
+		}
+
+		endpoint, err = services.UpdateWebhookEndpoint(uid, endpoint)
+		if err != nil {
+			if err == services.ErrWebhookNotFound {
+				response.SendNotFoundError(c, "Webhook endpoint not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"endpoint": endpoint,
+			"message":  "Webhook endpoint updated",
+		})
+	}
+}
+
+func DeleteWebhookEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		if err := services.DeleteWebhookEndpoint(uid, c.Param("id")); err != nil {
+			if err == services.ErrWebhookNotFound {
+				response.SendNotFoundError(c, "Webhook endpoint not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"message": "Webhook endpoint deleted"})
+	}
+}
+
+func GetWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		status := c.Query("status")
+		switch status {
+		case "", services.DeliveryPending, services.DeliverySucceeded, services.DeliveryFailed:
+		default:
+			response.SendBadRequestError(c, "Invalid status")
+			return
+		}
+		limit := 50
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 200 {
+				response.SendBadRequestError(c, "limit must be between 1 and 200")
+				return
+			}
+			limit = n
+		}
+
+		if _, err := services.GetWebhookEndpoint(uid, c.Param("id")); err != nil {
+			if err == services.ErrWebhookNotFound {
+				response.SendNotFoundError(c, "Webhook endpoint not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		deliveries, err := services.ListWebhookDeliveries(uid, c.Param("id"), status, limit)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"deliveries": deliveries})
+	}
+}
+
+func ReplayWebhookDelivery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendServerError(c, errors.New("invalid request. uid is required"))
+			return
+		}
+
+		id, err := services.ReplayWebhookDelivery(uid, c.Param("id"), c.Param("deliveryId"))
+		if err != nil {
+			if err == services.ErrWebhookNotFound {
+				response.SendNotFoundError(c, "Webhook delivery not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendAcceptedJSON(c, gin.H{
+			"delivery_id": id,
+			"message":     "Webhook delivery queued",
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package tags
+
+// RenameTagPayload renames Tag to NewName on every link; an existing NewName merges the two
+type RenameTagPayload struct {
+	Tag     string `json:"tag"`
+	NewName string `json:"new_name"`
+}
+
+// MergeTagsPayload replaces every tag in Tags with Into
+type MergeTagsPayload struct {
+	Tags []string `json:"tags"`
+	Into string   `json:"into"`
+}
@@ -11,6 +11,9 @@ func RegisterRoutes(router *gin.RouterGroup) {
 	routes.Links(router)
 	routes.Dashboard(router)
 	routes.Analytics(router)
+	routes.Alerts(router)
+	routes.Folders(router)
+	routes.Tags(router)
 
 	settingsGroup := router.Group("/settings")
 	routes.Settings(settingsGroup)
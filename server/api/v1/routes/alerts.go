@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/api/v1/controllers/alerts"
+	"github.com/gin-gonic/gin"
+)
+
+// Alerts is registered after Links, whose authentication and rate limiting middleware already covers the group
+func Alerts(router *gin.RouterGroup) {
+	router.GET("/alerts", alerts.GetAlertsHandler())
+	router.PUT("/alerts/:id/read", alerts.MarkAlertReadHandler())
+}
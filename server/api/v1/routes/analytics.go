@@ -14,4 +14,10 @@ func Analytics(router *gin.RouterGroup) {
 
 	// Get analytics for a specific link (must belong to authenticated user)
 	router.GET("/analytics/link/:shortLink", analytics.GetLinkAnalyticsHandler())
+
+	// Export raw clicks or daily stats as CSV/NDJSON, streamed or as an async job
+	router.GET("/analytics/export", analytics.ExportAnalyticsHandler())
+	router.GET("/analytics/link/:shortLink/export", analytics.ExportLinkAnalyticsHandler())
+	router.GET("/analytics/exports/:id", analytics.GetExportJobHandler())
+	router.GET("/analytics/exports/download/:token", analytics.DownloadExportHandler())
 }
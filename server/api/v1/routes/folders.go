@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/api/v1/controllers/folders"
+	"github.com/gin-gonic/gin"
+)
+
+// Folders is registered after Links, whose authentication and rate limiting middleware already covers the group
+func Folders(router *gin.RouterGroup) {
+	router.GET("/folders", folders.GetFoldersHandler())
+	router.POST("/folders", folders.CreateFolderHandler())
+	router.GET("/folders/:id", folders.GetFolderHandler())
+	router.PUT("/folders/:id", folders.UpdateFolderHandler())
+	router.DELETE("/folders/:id", folders.DeleteFolderHandler())
+}
@@ -11,9 +11,25 @@ func Links(router *gin.RouterGroup) {
 	router.Use(services.Authenticate(), middleware.InternalRateLimiter())
 	router.POST("/links", links.CreateShortURLHandler())
 	router.GET("/links", links.GetLinksHandler())
+	router.GET("/links/live", links.LiveAccountHandler())
+	router.POST("/links/batch", links.CreateLinkBatchHandler())
+	router.POST("/links/bulk", links.BulkLinksHandler())
+	router.POST("/links/import", links.ImportLinksHandler())
+	router.GET("/links/trash", links.GetTrashHandler())
+	router.DELETE("/links/trash", links.EmptyTrashHandler())
+	router.POST("/links/trash/:id/restore", links.RestoreLinkHandler())
+	router.DELETE("/links/trash/:id", links.PurgeLinkHandler())
+	router.GET("/links/batch/:id", links.GetLinkBatchHandler())
+	router.GET("/links/batch/download/:token", links.DownloadLinkBatchHandler())
 	router.GET("/links/:id", links.GetLinkHandler())
 	router.PUT("/links/:id", links.UpdateLinkHandler())
 	router.DELETE("/links/:id", links.DeleteLinkHandler())
+	router.GET("/links/:id/history", links.GetLinkHistoryHandler())
+	router.POST("/links/:id/history/:revision/rollback", links.RollbackLinkHandler())
+	router.GET("/links/:id/live", links.LiveLinkHandler())
+	router.GET("/links/:id/clicks", links.GetLinkClicksHandler())
+	router.GET("/links/:id/pixels", links.GetLinkPixelsHandler())
+	router.PUT("/links/:id/pixels", links.SetLinkPixelsHandler())
 	router.GET("/links/availability/:alias", links.CheckAliasAvailabilityHandler())
 	router.GET("/links/preview/:url", links.PreviewHandler())
 	router.GET("/links/search", links.SearchLinksHandler())
@@ -11,6 +11,34 @@ func Settings(router *gin.RouterGroup) {
 	router.PUT("/profile", services.Authenticate(), settings.UpdateProfile())
 	router.GET("/domain", services.Authenticate(), settings.GetDomain())
 	router.PUT("/domain", services.Authenticate(), settings.UpdateDomainSettings())
+	router.GET("/privacy", services.Authenticate(), settings.GetPrivacySettings())
+	router.PUT("/privacy", services.Authenticate(), settings.UpdatePrivacySettings())
+	router.GET("/conversions", services.Authenticate(), settings.GetConversionSettings())
+	router.PUT("/conversions", services.Authenticate(), settings.UpdateConversionSettings())
+	router.GET("/retention", services.Authenticate(), settings.GetRetentionSettings())
+	router.PUT("/retention", services.Authenticate(), settings.UpdateRetentionSettings())
+	router.GET("/notifications", services.Authenticate(), settings.GetNotificationSettings())
+	router.PUT("/notifications", services.Authenticate(), settings.UpdateNotificationSettings())
+
+	// Scheduled reports
+	router.GET("/reports", services.Authenticate(), settings.GetReportSubscriptions())
+	router.POST("/reports", services.Authenticate(), settings.CreateReportSubscription())
+	router.DELETE("/reports/:id", services.Authenticate(), settings.DeleteReportSubscription())
+
+	// Webhooks
+	router.GET("/webhooks", services.Authenticate(), settings.GetWebhookEndpoints())
+	router.POST("/webhooks", services.Authenticate(), settings.CreateWebhookEndpoint())
+	router.PUT("/webhooks/:id", services.Authenticate(), settings.UpdateWebhookEndpoint())
+	router.DELETE("/webhooks/:id", services.Authenticate(), settings.DeleteWebhookEndpoint())
+	router.GET("/webhooks/:id/deliveries", services.Authenticate(), settings.GetWebhookDeliveries())
+	router.POST("/webhooks/:id/deliveries/:deliveryId/replay", services.Authenticate(), settings.ReplayWebhookDelivery())
+
+	// Retargeting pixels
+	router.GET("/pixels", services.Authenticate(), settings.GetPixels())
+	router.POST("/pixels", services.Authenticate(), settings.CreatePixel())
+	router.PUT("/pixels/:id", services.Authenticate(), settings.UpdatePixel())
+	router.DELETE("/pixels/:id", services.Authenticate(), settings.DeletePixel())
+
 	// Security
 	router.PUT("/password", services.Authenticate(), settings.UpdatePassword())
 
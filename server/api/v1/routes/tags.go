@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/api/v1/controllers/tags"
+	"github.com/gin-gonic/gin"
+)
+
+// Tags is registered after Links, whose authentication and rate limiting middleware already covers the group
+func Tags(router *gin.RouterGroup) {
+	router.GET("/tags", tags.GetTagsHandler())
+	router.GET("/tags/analytics", tags.GetTagAnalyticsHandler())
+	router.POST("/tags/rename", tags.RenameTagHandler())
+	router.POST("/tags/merge", tags.MergeTagsHandler())
+	router.DELETE("/tags/:tag", tags.DeleteTagHandler())
+}
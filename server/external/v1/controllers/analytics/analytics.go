@@ -0,0 +1,330 @@
+package analytics
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of values returned per breakdown and of top links
+const (
+	breakdownLimit = 50
+	topLinksLimit  = 10
+)
+
+// GetAnalyticsHandler returns the analytics of all links of the API key's account
+func GetAnalyticsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "Unauthorized")
+			return
+		}
+
+		scope, compare, ok := scopeFromQuery(c, uid, "")
+		if !ok {
+			return
+		}
+
+		report, err := buildReport(scope, compare)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, report)
+	}
+}
+
+// GetLinkAnalyticsHandler returns the analytics of a single link
+func GetLinkAnalyticsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "Unauthorized")
+			return
+		}
+
+		shortLink := c.Param("id")
+		row, err := postgres.FindOne("SELECT short_link FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", shortLink, uid)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if err := row.Scan(&shortLink); err != nil {
+			if err == sql.ErrNoRows {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		scope, compare, ok := scopeFromQuery(c, uid, shortLink)
+		if !ok {
+			return
+		}
+
+		report, err := buildReport(scope, compare)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, report)
+	}
+}
+
+// GetTotalsHandler returns the headline counters shown on the dashboard
+func GetTotalsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "Unauthorized")
+			return
+		}
+
+		scope, _, ok := scopeFromQuery(c, uid, "")
+		if !ok {
+			return
+		}
+
+		totals, err := services.FetchClickTotals(scope)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, TotalsResponse{
+			Period: periodOf(scope),
+			Totals: totalsOf(totals),
+		})
+	}
+}
+
+// scopeFromQuery reads start_date, end_date, tz and compare. Dates default to the last 30 days.
+// It sends a 400 and returns false when a parameter is invalid.
+func scopeFromQuery(c *gin.Context, uid, shortLink string) (services.StatsScope, string, bool) {
+	tz, err := services.ResolveTimeZone(c.Query("tz"), uid)
+	if err != nil {
+		response.SendBadRequestError(c, err.Error())
+		return services.StatsScope{}, "", false
+	}
+	compare := c.Query("compare")
+	if err := services.ValidateCompareMode(compare); err != nil {
+		response.SendBadRequestError(c, err.Error())
+		return services.StatsScope{}, "", false
+	}
+
+	startDate, endDate := services.DefaultDateRange(c.Query("start_date"), c.Query("end_date"), tz)
+	if _, _, err := (services.StatsScope{StartDate: startDate, EndDate: endDate, TimeZone: tz}).Bounds(); err != nil {
+		response.SendBadRequestError(c, err.Error())
+		return services.StatsScope{}, "", false
+	}
+	scope, err := services.NewStatsScope(uid, shortLink, startDate, endDate, tz)
+	if err != nil {
+		response.SendServerError(c, err)
+		return services.StatsScope{}, "", false
+	}
+	return scope, compare, true
+}
+
+// buildReport fetches every section of a report concurrently
+func buildReport(scope services.StatsScope, compare string) (*Report, error) {
+	report := &Report{
+		Period:    periodOf(scope),
+		ShortLink: scope.ShortLink,
+		Hourly:    make([]int64, 24),
+		Weekday:   make([]int64, 7),
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	run := func(fetch func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fetch(); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Each fetch fills a different field, so no locking is needed until wg.Wait
+	run(func() error {
+		totals, err := services.FetchClickTotals(scope)
+		report.Totals = totalsOf(totals)
+		return err
+	})
+	run(func() error {
+		daily, err := services.FetchDailyStats(scope)
+		if err != nil {
+			return err
+		}
+		report.Daily = make([]DailyClicks, 0, len(daily))
+		for date, clicks := range daily {
+			report.Daily = append(report.Daily, DailyClicks{Date: date, Clicks: clicks})
+		}
+		sort.Slice(report.Daily, func(i, j int) bool { return report.Daily[i].Date < report.Daily[j].Date })
+		return nil
+	})
+	run(func() error {
+		hourly, err := services.FetchHourlyStats(scope)
+		for hour, clicks := range hourly {
+			if hour >= 0 && hour < 24 {
+				report.Hourly[hour] = clicks
+			}
+		}
+		return err
+	})
+	run(func() error {
+		weekdays, err := services.FetchWeekdayStats(scope)
+		for day, clicks := range weekdays {
+			if day >= 0 && day < 7 {
+				report.Weekday[day] = clicks
+			}
+		}
+		return err
+	})
+
+	breakdowns := []struct {
+		dimension string
+		target    *[]BreakdownItem
+		exclude   []string
+	}{
+		{"country", &report.Breakdowns.Countries, []string{"Unknown", ""}},
+		{"city", &report.Breakdowns.Cities, []string{"Unknown", ""}},
+		{"browser", &report.Breakdowns.Browsers, []string{""}},
+		{"os", &report.Breakdowns.OS, []string{"Unknown", ""}},
+		{"device", &report.Breakdowns.Devices, []string{""}},
+		{"referrer", &report.Breakdowns.Referrers, []string{""}},
+		{"source", &report.Breakdowns.Sources, []string{""}},
+	}
+	for _, b := range breakdowns {
+		b := b
+		run(func() error {
+			stats, err := services.FetchDimensionStats(scope, b.dimension, b.exclude...)
+			if err != nil {
+				return err
+			}
+			if len(stats) > breakdownLimit {
+				stats = stats[:breakdownLimit]
+			}
+			items := make([]BreakdownItem, 0, len(stats))
+			for _, dc := range stats {
+				items = append(items, BreakdownItem{Value: dc.Value, Code: dc.Code, Clicks: dc.Clicks})
+			}
+			*b.target = items
+			return nil
+		})
+	}
+
+	if scope.ShortLink == "" {
+		run(func() error {
+			links, err := services.FetchTopLinks(scope, topLinksLimit)
+			if err != nil {
+				return err
+			}
+			base, err := shortLinkBase(scope.UserUID)
+			if err != nil {
+				return err
+			}
+			report.TopLinks = make([]TopLink, 0, len(links))
+			for _, l := range links {
+				report.TopLinks = append(report.TopLinks, TopLink{
+					ShortLink:     l.ShortLink,
+					FullShortLink: base + "/" + l.ShortLink,
+					OriginalLink:  l.OriginalLink,
+					TotalClicks:   l.TotalClicks,
+					QRClicks:      l.QRClicks,
+					DirectClicks:  l.DirectClicks,
+				})
+			}
+			return nil
+		})
+	}
+
+	if compare != "" {
+		run(func() error {
+			comparison, err := services.FetchComparison(scope, compare)
+			if err != nil {
+				return err
+			}
+			report.Comparison = comparisonOf(comparison, scope.TimeZone)
+			return nil
+		})
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to build analytics report: %v", errs)
+	}
+	return report, nil
+}
+
+func periodOf(scope services.StatsScope) Period {
+	return Period{StartDate: scope.StartDate, EndDate: scope.EndDate, TimeZone: scope.TimeZone}
+}
+
+func totalsOf(t services.ClickTotals) Totals {
+	return Totals{
+		TotalClicks:    t.TotalClicks,
+		UniqueVisitors: t.UniqueVisitors,
+		QRClicks:       t.QRClicks,
+		DirectClicks:   t.DirectClicks,
+	}
+}
+
+func changeOf(m services.MetricChange) Change {
+	return Change{Current: m.Current, Previous: m.Previous, Delta: m.Delta, ChangePercent: m.ChangePercent}
+}
+
+func comparisonOf(c *services.Comparison, timeZone string) *Comparison {
+	comparison := &Comparison{
+		Mode:           c.Mode,
+		Period:         Period{StartDate: c.StartDate, EndDate: c.EndDate, TimeZone: timeZone},
+		TotalClicks:    changeOf(c.TotalClicks),
+		UniqueVisitors: changeOf(c.UniqueVisitors),
+		QRClicks:       changeOf(c.QRClicks),
+		DirectClicks:   changeOf(c.DirectClicks),
+		Breakdowns:     make(map[string][]BreakdownChange, len(c.Breakdowns)),
+	}
+	for name, changes := range c.Breakdowns {
+		items := make([]BreakdownChange, 0, len(changes))
+		for _, ch := range changes {
+			items = append(items, BreakdownChange{Value: ch.Value, Change: changeOf(ch.MetricChange)})
+		}
+		comparison.Breakdowns[name] = items
+	}
+	return comparison
+}
+
+// shortLinkBase returns the host short links of the user are served from, honouring their subdomain
+func shortLinkBase(userUID string) (string, error) {
+	var useSubdomain bool
+	var subdomain sql.NullString
+
+	row, err := postgres.FindOne("SELECT use_subdomain, subdomain FROM users WHERE uid = $1", userUID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch user subdomain settings: %w", err)
+	}
+	if err := row.Scan(&useSubdomain, &subdomain); err != nil {
+		return "", fmt.Errorf("failed to scan user subdomain settings: %w", err)
+	}
+
+	sotDomain := os.Getenv("SERVER_DOMAIN")
+	if useSubdomain && subdomain.Valid && subdomain.String != "" {
+		return subdomain.String + "." + sotDomain, nil
+	}
+	return sotDomain, nil
+}
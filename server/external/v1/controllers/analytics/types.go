@@ -0,0 +1,91 @@
+package analytics
+
+// The types below are the public v1 contract of the external API. They are kept separate from
+// the dashboard's response types so internal changes never alter what API clients receive.
+
+type Period struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	TimeZone  string `json:"timezone"`
+}
+
+// Totals are the counters of a period. Unique visitors are distinct visitors per link and UTC
+// day, summed over the period, so a visitor returning on another day is counted again.
+type Totals struct {
+	TotalClicks    int64 `json:"total_clicks"`
+	UniqueVisitors int64 `json:"unique_visitors"`
+	QRClicks       int64 `json:"qr_clicks"`
+	DirectClicks   int64 `json:"direct_clicks"`
+}
+
+type DailyClicks struct {
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+type BreakdownItem struct {
+	Value  string `json:"value"`
+	Code   string `json:"code,omitempty"`
+	Clicks int64  `json:"clicks"`
+}
+
+type Breakdowns struct {
+	Countries []BreakdownItem `json:"countries"`
+	Cities    []BreakdownItem `json:"cities"`
+	Browsers  []BreakdownItem `json:"browsers"`
+	OS        []BreakdownItem `json:"os"`
+	Devices   []BreakdownItem `json:"devices"`
+	Referrers []BreakdownItem `json:"referrers"`
+	Sources   []BreakdownItem `json:"sources"`
+}
+
+type TopLink struct {
+	ShortLink     string `json:"short_link"`
+	FullShortLink string `json:"full_short_link"`
+	OriginalLink  string `json:"original_link"`
+	TotalClicks   int64  `json:"total_clicks"`
+	QRClicks      int64  `json:"qr_clicks"`
+	DirectClicks  int64  `json:"direct_clicks"`
+}
+
+// Report is the analytics of an account or of a single link over a period.
+// Hourly has 24 entries indexed by hour and Weekday 7 entries starting on Sunday, both in the period's time zone.
+type Report struct {
+	Period     Period        `json:"period"`
+	ShortLink  string        `json:"short_link,omitempty"`
+	Totals     Totals        `json:"totals"`
+	Daily      []DailyClicks `json:"daily"`
+	Hourly     []int64       `json:"hourly"`
+	Weekday    []int64       `json:"weekday"`
+	Breakdowns Breakdowns    `json:"breakdowns"`
+	TopLinks   []TopLink     `json:"top_links,omitempty"`
+	Comparison *Comparison   `json:"comparison,omitempty"`
+}
+
+type TotalsResponse struct {
+	Period Period `json:"period"`
+	Totals Totals `json:"totals"`
+}
+
+// Change compares a counter with the comparison period. ChangePercent is null when the previous value is 0.
+type Change struct {
+	Current       int64    `json:"current"`
+	Previous      int64    `json:"previous"`
+	Delta         int64    `json:"delta"`
+	ChangePercent *float64 `json:"change_percent"`
+}
+
+type BreakdownChange struct {
+	Value string `json:"value"`
+	Change
+}
+
+type Comparison struct {
+	Mode           string                       `json:"mode"`
+	Period         Period                       `json:"period"`
+	TotalClicks    Change                       `json:"total_clicks"`
+	UniqueVisitors Change                       `json:"unique_visitors"`
+	QRClicks       Change                       `json:"qr_clicks"`
+	DirectClicks   Change                       `json:"direct_clicks"`
+	Breakdowns     map[string][]BreakdownChange `json:"breakdowns"`
+}
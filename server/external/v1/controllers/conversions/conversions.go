@@ -0,0 +1,93 @@
+package conversions
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// PixelGIF is the transparent 1x1 GIF returned by the conversion pixel
+var PixelGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// RecordConversionHandler records a conversion against a click of one of the account's links
+func RecordConversionHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		if uid == "" {
+			response.SendUnAuthorizedError(c, "Unauthorized")
+			return
+		}
+
+		var payload services.Conversion
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid JSON body")
+			return
+		}
+		if err := payload.Normalize(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		recorded, err := services.RecordConversion(uid, payload)
+		if err != nil {
+			if err == services.ErrClickNotFound {
+				response.SendNotFoundError(c, "Click not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		message := "Conversion recorded"
+		if !recorded {
+			message = "Conversion already recorded"
+		}
+		response.SendJSON(c, gin.H{
+			"click_id": payload.ClickID,
+			"goal":     payload.Goal,
+			"recorded": recorded,
+			"message":  message,
+		})
+	}
+}
+
+// ConversionPixelHandler records a conversion from an image request. Browsers can't hold an API key,
+// so the click ID alone decides the account. A pixel is always returned so pages never show a broken image.
+func ConversionPixelHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conversion := services.Conversion{
+			ClickID:  c.Query(services.ClickIDParam),
+			Goal:     c.Query("goal"),
+			Currency: c.Query("currency"),
+			EventID:  c.Query("event_id"),
+		}
+		if conversion.ClickID == "" {
+			conversion.ClickID = c.Query("click_id")
+		}
+		c.Header("Cache-Control", "no-store")
+		if v := c.Query("value"); v != "" {
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				c.Data(http.StatusBadRequest, "image/gif", PixelGIF)
+				return
+			}
+			conversion.Value = value
+		}
+
+		status := http.StatusOK
+		if _, err := services.RecordConversion("", conversion); err != nil {
+			status = http.StatusBadRequest
+			if err == services.ErrClickNotFound {
+				status = http.StatusNotFound
+			}
+		}
+
+		c.Data(status, "image/gif", PixelGIF)
+	}
+}
@@ -0,0 +1,9 @@
+package folders
+
+// FolderPayload creates or updates a folder. Dates are YYYY-MM-DD; an empty date clears it.
+type FolderPayload struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	StartDate   *string `json:"start_date"`
+	EndDate     *string `json:"end_date"`
+}
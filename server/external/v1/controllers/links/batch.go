@@ -0,0 +1,95 @@
+package links
+
+import (
+	"fmt"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateLinkBatchHandler queues the creation of many links from a JSON array or a CSV upload
+func CreateLinkBatchHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		source, rows, err := services.ReadLinkBatchRequest(c.Writer, c.Request)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		if len(rows) == 0 || len(rows) > services.MaxBatchRows {
+			response.SendBadRequestError(c, fmt.Sprintf("A batch must contain between 1 and %d links", services.MaxBatchRows))
+			return
+		}
+
+		batch, err := services.CreateLinkBatch(uid, source, rows)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendAcceptedJSON(c, batch)
+	}
+}
+
+// ImportLinksHandler queues the import of a CSV file exported from another shortener. The
+// provider query parameter names the shortener; progress is reported like any batch.
+func ImportLinksHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		provider := c.DefaultQuery("provider", services.ImportOther)
+		if !services.ValidImportProvider(provider) {
+			response.SendBadRequestError(c, "Unknown provider: "+provider)
+			return
+		}
+		rows, err := services.ReadLinkImportRequest(c.Writer, c.Request)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		if len(rows) == 0 {
+			response.SendBadRequestError(c, "The file has no links to import")
+			return
+		}
+
+		batch, err := services.CreateLinkImport(uid, provider, rows)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendAcceptedJSON(c, batch)
+	}
+}
+
+// GetLinkBatchHandler returns the progress of a batch, including its download token once done
+func GetLinkBatchHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		batch, err := services.GetLinkBatch(c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			if err == services.ErrLinkBatchNotFound {
+				response.SendNotFoundError(c, "Batch not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, batch)
+	}
+}
+
+// DownloadLinkBatchHandler serves the result file of a finished batch
+func DownloadLinkBatchHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		batch, path, err := services.LinkBatchDownload(c.GetString("uid"), c.Param("token"))
+		if err != nil {
+			if err == services.ErrLinkBatchNotFound {
+				response.SendNotFoundError(c, "Batch results not found or expired")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.FileAttachment(path, "links-batch-"+batch.ID+".csv")
+	}
+}
@@ -0,0 +1,34 @@
+package links
+
+import (
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// BulkLinksHandler applies one action to a list of links or to every link matching a filter,
+// reporting the outcome of each link
+func BulkLinksHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload services.BulkLinkAction
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		if err := payload.Validate(); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		report, err := services.ApplyBulkLinkAction(c.GetString("uid"), payload)
+		if err != nil {
+			if err == services.ErrTooManyBulkLinks || err == services.ErrFolderNotFound {
+				response.SendBadRequestError(c, err.Error())
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, report)
+	}
+}
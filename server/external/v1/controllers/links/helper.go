@@ -5,6 +5,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,6 +19,7 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -79,15 +83,49 @@ func IsValidURL(sot string) bool {
 	return err == nil
 }
 
+// metadataClient fetches destination pages for previews and search, giving up on slow sites.
+// Destinations are user supplied, so it only reaches public addresses and doesn't follow redirects.
+var metadataClient = services.NewPublicClient(10 * time.Second)
+
+// metadataMaxBody is the most of a destination page read for its metadata
+const metadataMaxBody = 512 << 10
+
+// metadataSlots bounds how many destination pages are fetched in the background at once
+var metadataSlots = make(chan struct{}, 8)
+
+// captureMetadata stores the title and description of a link's destination in the background
+// so searches can match them
+func captureMetadata(shortLink, originalURL string) {
+	go func() {
+		metadataSlots <- struct{}{}
+		defer func() { <-metadataSlots }()
+
+		data, err := fetchMetadata(originalURL)
+		if err != nil {
+			log.Printf("Failed to fetch metadata of %s: %v", shortLink, err)
+			return
+		}
+		if err := services.SaveLinkMetadata(shortLink, originalURL, data.Title, data.Description); err != nil {
+			log.Printf("Failed to save metadata of %s: %v", shortLink, err)
+		}
+	}()
+}
+
 func fetchMetadata(link string) (*PreviewData, error) {
-	resp, err := http.Get(link)
+	resp, err := metadataClient.Get(link)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return nil, fmt.Errorf("not an HTML page: %s", mediaType)
+	}
 
 	// Parse HTML
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, metadataMaxBody))
 	if err != nil {
 		return nil, err
 	}
@@ -377,3 +415,8 @@ func buildShortLinkURLsBatch(links []Link) error {
 
 	return nil
 }
+
+// apiKeyActor names the API key making the request as the author of link revisions
+func apiKeyActor(c *gin.Context) string {
+	return "api_key:" + c.GetString("api_key_id")
+}
@@ -0,0 +1,19 @@
+package links
+
+import (
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GetLinkHistoryHandler lists the revisions of a link, newest first
+func GetLinkHistoryHandler() gin.HandlerFunc {
+	return services.LinkHistoryHandler()
+}
+
+// RollbackLinkHandler restores a link to an earlier revision
+func RollbackLinkHandler() gin.HandlerFunc {
+	return services.RollbackLinkHandler(func(c *gin.Context) string { return apiKeyActor(c) }, func(revision services.LinkRevision) {
+		// The restored destination may differ from the one whose page was captured
+		captureMetadata(revision.ShortLink, revision.OriginalURL)
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/RishiKendai/sot/pkg/config/response"
 	"github.com/RishiKendai/sot/pkg/database/postgres"
 	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+	"github.com/RishiKendai/sot/pkg/services"
 	"github.com/RishiKendai/sot/service/counter"
 	"github.com/gin-gonic/gin"
 )
@@ -51,39 +52,54 @@ func GetLinksHandler() gin.HandlerFunc {
 
 		offset := (page - 1) * pageSize
 
+		// Optionally only the links of a folder or with some tags
+		filter, err := services.ParseLinkFilter(c.Request.URL.Query())
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		listing := filter.Build(uid)
+		where, args := listing.Where, listing.Args
+
 		var (
 			total int
 			links []Link
+			keys  []services.LinkKey
 			wg    sync.WaitGroup
 			errs  = make(chan error, 2)
 		)
 
-		// Run total count and data query concurrently
-		wg.Add(2)
-
-		go func() {
-			defer wg.Done()
-			row, err := postgres.FindOne("SELECT COUNT(*) FROM links WHERE user_uid = $1 AND deleted = false", uid)
-			if err != nil {
-				errs <- err
-				return
-			}
-			if err := row.Scan(&total); err != nil {
-				errs <- err
-				return
-			}
-		}()
+		// Run total count and data query concurrently. Pages read by cursor aren't counted.
+		if filter.HasCursor() {
+			offset = 0
+		} else {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				row, err := postgres.FindOne("SELECT COUNT(*) FROM links WHERE "+where, args...)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := row.Scan(&total); err != nil {
+					errs <- err
+					return
+				}
+			}()
+		}
 
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			query := `SELECT uid, user_uid, original_link, short_link, is_custom_backoff, created_at, expiry_date,
-				password, is_flagged, updated_at, tags
+			query := fmt.Sprintf(`SELECT uid, user_uid, original_link, short_link, is_custom_backoff, created_at, expiry_date,
+				password, is_flagged, updated_at, tags, folder_id, title, description, notes, %s
 				FROM links 
-				WHERE user_uid = $1 AND deleted = false 
-				ORDER BY created_at DESC 
-				LIMIT $2 OFFSET $3`
+				WHERE %s
+				ORDER BY %s
+				LIMIT $%d OFFSET $%d`, listing.SortKey, where, listing.OrderBy, len(args)+1, len(args)+2)
 
-			rows, err := postgres.FindMany(query, uid, pageSize, offset)
+			// One extra row tells whether there is another page
+			rows, err := postgres.FindMany(query, append(args[:len(args):len(args)], pageSize+1, offset)...)
 			if err != nil {
 				errs <- err
 				return
@@ -92,12 +108,13 @@ func GetLinksHandler() gin.HandlerFunc {
 
 			for rows.Next() {
 				var link Link
+				var key services.LinkKey
 				var tagsJSON []byte
 				if err := rows.Scan(
 					&link.Uid, &link.User_uid, &link.Original_url, &link.Short_link,
 					&link.Is_custom_backoff, &link.Created_at, &link.Expiry_date,
 					&link.Password, &link.Is_flagged, &link.Updated_at,
-					&tagsJSON,
+					&tagsJSON, &link.Folder_id, &link.Title, &link.Description, &link.Notes, &key.Sort,
 				); err != nil {
 					errs <- err
 					return
@@ -114,29 +131,13 @@ func GetLinksHandler() gin.HandlerFunc {
 					link.Tags = []string{}
 				}
 
+				key.UID = link.Uid
 				links = append(links, link)
+				keys = append(keys, key)
 			}
-
-			// Build full short link URLs efficiently in batch
-			if err := buildShortLinkURLsBatch(links); err != nil {
+			if err := rows.Err(); err != nil {
 				errs <- err
-				return
 			}
-
-			// Send the response
-			response := map[string]interface{}{
-				"links": links,
-				"pagination": gin.H{
-					"total":        total,
-					"count":        len(links),
-					"per_page":     pageSize,
-					"current_page": page,
-					"total_pages":  int(math.Ceil(float64(total) / float64(pageSize))),
-					"has_next":     page < int(math.Ceil(float64(total)/float64(pageSize))),
-					"has_prev":     page > 1,
-				},
-			}
-			c.JSON(http.StatusOK, response)
 		}()
 
 		wg.Wait()
@@ -146,6 +147,32 @@ func GetLinksHandler() gin.HandlerFunc {
 			response.SendServerError(c, err)
 			return
 		}
+
+		links, next, prev := services.PageLinks(filter, links, keys, pageSize, offset > 0)
+
+		// Build full short link URLs efficiently in batch
+		if err := buildShortLinkURLsBatch(links); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+
+		pagination := gin.H{
+			"count":       len(links),
+			"per_page":    pageSize,
+			"has_next":    next != "",
+			"has_prev":    prev != "",
+			"next_cursor": next,
+			"prev_cursor": prev,
+		}
+		if !filter.HasCursor() {
+			pagination["total"] = total
+			pagination["current_page"] = page
+			pagination["total_pages"] = int(math.Ceil(float64(total) / float64(pageSize)))
+		}
+		c.JSON(http.StatusOK, map[string]interface{}{
+			"links":      links,
+			"pagination": pagination,
+		})
 	}
 }
 
@@ -163,6 +190,10 @@ func CreateShortURLHandler() gin.HandlerFunc {
 			response.SendBadRequestError(c, "original_url is required")
 			return
 		}
+		if err := services.ValidateDestination(payload.Original_url); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
 
 		// Normalize expiry to UTC
 		if !payload.Expiry_date.IsZero() {
@@ -217,11 +248,26 @@ func CreateShortURLHandler() gin.HandlerFunc {
 			expiry = time.Now().Add(30 * 24 * time.Hour).UTC()
 		}
 
+		folderID, err := services.LinkFolder(uid, payload.Folder_id)
+		if err != nil {
+			if err == services.ErrFolderNotFound {
+				response.SendBadRequestError(c, "Folder not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		notes, err := services.CleanLinkNotes(payload.Notes)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
 		// Prepare insert query
 		query := `
 			INSERT INTO links 
-			(user_uid, original_link, short_link, expiry_date, password, is_flagged, is_custom_backoff, tags)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			(user_uid, original_link, short_link, expiry_date, password, is_flagged, is_custom_backoff, tags, folder_id, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
 		`
 
 		_, err = postgres.InsertOne(
@@ -234,6 +280,8 @@ func CreateShortURLHandler() gin.HandlerFunc {
 			payload.Is_flagged,
 			isCustom,
 			payload.Tags,
+			folderID,
+			notes,
 		)
 		if err != nil {
 			// Custom backoff collision? Return 409 Conflict
@@ -251,6 +299,14 @@ func CreateShortURLHandler() gin.HandlerFunc {
 			redisExpiry = diff
 		}
 		rdb.RC.Set(sc, payload.Original_url, &redisExpiry)
+		captureMetadata(sc, payload.Original_url)
+
+		services.EmitLinkEvent(uid, services.EventLinkCreated, services.LinkEvent{
+			ShortLink:   sc,
+			OriginalURL: payload.Original_url,
+			ExpiryDate:  &expiry,
+			Tags:        payload.Tags,
+		})
 
 		// Return full short URL
 		base := env.GetEnvKey("SERVER_DOMAIN")
@@ -275,6 +331,10 @@ func UpdateLinkHandler() gin.HandlerFunc {
 			response.SendBadRequestError(c, "Invalid request body")
 			return
 		}
+		if err := services.ValidateDestination(payload.Original_url); err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
 
 		if !payload.Expiry_date.IsZero() {
 			payload.Expiry_date = payload.Expiry_date.UTC()
@@ -348,14 +408,68 @@ func UpdateLinkHandler() gin.HandlerFunc {
 			expiry = existingLink.Expiry_date.UTC()
 		}
 
-		_, err = postgres.UpdateOne(
-			"UPDATE links SET original_link = $1, short_link = $2, expiry_date = $3, password = $4, is_flagged = $5, is_custom_backoff = $6, updated_at = NOW(), tags = $7 WHERE short_link = $8 AND user_uid = $9",
-			payload.Original_url, newShortCode, expiry, payload.Password, payload.Is_flagged, isCustom, payload.Tags, existingLink.Short_link, uid,
+		folderID, err := services.LinkFolder(uid, payload.Folder_id)
+		if err != nil {
+			if err == services.ErrFolderNotFound {
+				response.SendBadRequestError(c, "Folder not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		notes, err := services.CleanLinkNotes(payload.Notes)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+
+		// The link and its revision are saved together
+		before := services.LinkState{
+			OriginalURL:     existingLink.Original_url,
+			ShortLink:       existingLink.Short_link,
+			ExpiryDate:      existingLink.Expiry_date,
+			Tags:            existingLink.Tags,
+			Password:        existingLink.Password,
+			IsFlagged:       existingLink.Is_flagged,
+			IsCustomBackoff: existingLink.Is_custom_backoff,
+		}
+		after := services.LinkState{
+			OriginalURL:     payload.Original_url,
+			ShortLink:       newShortCode,
+			ExpiryDate:      expiry,
+			Tags:            payload.Tags,
+			Password:        payload.Password,
+			IsFlagged:       payload.Is_flagged,
+			IsCustomBackoff: isCustom,
+		}
+		tx, err := postgres.Begin()
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		defer tx.Rollback()
+
+		// A folder or notes left out are kept, the captured page metadata only while the destination stays the same
+		_, err = tx.Exec(
+			`UPDATE links SET original_link = $1, short_link = $2, expiry_date = $3, password = $4, is_flagged = $5, is_custom_backoff = $6, updated_at = NOW(), tags = $7,
+				folder_id = CASE WHEN $12::boolean THEN $10 ELSE folder_id END,
+				notes = CASE WHEN $11::text IS NULL THEN notes ELSE NULLIF($11, '') END,
+				title = CASE WHEN original_link = $1 THEN title END, description = CASE WHEN original_link = $1 THEN description END
+				WHERE short_link = $8 AND user_uid = $9`,
+			payload.Original_url, newShortCode, expiry, payload.Password, payload.Is_flagged, isCustom, payload.Tags, existingLink.Short_link, uid, folderID, notes, payload.Folder_id != nil,
 		)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+		if err := services.RecordLinkRevision(tx, uid, existingLink.Uid, before, after, apiKeyActor(c), services.RevisionAPI); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			response.SendServerError(c, err)
+			return
+		}
 
 		// Update Redis cache
 		redisExpiry := time.Hour * 5
@@ -366,6 +480,20 @@ func UpdateLinkHandler() gin.HandlerFunc {
 			rdb.RC.Del(existingLink.Short_link)
 		}
 		rdb.RC.Set(newShortCode, payload.Original_url, &redisExpiry)
+		if payload.Original_url != existingLink.Original_url {
+			captureMetadata(newShortCode, payload.Original_url)
+		}
+
+		event := services.LinkEvent{
+			ShortLink:   newShortCode,
+			OriginalURL: payload.Original_url,
+			ExpiryDate:  &expiry,
+			Tags:        payload.Tags,
+		}
+		if newShortCode != existingLink.Short_link {
+			event.PreviousShortLink = existingLink.Short_link
+		}
+		services.EmitLinkEvent(uid, services.EventLinkUpdated, event)
 
 		base := env.GetEnvKey("SERVER_DOMAIN")
 		if base == "" {
@@ -404,26 +532,20 @@ func DeleteLinkHandler() gin.HandlerFunc {
 			return
 		}
 
-		// Soft delete in DB
-		_, err = postgres.UpdateOne(
-			"UPDATE links SET deleted = TRUE WHERE short_link = $1 AND user_uid = $2",
-			shortLinkID, uid,
-		)
-		if err != nil {
-			response.SendServerError(c, err)
-			return
-		}
+		// Soft delete in DB: the link moves to the trash until it is restored or purged
 		_, err = postgres.UpdateOne(
-			"UPDATE analytics SET deleted = TRUE WHERE short_link = $1 AND user_uid = $2",
-			shortCode, uid,
+			"UPDATE links SET deleted = TRUE, deleted_at = $3 WHERE short_link = $1 AND user_uid = $2",
+			shortLinkID, uid, time.Now().UTC(),
 		)
 		if err != nil {
 			response.SendServerError(c, err)
 			return
 		}
+		services.EmitLinkEvent(uid, services.EventLinkDeleted, services.LinkEvent{ShortLink: shortCode})
 
 		// Clean up Redis cache
 		rdb.RC.Del(shortCode)
+		rdb.RC.Del("links:" + shortCode)
 
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "success",
@@ -9,6 +9,8 @@ type CreateShortURLPayload struct {
 	Is_flagged     bool      `json:"is_flagged,omitempty"`
 	Custom_backoff string    `json:"custom_backoff,omitempty"`
 	Tags           []string  `json:"tags,omitempty"`
+	Folder_id      *string   `json:"folder_id,omitempty"`
+	Notes          *string   `json:"notes,omitempty"`
 }
 
 type Link struct {
@@ -24,6 +26,10 @@ type Link struct {
 	Is_custom_backoff bool      `json:"is_custom_backoff"`
 	Updated_at        time.Time `json:"updated_at"`
 	Tags              []string  `json:"tags"`
+	Folder_id         *string   `json:"folder_id"`
+	Title             *string   `json:"title"`       // captured from the destination page
+	Description       *string   `json:"description"` // captured from the destination page
+	Notes             *string   `json:"notes"`
 }
 
 type PreviewData struct {
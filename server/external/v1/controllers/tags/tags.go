@@ -0,0 +1,120 @@
+package tags
+
+import (
+	"strings"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GetTagsHandler lists the tags of the user with how many links have each
+func GetTagsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tags, err := services.ListTags(c.GetString("uid"))
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"tags": tags})
+	}
+}
+
+// GetTagAnalyticsHandler returns the clicks per tag between start_date and end_date, calendar
+// days in the requested or preferred time zone. Defaults to the last 30 days.
+func GetTagAnalyticsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetString("uid")
+		tz, err := services.ResolveTimeZone(c.Query("tz"), uid)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		startDate, endDate := services.DefaultDateRange(c.Query("start_date"), c.Query("end_date"), tz)
+
+		scope, err := services.NewStatsScope(uid, "", startDate, endDate, tz)
+		if err != nil {
+			response.SendBadRequestError(c, err.Error())
+			return
+		}
+		tags, err := services.FetchTagClicks(scope)
+		if err != nil {
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{
+			"tags":       tags,
+			"start_date": startDate,
+			"end_date":   endDate,
+			"time_zone":  tz,
+		})
+	}
+}
+
+// RenameTagHandler renames a tag on every link of the user
+func RenameTagHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload RenameTagPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		if strings.TrimSpace(payload.Tag) == "" || strings.TrimSpace(payload.NewName) == "" {
+			response.SendBadRequestError(c, "tag and new_name are required")
+			return
+		}
+
+		changed, err := services.RenameTag(c.GetString("uid"), payload.Tag, payload.NewName)
+		if err != nil {
+			if err == services.ErrTagNotFound {
+				response.SendNotFoundError(c, "Tag not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"links": changed.Links, "message": "Tag renamed"})
+	}
+}
+
+// MergeTagsHandler replaces several tags with one on every link of the user
+func MergeTagsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload MergeTagsPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			response.SendBadRequestError(c, "Invalid request body")
+			return
+		}
+		if len(payload.Tags) == 0 || strings.TrimSpace(payload.Into) == "" {
+			response.SendBadRequestError(c, "tags and into are required")
+			return
+		}
+
+		changed, err := services.MergeTags(c.GetString("uid"), payload.Tags, payload.Into)
+		if err != nil {
+			if err == services.ErrTagNotFound {
+				response.SendNotFoundError(c, "Tag not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"links": changed.Links, "message": "Tags merged"})
+	}
+}
+
+// DeleteTagHandler removes a tag from every link of the user
+func DeleteTagHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changed, err := services.DeleteTag(c.GetString("uid"), c.Param("tag"))
+		if err != nil {
+			if err == services.ErrTagNotFound {
+				response.SendNotFoundError(c, "Tag not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"links": changed.Links, "message": "Tag deleted"})
+	}
+}
@@ -2,9 +2,21 @@ package externalV1
 
 import (
 	"github.com/RishiKendai/sot/external/v1/routes"
+	"github.com/RishiKendai/sot/middleware"
+	"github.com/RishiKendai/sot/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterRoutes(router *gin.RouterGroup) {
+	// The conversion pixel authenticates with the click ID it carries, so it is registered before the middleware
+	routes.ConversionPixel(router)
+
+	// Every other external route authenticates with an API key and shares its rate limit
+	router.Use(services.ExternalAuthenticate(), middleware.ExternalRateLimiter())
+
 	routes.Links(router)
+	routes.Analytics(router)
+	routes.Conversions(router)
+	routes.Folders(router)
+	routes.Tags(router)
 }
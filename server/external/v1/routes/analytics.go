@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/external/v1/controllers/analytics"
+	"github.com/gin-gonic/gin"
+)
+
+func Analytics(router *gin.RouterGroup) {
+	router.GET("/analytics", analytics.GetAnalyticsHandler())
+	router.GET("/analytics/totals", analytics.GetTotalsHandler())
+	router.GET("/analytics/links/:id", analytics.GetLinkAnalyticsHandler())
+}
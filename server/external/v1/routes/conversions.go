@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/external/v1/controllers/conversions"
+	"github.com/RishiKendai/sot/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func Conversions(router *gin.RouterGroup) {
+	router.POST("/conversions", conversions.RecordConversionHandler())
+}
+
+func ConversionPixel(router *gin.RouterGroup) {
+	router.GET("/conversions/pixel.gif", middleware.PixelRateLimiter(conversions.PixelGIF), conversions.ConversionPixelHandler())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/external/v1/controllers/folders"
+	"github.com/gin-gonic/gin"
+)
+
+func Folders(router *gin.RouterGroup) {
+	router.GET("/folders", folders.GetFoldersHandler())
+	router.POST("/folders", folders.CreateFolderHandler())
+	router.GET("/folders/:id", folders.GetFolderHandler())
+	router.PUT("/folders/:id", folders.UpdateFolderHandler())
+	router.DELETE("/folders/:id", folders.DeleteFolderHandler())
+}
@@ -2,15 +2,19 @@ package routes
 
 import (
 	"github.com/RishiKendai/sot/external/v1/controllers/links"
-	"github.com/RishiKendai/sot/middleware"
-	"github.com/RishiKendai/sot/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
 func Links(router *gin.RouterGroup) {
-	router.Use(services.ExternalAuthenticate(), middleware.ExternalRateLimiter())
 	router.GET("/links", links.GetLinksHandler())
 	router.POST("/links", links.CreateShortURLHandler())
+	router.POST("/links/batch", links.CreateLinkBatchHandler())
+	router.POST("/links/bulk", links.BulkLinksHandler())
+	router.POST("/links/import", links.ImportLinksHandler())
+	router.GET("/links/batch/:id", links.GetLinkBatchHandler())
+	router.GET("/links/batch/download/:token", links.DownloadLinkBatchHandler())
 	router.PUT("/links/:id", links.UpdateLinkHandler())
 	router.DELETE("/links/:id", links.DeleteLinkHandler())
+	router.GET("/links/:id/history", links.GetLinkHistoryHandler())
+	router.POST("/links/:id/history/:revision/rollback", links.RollbackLinkHandler())
 }
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/RishiKendai/sot/external/v1/controllers/tags"
+	"github.com/gin-gonic/gin"
+)
+
+func Tags(router *gin.RouterGroup) {
+	router.GET("/tags", tags.GetTagsHandler())
+	router.GET("/tags/analytics", tags.GetTagAnalyticsHandler())
+	router.POST("/tags/rename", tags.RenameTagHandler())
+	router.POST("/tags/merge", tags.MergeTagsHandler())
+	router.DELETE("/tags/:tag", tags.DeleteTagHandler())
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"time"
+	_ "time/tzdata" // analytics time zones must resolve even on hosts without zoneinfo
 
 	apiV1 "github.com/RishiKendai/sot/api/v1"
 	routes "github.com/RishiKendai/sot/api/v1/routes"
@@ -13,8 +16,11 @@ import (
 	mongodb "github.com/RishiKendai/sot/pkg/database/mongo"
 	"github.com/RishiKendai/sot/pkg/database/postgres"
 	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+	"github.com/RishiKendai/sot/pkg/services"
+	"github.com/RishiKendai/sot/service/batches"
 	"github.com/RishiKendai/sot/service/counter"
 	"github.com/RishiKendai/sot/service/cron"
+	"github.com/RishiKendai/sot/service/reports"
 	"github.com/gin-gonic/gin"
 )
 
@@ -26,10 +32,24 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate-analytics" {
+		migrateAnalytics()
+		return
+	}
+
 	port := env.EnvPort()
 
 	// Start analytics cron service in background
 	go cron.RunWithInterval(8 * time.Second)
+	go cron.Every("analytics exports", 10*time.Second, services.ProcessExportJobs)
+	go cron.Every("traffic anomaly detection", 5*time.Minute, services.DetectTrafficAnomalies)
+	go cron.Every("analytics reports", time.Minute, reports.SendDueReports)
+	go cron.Every("analytics maintenance", 6*time.Hour, services.RunAnalyticsMaintenance)
+	go cron.Every("webhook deliveries", 5*time.Second, services.ProcessWebhookDeliveries)
+	go cron.Every("link batches", 5*time.Second, batches.ProcessLinkBatches)
+	go cron.Every("trash purge", time.Hour, services.PurgeExpiredTrash)
+	go cron.Once("referrer backfill", services.BackfillReferrers)
+	go services.PublishReceivedClicks()
 	fmt.Println("Cron service started")
 
 	router := gin.Default()
@@ -72,3 +92,15 @@ func main() {
 
 	router.Run(":" + port)
 }
+
+// migrateAnalytics partitions an analytics table created before partitioning. Referrers are
+// classified first, so no click changes after it has been copied.
+func migrateAnalytics() {
+	if err := services.BackfillReferrers(); err != nil {
+		log.Fatalf("❌ Failed to backfill referrers: %v", err)
+	}
+	if err := postgres.MigrateAnalyticsPartitions(); err != nil {
+		log.Fatalf("❌ Failed to partition analytics table: %v", err)
+	}
+	fmt.Println("Analytics table partitioned ✅")
+}
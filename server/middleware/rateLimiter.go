@@ -133,3 +133,44 @@ func ExternalRateLimiter() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// PixelRateLimiter limits unauthenticated requests such as the conversion pixel per client IP.
+// Limited requests still get the pixel so pages never show a broken image.
+func PixelRateLimiter(pixel []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cntr_key := fmt.Sprintf("ratelimit:pixel:counter:%s", c.ClientIP())
+
+		// Get current count
+		cntr, err := rdb.RC.GetInt(cntr_key)
+		if err != nil && err.Error() != "redis: nil" {
+			fmt.Println("RateLimiter Redis error: ", err)
+			c.Data(http.StatusInternalServerError, "image/gif", pixel)
+			c.Abort()
+			return
+		}
+
+		if cntr >= RateLimitMaxRequests {
+			c.Header("Retry-After", strconv.Itoa(RateLimitWindowSeconds))
+			c.Data(http.StatusTooManyRequests, "image/gif", pixel)
+			c.Abort()
+			return
+		}
+
+		// Increment or set count
+		if cntr == 0 {
+			// New key – set with expiry
+			expiry := RateLimitWindowSeconds * time.Second
+			err = rdb.RC.Set(cntr_key, 1, &expiry)
+		} else {
+			// Increment – preserve expiry
+			err = rdb.RC.Set(cntr_key, cntr+1, nil)
+		}
+		if err != nil {
+			fmt.Println("RateLimiter SET error: ", err)
+			c.Data(http.StatusInternalServerError, "image/gif", pixel)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
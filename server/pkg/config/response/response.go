@@ -42,6 +42,14 @@ func SendAccepted(context *gin.Context, message string) {
 	})
 }
 
+// 202 with data
+func SendAcceptedJSON(c *gin.Context, data any) {
+	c.JSON(http.StatusAccepted, bson.M{
+		"status": "success",
+		"data":   data,
+	})
+}
+
 // ServeHTML renders an HTML template with the given status code, template name, and data.
 func ServeHTML(c *gin.Context, status int, name string, obj interface{}) {
 	c.HTML(status, name, obj)
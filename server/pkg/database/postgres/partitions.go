@@ -0,0 +1,381 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The analytics table is range partitioned by month on click_timestamp (UTC). Monthly partitions
+// are named analytics_pYYYY_MM; clicks outside every monthly partition land in analytics_default.
+const (
+	analyticsPartitionPrefix = "analytics_p"
+	analyticsPartitionLayout = "2006_01"
+	analyticsDefaultPart     = "analytics_default"
+	// Monthly partitions are created this many months ahead of the current month
+	analyticsPartitionsAhead = 2
+	// Clicks copied per statement when partitioning a table from before partitioning
+	analyticsCopyBatch = 10000
+)
+
+// AnalyticsPartition is a monthly partition of the analytics table covering [From, To)
+type AnalyticsPartition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func analyticsPartitionFor(month time.Time) AnalyticsPartition {
+	from := monthStart(month)
+	return AnalyticsPartition{
+		Name: analyticsPartitionPrefix + from.Format(analyticsPartitionLayout),
+		From: from,
+		To:   from.AddDate(0, 1, 0),
+	}
+}
+
+// ListAnalyticsPartitions returns the monthly partitions of the analytics table, oldest first
+func ListAnalyticsPartitions() ([]AnalyticsPartition, error) {
+	rows, err := DB.Query(`
+		SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		JOIN pg_namespace n ON n.oid = p.relnamespace
+		WHERE p.relname = 'analytics' AND n.nspname = current_schema()
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []AnalyticsPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(name, analyticsPartitionPrefix) {
+			continue
+		}
+		month, err := time.Parse(analyticsPartitionLayout, strings.TrimPrefix(name, analyticsPartitionPrefix))
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, analyticsPartitionFor(month))
+	}
+	return partitions, rows.Err()
+}
+
+// EnsureAnalyticsPartitions creates the monthly partitions covering from through to. Clicks of a new
+// month that already landed in the default partition are moved into it. Nothing is done until a
+// table from before partitioning has been migrated.
+func EnsureAnalyticsPartitions(from, to time.Time) error {
+	if kind, err := analyticsRelKind(); err != nil || kind != "p" {
+		return err
+	}
+	existing, err := ListAnalyticsPartitions()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, p := range existing {
+		exists[p.Name] = true
+	}
+
+	for month := monthStart(from); !month.After(to); month = month.AddDate(0, 1, 0) {
+		p := analyticsPartitionFor(month)
+		if exists[p.Name] {
+			continue
+		}
+		if err := createAnalyticsPartition(p); err != nil {
+			return fmt.Errorf("failed to create partition %s: %v", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// EnsureUpcomingAnalyticsPartitions creates the partitions of the current month and the months ahead
+func EnsureUpcomingAnalyticsPartitions() error {
+	now := time.Now().UTC()
+	return EnsureAnalyticsPartitions(now, now.AddDate(0, analyticsPartitionsAhead, 0))
+}
+
+// createAnalyticsPartition creates the partition detached, fills it from the default partition and
+// attaches it, as a partition can't be created while the default partition holds rows of its range
+func createAnalyticsPartition(p AnalyticsPartition) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE analytics INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", p.Name),
+		fmt.Sprintf(`
+			WITH moved AS (
+				DELETE FROM %s WHERE click_timestamp >= '%s' AND click_timestamp < '%s' RETURNING *
+			)
+			INSERT INTO %s SELECT * FROM moved
+		`, analyticsDefaultPart, p.From.Format(time.DateTime), p.To.Format(time.DateTime), p.Name),
+		fmt.Sprintf("ALTER TABLE analytics ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')",
+			p.Name, p.From.Format(time.DateTime), p.To.Format(time.DateTime)),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DropAnalyticsPartitionsBefore drops the monthly partitions holding only clicks before cutoff
+// and returns their names
+func DropAnalyticsPartitionsBefore(cutoff time.Time) ([]string, error) {
+	partitions, err := ListAnalyticsPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for _, p := range partitions {
+		if p.To.After(cutoff) {
+			continue
+		}
+		if _, err := DB.Exec("DROP TABLE IF EXISTS " + p.Name); err != nil {
+			return dropped, fmt.Errorf("failed to drop partition %s: %v", p.Name, err)
+		}
+		dropped = append(dropped, p.Name)
+	}
+	return dropped, nil
+}
+
+// analyticsRelKind returns the kind of the analytics relation: "" when it doesn't exist,
+// "r" for a plain table and "p" for a partitioned table
+func analyticsRelKind() (string, error) {
+	var kind string
+	err := DB.QueryRow(`
+		SELECT c.relkind::text FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relname = 'analytics' AND n.nspname = current_schema()
+	`).Scan(&kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return kind, err
+}
+
+// MigrateAnalyticsPartitions moves an analytics table created before partitioning into a
+// partitioned table, while the old table keeps serving reads and writes. The clicks are copied in
+// batches by id; only the last ones are copied with writes held, right before the tables are
+// swapped and the old one dropped. It does nothing once the table is partitioned and can be run
+// again after a failure.
+func MigrateAnalyticsPartitions() error {
+	kind, err := analyticsRelKind()
+	if err != nil || kind != "r" {
+		return err
+	}
+	if err := prepareAnalyticsPartitions(); err != nil {
+		return fmt.Errorf("failed to create partitioned table: %v", err)
+	}
+	columns, values, err := analyticsCopyColumns()
+	if err != nil {
+		return err
+	}
+
+	// Copy up to the last click written when each round starts, until a round finds less than a
+	// batch of new clicks
+	var copied int64
+	for {
+		cut, err := lastAnalyticsID()
+		if err != nil {
+			return err
+		}
+		var round int64
+		for {
+			last, n, err := copyAnalyticsBatch(columns, values, copied, cut)
+			if err != nil {
+				return fmt.Errorf("failed to copy clicks after id %d: %v", copied, err)
+			}
+			if n == 0 {
+				break
+			}
+			copied, round = last, round+n
+		}
+		copied = cut
+		if round < analyticsCopyBatch {
+			break
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		// Holds writes but not reads while the last clicks are copied
+		"LOCK TABLE analytics IN EXCLUSIVE MODE",
+		fmt.Sprintf("INSERT INTO analytics_partitioned (%s) SELECT %s FROM analytics WHERE id > %d",
+			strings.Join(columns, ", "), strings.Join(values, ", "), copied),
+		"ALTER TABLE analytics RENAME TO analytics_unpartitioned",
+		"ALTER TABLE analytics_partitioned RENAME TO analytics",
+		"ALTER TABLE analytics RENAME CONSTRAINT analytics_partitioned_pkey TO analytics_pkey",
+		"ALTER SEQUENCE analytics_id_seq OWNED BY analytics.id",
+		"DROP TABLE analytics_unpartitioned",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return EnsureUpcomingAnalyticsPartitions()
+}
+
+// prepareAnalyticsPartitions creates the empty partitioned table next to the old one, with a
+// partition per month of existing clicks. The names of the old table's primary key and indexes
+// are moved out of the way, so the new table gets them when the tables are swapped.
+func prepareAnalyticsPartitions() error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A previous run may have stopped halfway
+	if _, err := tx.Exec("DROP TABLE IF EXISTS analytics_partitioned"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT indexname FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename = 'analytics'
+			AND indexname LIKE 'idx\_analytics\_%' AND indexname NOT LIKE 'idx\_analytics\_unpartitioned\_%'
+	`)
+	if err != nil {
+		return err
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'analytics_pkey' AND conrelid = 'analytics'::regclass) THEN
+				ALTER TABLE analytics RENAME CONSTRAINT analytics_pkey TO analytics_unpartitioned_pkey;
+			END IF;
+		END $$`,
+		// The sequence of the SERIAL id keeps numbering both tables, so copied ids stay unique
+		"ALTER SEQUENCE IF EXISTS analytics_id_seq OWNED BY NONE",
+	}
+	for _, name := range indexes {
+		statements = append(statements, fmt.Sprintf("ALTER INDEX %s RENAME TO %s",
+			name, strings.Replace(name, "idx_analytics_", "idx_analytics_unpartitioned_", 1)))
+	}
+	statements = append(statements, analyticsTable("analytics_partitioned"), analyticsIndexes("analytics_partitioned"))
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	var oldest sql.NullTime
+	if err := tx.QueryRow("SELECT MIN(click_timestamp) FROM analytics").Scan(&oldest); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	from := now
+	if oldest.Valid && oldest.Time.Before(now) {
+		from = oldest.Time
+	}
+	for month := monthStart(from); !month.After(now.AddDate(0, analyticsPartitionsAhead, 0)); month = month.AddDate(0, 1, 0) {
+		p := analyticsPartitionFor(month)
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s PARTITION OF analytics_partitioned FOR VALUES FROM ('%s') TO ('%s')",
+			p.Name, p.From.Format(time.DateTime), p.To.Format(time.DateTime))); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// analyticsCopyColumns returns the columns both analytics tables have, with the expressions
+// copying them from the old table
+func analyticsCopyColumns() (columns, values []string, err error) {
+	rows, err := DB.Query(`
+		SELECT o.column_name FROM information_schema.columns o
+		JOIN information_schema.columns n ON n.table_schema = o.table_schema AND n.column_name = o.column_name AND n.table_name = 'analytics_partitioned'
+		WHERE o.table_schema = current_schema() AND o.table_name = 'analytics'
+		ORDER BY o.ordinal_position
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, column)
+		if column == "click_timestamp" {
+			// The partition key can't be NULL
+			column = "COALESCE(click_timestamp, created_at, CURRENT_TIMESTAMP)"
+		}
+		values = append(values, column)
+	}
+	return columns, values, rows.Err()
+}
+
+// lastAnalyticsID waits for the clicks being written to the old analytics table and returns the
+// highest id written. Clicks written afterwards take higher ids from the sequence.
+func lastAnalyticsID() (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("LOCK TABLE analytics IN SHARE MODE"); err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM analytics").Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// copyAnalyticsBatch copies the next batch of clicks with ids in (after, upTo] to the partitioned
+// table and returns the last id copied and how many were
+func copyAnalyticsBatch(columns, values []string, after, upTo int64) (last, n int64, err error) {
+	err = DB.QueryRow(fmt.Sprintf(`
+		WITH batch AS (
+			SELECT * FROM analytics WHERE id > $1 AND id <= $2 ORDER BY id LIMIT $3
+		), copied AS (
+			INSERT INTO analytics_partitioned (%s) SELECT %s FROM batch
+		)
+		SELECT COALESCE(MAX(id), 0), COUNT(*) FROM batch
+	`, strings.Join(columns, ", "), strings.Join(values, ", ")), after, upTo, analyticsCopyBatch).Scan(&last, &n)
+	return last, n, err
+}
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -26,6 +27,11 @@ func FindMany(query string, args ...interface{}) (*sql.Rows, error) {
 	return DB.Query(query, args...)
 }
 
+// FindManyContext fetches multiple records, cancelling the query when ctx is done
+func FindManyContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return DB.QueryContext(ctx, query, args...)
+}
+
 // UpdateOne updates a record in a table
 func UpdateOne(query string, args ...interface{}) (sql.Result, error) {
 	stmt, err := DB.Prepare(query)
@@ -68,3 +74,8 @@ func CountDocuments(query string, args ...interface{}) (int, error) {
 	}
 	return count, nil
 }
+
+// Begin starts a transaction
+func Begin() (*sql.Tx, error) {
+	return DB.Begin()
+}
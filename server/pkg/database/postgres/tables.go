@@ -1,6 +1,9 @@
 package postgres
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 func createUser() error {
 	query := `
@@ -12,8 +15,21 @@ func createUser() error {
 			subdomain VARCHAR(255) DEFAULT NULL,
 			use_subdomain BOOLEAN DEFAULT FALSE,
 			token_version INTEGER DEFAULT 1,
+			privacy_mode BOOLEAN DEFAULT FALSE,
+			conversion_tracking BOOLEAN DEFAULT FALSE,
+			timezone VARCHAR(64) DEFAULT 'UTC',
+			raw_retention_days INTEGER,
+			rollup_retention_days INTEGER,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);`
+		);
+
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS privacy_mode BOOLEAN DEFAULT FALSE;
+		-- Click IDs are only appended to destinations of accounts tracking conversions
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS conversion_tracking BOOLEAN DEFAULT FALSE;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) DEFAULT 'UTC';
+		-- Analytics retention in days, the server defaults when NULL
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS raw_retention_days INTEGER;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS rollup_retention_days INTEGER;`
 
 	_, err := DB.Exec(query)
 	if err != nil {
@@ -38,8 +54,13 @@ func createLinks() error {
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			tags JSONB DEFAULT '[]'::jsonb,
 			deleted BOOLEAN DEFAULT FALSE,
+			deleted_at TIMESTAMP,
 			FOREIGN KEY (user_uid) REFERENCES users(uid)
-		);`
+		);
+
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+		CREATE INDEX IF NOT EXISTS idx_links_trash ON links(user_uid, deleted_at) WHERE deleted = TRUE;
+		CREATE INDEX IF NOT EXISTS idx_links_tags ON links USING GIN (tags);`
 
 	_, err := DB.Exec(query)
 	if err != nil {
@@ -48,14 +69,19 @@ func createLinks() error {
 	return nil
 }
 
-func createAnalytics() error {
-	query := `
-		CREATE TABLE IF NOT EXISTS analytics (
-			id SERIAL PRIMARY KEY,
+// analyticsTable returns the statements creating the analytics table under the given name,
+// partitioned by month on click_timestamp (see partitions.go). The primary key includes the
+// partition key, as Postgres requires for partitioned tables.
+func analyticsTable(table string) string {
+	return fmt.Sprintf(`
+		CREATE SEQUENCE IF NOT EXISTS analytics_id_seq;
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			id INTEGER NOT NULL DEFAULT nextval('analytics_id_seq'),
 			short_link VARCHAR(255) NOT NULL,
 			user_uid UUID,
 			ip_address VARCHAR(45),
 			user_agent TEXT,
+			visitor_hash VARCHAR(64),
 			browser VARCHAR(100),
 			browser_version VARCHAR(50),
 			operating_system VARCHAR(100),
@@ -69,8 +95,12 @@ func createAnalytics() error {
 			latitude FLOAT,
 			longitude FLOAT,
 			referrer TEXT,
+			referrer_host VARCHAR(255),
+			referrer_source VARCHAR(20),
 			is_qr_code BOOLEAN DEFAULT FALSE,
-			click_timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			is_bot BOOLEAN DEFAULT FALSE,
+			click_id VARCHAR(32),
+			click_timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			click_date DATE DEFAULT CURRENT_DATE,
 			click_time TIME DEFAULT CURRENT_TIME,
 			day_of_week INTEGER,
@@ -79,26 +109,526 @@ func createAnalytics() error {
 			month INTEGER,
 			year INTEGER,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id, click_timestamp),
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		) PARTITION BY RANGE (click_timestamp);
+		CREATE TABLE IF NOT EXISTS analytics_default PARTITION OF %[1]s DEFAULT;
+	`, table)
+}
+
+// analyticsColumns adds the analytics columns added after the initial release
+const analyticsColumns = `
+		ALTER TABLE analytics ADD COLUMN IF NOT EXISTS visitor_hash VARCHAR(64);
+		ALTER TABLE analytics ADD COLUMN IF NOT EXISTS referrer_host VARCHAR(255);
+		ALTER TABLE analytics ADD COLUMN IF NOT EXISTS referrer_source VARCHAR(20);
+		ALTER TABLE analytics ADD COLUMN IF NOT EXISTS is_bot BOOLEAN DEFAULT FALSE;
+		ALTER TABLE analytics ADD COLUMN IF NOT EXISTS click_id VARCHAR(32);
+`
+
+// analyticsIndexes returns the statements creating the indexes of the analytics table under the given name
+func analyticsIndexes(table string) string {
+	return fmt.Sprintf(`
+		CREATE INDEX IF NOT EXISTS idx_analytics_short_link ON %[1]s(short_link);
+		CREATE INDEX IF NOT EXISTS idx_analytics_user_uid ON %[1]s(user_uid);
+		CREATE INDEX IF NOT EXISTS idx_analytics_click_timestamp ON %[1]s(click_timestamp);
+		CREATE INDEX IF NOT EXISTS idx_analytics_browser ON %[1]s(browser);
+		CREATE INDEX IF NOT EXISTS idx_analytics_os ON %[1]s(operating_system);
+		CREATE INDEX IF NOT EXISTS idx_analytics_country ON %[1]s(country);
+		CREATE INDEX IF NOT EXISTS idx_analytics_device_type ON %[1]s(device_type);
+		CREATE INDEX IF NOT EXISTS idx_analytics_day_of_week ON %[1]s(day_of_week);
+		CREATE INDEX IF NOT EXISTS idx_analytics_hour_of_day ON %[1]s(hour_of_day);
+		CREATE INDEX IF NOT EXISTS idx_analytics_click_date ON %[1]s(click_date);
+		CREATE INDEX IF NOT EXISTS idx_analytics_is_qr_code ON %[1]s(is_qr_code);
+		CREATE INDEX IF NOT EXISTS idx_analytics_visitor_hash ON %[1]s(visitor_hash);
+		CREATE INDEX IF NOT EXISTS idx_analytics_referrer_source ON %[1]s(referrer_source);
+		CREATE INDEX IF NOT EXISTS idx_analytics_short_link_click ON %[1]s(short_link, click_timestamp DESC, id DESC);
+		CREATE INDEX IF NOT EXISTS idx_analytics_click_id ON %[1]s(click_id);
+	`, table)
+}
+
+func createAnalytics() error {
+	kind, err := analyticsRelKind()
+	if err != nil {
+		return errors.New("failed to inspect analytics table: " + err.Error())
+	}
+	if kind == "r" {
+		// A table from before partitioning keeps serving until MigrateAnalyticsPartitions is run
+		fmt.Println("⚠️ The analytics table is not partitioned yet, run `go run main.go migrate-analytics` to partition it")
+		if _, err := DB.Exec(analyticsColumns + analyticsIndexes("analytics")); err != nil {
+			return errors.New("failed to update analytics table: " + err.Error())
+		}
+		return nil
+	}
+
+	query := analyticsTable("analytics") + `
+		ALTER SEQUENCE analytics_id_seq OWNED BY analytics.id;
+	` + analyticsColumns + analyticsIndexes("analytics")
+	if _, err := DB.Exec(query); err != nil {
+		return errors.New("failed to create analytics table: " + err.Error())
+	}
+
+	if err := EnsureUpcomingAnalyticsPartitions(); err != nil {
+		return errors.New("failed to create analytics partitions: " + err.Error())
+	}
+	return nil
+}
+
+func createAnalyticsRollups() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS analytics_rollup_hourly (
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) NOT NULL,
+			bucket_start TIMESTAMP NOT NULL,
+			clicks BIGINT DEFAULT 0,
+			unique_visitors BIGINT DEFAULT 0,
+			qr_clicks BIGINT DEFAULT 0,
+			direct_clicks BIGINT DEFAULT 0,
+			PRIMARY KEY (user_uid, short_link, bucket_start),
 			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
 		);
-		
-		-- Create indexes for better query performance
-		CREATE INDEX IF NOT EXISTS idx_analytics_short_link ON analytics(short_link);
-		CREATE INDEX IF NOT EXISTS idx_analytics_user_uid ON analytics(user_uid);
-		CREATE INDEX IF NOT EXISTS idx_analytics_click_timestamp ON analytics(click_timestamp);
-		CREATE INDEX IF NOT EXISTS idx_analytics_browser ON analytics(browser);
-		CREATE INDEX IF NOT EXISTS idx_analytics_os ON analytics(operating_system);
-		CREATE INDEX IF NOT EXISTS idx_analytics_country ON analytics(country);
-		CREATE INDEX IF NOT EXISTS idx_analytics_device_type ON analytics(device_type);
-		CREATE INDEX IF NOT EXISTS idx_analytics_day_of_week ON analytics(day_of_week);
-		CREATE INDEX IF NOT EXISTS idx_analytics_hour_of_day ON analytics(hour_of_day);
-		CREATE INDEX IF NOT EXISTS idx_analytics_click_date ON analytics(click_date);
-		CREATE INDEX IF NOT EXISTS idx_analytics_is_qr_code ON analytics(is_qr_code);
+
+		CREATE TABLE IF NOT EXISTS analytics_rollup_daily (
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) NOT NULL,
+			bucket_date DATE NOT NULL,
+			clicks BIGINT DEFAULT 0,
+			unique_visitors BIGINT DEFAULT 0,
+			qr_clicks BIGINT DEFAULT 0,
+			direct_clicks BIGINT DEFAULT 0,
+			PRIMARY KEY (user_uid, short_link, bucket_date),
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		-- Daily click counts per breakdown value (country, browser, os, device, city, referrer, source)
+		CREATE TABLE IF NOT EXISTS analytics_rollup_dimensions (
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) NOT NULL,
+			bucket_date DATE NOT NULL,
+			dimension VARCHAR(32) NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			value_code VARCHAR(10) DEFAULT '',
+			clicks BIGINT DEFAULT 0,
+			PRIMARY KEY (user_uid, short_link, bucket_date, dimension, value),
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		-- Rollups are complete for every click before the watermark
+		CREATE TABLE IF NOT EXISTS analytics_rollup_state (
+			name VARCHAR(50) PRIMARY KEY,
+			watermark TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_rollup_hourly_short_link ON analytics_rollup_hourly(short_link, bucket_start);
+		CREATE INDEX IF NOT EXISTS idx_rollup_daily_short_link ON analytics_rollup_daily(short_link, bucket_date);
+		CREATE INDEX IF NOT EXISTS idx_rollup_dimensions_user ON analytics_rollup_dimensions(user_uid, dimension, bucket_date);
 	`
 
 	_, err := DB.Exec(query)
 	if err != nil {
-		return errors.New("failed to create analytics table: " + err.Error())
+		return errors.New("failed to create analytics rollup tables: " + err.Error())
+	}
+	return nil
+}
+
+func createAnalyticsExports() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS analytics_exports (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) DEFAULT '',
+			kind VARCHAR(20) NOT NULL,
+			format VARCHAR(20) NOT NULL,
+			start_date DATE NOT NULL,
+			end_date DATE NOT NULL,
+			timezone VARCHAR(64) DEFAULT 'UTC',
+			status VARCHAR(20) DEFAULT 'pending',
+			row_count BIGINT DEFAULT 0,
+			error TEXT,
+			file_path TEXT,
+			download_token VARCHAR(64) UNIQUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			started_at TIMESTAMP,
+			completed_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_analytics_exports_user ON analytics_exports(user_uid, created_at);
+		CREATE INDEX IF NOT EXISTS idx_analytics_exports_status ON analytics_exports(status);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create analytics exports table: " + err.Error())
+	}
+	return nil
+}
+
+func createAlerts() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS notification_settings (
+			user_uid UUID PRIMARY KEY,
+			email_alerts BOOLEAN DEFAULT TRUE,
+			alert_email VARCHAR(255),
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS analytics_alerts (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) NOT NULL,
+			kind VARCHAR(20) NOT NULL,
+			message TEXT NOT NULL,
+			details JSONB DEFAULT '{}'::jsonb,
+			window_start TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			notified_at TIMESTAMP,
+			read_at TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_analytics_alerts_user ON analytics_alerts(user_uid, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_analytics_alerts_link_kind ON analytics_alerts(short_link, kind, created_at);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create alerts tables: " + err.Error())
+	}
+	return nil
+}
+
+func createReportSubscriptions() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS report_subscriptions (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) DEFAULT '',
+			frequency VARCHAR(10) NOT NULL,
+			email VARCHAR(255),
+			next_run_at TIMESTAMP NOT NULL,
+			last_sent_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_uid, short_link, frequency),
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_report_subscriptions_next_run ON report_subscriptions(next_run_at);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create report subscriptions table: " + err.Error())
+	}
+	return nil
+}
+
+func createWebhooks() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS webhook_endpoints (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			url TEXT NOT NULL,
+			description VARCHAR(255) DEFAULT '',
+			secret VARCHAR(64) NOT NULL,
+			events JSONB NOT NULL,
+			active BOOLEAN DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		-- Durable delivery queue and delivery log. A replay is a new delivery of the same payload.
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			endpoint_id UUID NOT NULL,
+			user_uid UUID NOT NULL,
+			event VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(20) DEFAULT 'pending',
+			attempts INTEGER DEFAULT 0,
+			next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			response_status INTEGER,
+			error TEXT,
+			replay_of UUID,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			delivered_at TIMESTAMP,
+			FOREIGN KEY (endpoint_id) REFERENCES webhook_endpoints(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_user ON webhook_endpoints(user_uid);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_queue ON webhook_deliveries(status, next_attempt_at);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, created_at DESC);
+
+		-- Receiver responses were stored before; only their status is kept now
+		ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS response_body;
+
+		-- Watermarks of the webhook jobs, such as the last run announcing expired links
+		CREATE TABLE IF NOT EXISTS webhook_state (
+			name VARCHAR(50) PRIMARY KEY,
+			watermark TIMESTAMP NOT NULL
+		);
+		WITH moved AS (
+			DELETE FROM analytics_rollup_state WHERE name = 'link_expiry_webhooks' RETURNING name, watermark
+		)
+		INSERT INTO webhook_state (name, watermark) SELECT name, watermark FROM moved
+		ON CONFLICT (name) DO NOTHING;
+
+		-- Alert webhooks used to be a URL and secret of the notification settings. They become
+		-- endpoints subscribed to the alert events, keeping their secret.
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'notification_settings' AND column_name = 'webhook_url'
+			) THEN
+				INSERT INTO webhook_endpoints (user_uid, url, description, secret, events)
+				SELECT user_uid, webhook_url, 'Traffic alerts', webhook_secret,
+					'["alert.spike", "alert.drop", "alert.geography", "alert.bot_share"]'::jsonb
+				FROM notification_settings
+				WHERE webhook_url IS NOT NULL AND webhook_url <> '' AND webhook_secret IS NOT NULL;
+
+				ALTER TABLE notification_settings DROP COLUMN webhook_url, DROP COLUMN webhook_secret;
+			END IF;
+		END $$;
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create webhook tables: " + err.Error())
+	}
+	return nil
+}
+
+// createConversions creates the conversions attributed to clicks. The click's country and referrer are
+// copied onto the conversion so breakdowns outlive the raw click's retention.
+func createConversions() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS conversions (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			short_link VARCHAR(255) NOT NULL,
+			click_id VARCHAR(32) NOT NULL,
+			goal VARCHAR(100) NOT NULL DEFAULT 'conversion',
+			value NUMERIC(14, 2) NOT NULL DEFAULT 0,
+			currency CHAR(3),
+			event_id VARCHAR(100),
+			country VARCHAR(100),
+			country_code VARCHAR(10),
+			referrer_host VARCHAR(255),
+			referrer_source VARCHAR(20),
+			clicked_at TIMESTAMP,
+			converted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_conversions_user ON conversions(user_uid, converted_at);
+		CREATE INDEX IF NOT EXISTS idx_conversions_short_link ON conversions(short_link, converted_at);
+		CREATE INDEX IF NOT EXISTS idx_conversions_click_id ON conversions(click_id);
+		-- event_id lets senders retry without counting a conversion twice
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_conversions_event ON conversions(user_uid, event_id) WHERE event_id IS NOT NULL;
+		-- Anyone can load the pixel, so without an event_id a click reaches each goal once
+		ALTER TABLE conversions ADD COLUMN IF NOT EXISTS from_pixel BOOLEAN NOT NULL DEFAULT FALSE;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_conversions_pixel_goal ON conversions(click_id, goal) WHERE from_pixel AND event_id IS NULL;
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create conversions table: " + err.Error())
+	}
+	return nil
+}
+
+// createPixels creates the account's retargeting pixels and the links they are attached to.
+// Links with pixels are forwarded through an interstitial page that fires them.
+func createPixels() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS retargeting_pixels (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			type VARCHAR(30) NOT NULL,
+			tracking_id VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_uid, type, tracking_id),
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS link_pixels (
+			link_uid TEXT NOT NULL,
+			pixel_id UUID NOT NULL,
+			PRIMARY KEY (link_uid, pixel_id),
+			FOREIGN KEY (link_uid) REFERENCES links(uid) ON DELETE CASCADE,
+			FOREIGN KEY (pixel_id) REFERENCES retargeting_pixels(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_retargeting_pixels_user ON retargeting_pixels(user_uid);
+		CREATE INDEX IF NOT EXISTS idx_link_pixels_pixel ON link_pixels(pixel_id);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create pixel tables: " + err.Error())
+	}
+	return nil
+}
+
+// createLinkBatches creates the background jobs creating links in bulk. The input rows are kept
+// until the batch has run; the result file maps them to the created links.
+func createLinkBatches() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS link_batches (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			source VARCHAR(20) NOT NULL,
+			status VARCHAR(20) DEFAULT 'pending',
+			input JSONB NOT NULL,
+			total_rows INTEGER NOT NULL,
+			processed_rows INTEGER DEFAULT 0,
+			created_rows INTEGER DEFAULT 0,
+			failed_rows INTEGER DEFAULT 0,
+			conflict_rows INTEGER DEFAULT 0,
+			provider VARCHAR(30),
+			error TEXT,
+			file_path TEXT,
+			download_token VARCHAR(64) UNIQUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			started_at TIMESTAMP,
+			completed_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_link_batches_user ON link_batches(user_uid, created_at);
+		CREATE INDEX IF NOT EXISTS idx_link_batches_status ON link_batches(status);
+
+		ALTER TABLE link_batches ADD COLUMN IF NOT EXISTS conflict_rows INTEGER DEFAULT 0;
+		ALTER TABLE link_batches ADD COLUMN IF NOT EXISTS provider VARCHAR(30);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create link batches table: " + err.Error())
+	}
+	return nil
+}
+
+// createLinkImports creates the details of links imported from another shortener. Clicks is the
+// click total at the previous shortener, kept as a baseline rather than as individual clicks.
+func createLinkImports() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS link_imports (
+			link_uid TEXT PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			batch_id UUID,
+			provider VARCHAR(30) NOT NULL,
+			title TEXT,
+			clicks BIGINT DEFAULT 0,
+			original_created_at TIMESTAMP,
+			imported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (link_uid) REFERENCES links(uid) ON DELETE CASCADE,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE,
+			FOREIGN KEY (batch_id) REFERENCES link_batches(id) ON DELETE SET NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_link_imports_user ON link_imports(user_uid);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create link imports table: " + err.Error())
+	}
+	return nil
+}
+
+// createLinkRevisions creates the edit history of links. Each revision is the state after a
+// change with the changed fields; only whether a password was set is recorded.
+func createLinkRevisions() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS link_revisions (
+			id BIGSERIAL PRIMARY KEY,
+			link_uid TEXT NOT NULL,
+			user_uid UUID NOT NULL,
+			original_link TEXT NOT NULL,
+			short_link VARCHAR(255) NOT NULL,
+			expiry_date TIMESTAMP,
+			tags JSONB DEFAULT '[]'::jsonb,
+			password_set BOOLEAN DEFAULT FALSE,
+			is_flagged BOOLEAN DEFAULT FALSE,
+			is_custom_backoff BOOLEAN DEFAULT FALSE,
+			changes JSONB DEFAULT '{}'::jsonb,
+			changed_by VARCHAR(255),
+			source VARCHAR(20) NOT NULL,
+			rollback_of BIGINT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (link_uid) REFERENCES links(uid) ON DELETE CASCADE,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_link_revisions_link ON link_revisions(link_uid, id DESC);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create link revisions table: " + err.Error())
+	}
+	return nil
+}
+
+// createFolders creates the account's folders (campaigns) and the column assigning links to them.
+// Deleting a folder keeps its links outside any folder.
+func createFolders() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS folders (
+			id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+			user_uid UUID NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			description TEXT,
+			start_date DATE,
+			end_date DATE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_uid) REFERENCES users(uid) ON DELETE CASCADE
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_user_name ON folders(user_uid, lower(name));
+
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS folder_id UUID REFERENCES folders(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_links_folder ON links(folder_id) WHERE folder_id IS NOT NULL;
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to create folders table: " + err.Error())
+	}
+	return nil
+}
+
+func createLinkSearch() error {
+	// Titles and descriptions are captured from the destination page, notes are written by the
+	// user. Words of the title, alias and tags weigh most, then the destination host.
+	query := `
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS title TEXT;
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS description TEXT;
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS notes TEXT;
+
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', COALESCE(title, '')), 'A')
+			|| setweight(to_tsvector('simple', short_link), 'A')
+			|| setweight(to_tsvector('simple', COALESCE(tags, '[]'::jsonb)), 'A')
+			|| setweight(to_tsvector('simple', translate(COALESCE(regexp_replace(lower(substring(original_link from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^:/?#]+)')), '^www\.', ''), ''), '.', ' ')), 'B')
+			|| setweight(to_tsvector('english', COALESCE(description, '')), 'C')
+			|| setweight(to_tsvector('english', COALESCE(notes, '')), 'C')
+		) STORED;
+
+		CREATE INDEX IF NOT EXISTS idx_links_search ON links USING GIN (search_vector);
+	`
+
+	_, err := DB.Exec(query)
+	if err != nil {
+		return errors.New("failed to add link search columns: " + err.Error())
 	}
 	return nil
 }
@@ -113,5 +643,41 @@ func createTables() error {
 	if err := createAnalytics(); err != nil {
 		return err
 	}
+	if err := createAnalyticsRollups(); err != nil {
+		return err
+	}
+	if err := createAnalyticsExports(); err != nil {
+		return err
+	}
+	if err := createAlerts(); err != nil {
+		return err
+	}
+	if err := createReportSubscriptions(); err != nil {
+		return err
+	}
+	if err := createWebhooks(); err != nil {
+		return err
+	}
+	if err := createConversions(); err != nil {
+		return err
+	}
+	if err := createPixels(); err != nil {
+		return err
+	}
+	if err := createLinkBatches(); err != nil {
+		return err
+	}
+	if err := createLinkImports(); err != nil {
+		return err
+	}
+	if err := createLinkRevisions(); err != nil {
+		return err
+	}
+	if err := createFolders(); err != nil {
+		return err
+	}
+	if err := createLinkSearch(); err != nil {
+		return err
+	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package rdb
 
 import (
+	"context"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -17,6 +18,10 @@ func (r *redisService) Set(key string, value any, expiration *time.Duration) err
 	return r.client.Set(r.ctx, key, value, redis.KeepTTL).Err()
 }
 
+func (r *redisService) SetNX(key string, value any, expiration time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, key, value, expiration).Result()
+}
+
 func (r *redisService) Get(key string) (string, error) {
 	return r.client.Get(r.ctx, key).Result()
 }
@@ -101,3 +106,12 @@ func (r *redisService) LTrim(key string, start, stop int64) error {
 func (r *redisService) Scan(cursor uint64, match string, count int64) ([]string, uint64, error) {
 	return r.client.Scan(r.ctx, cursor, match, count).Result()
 }
+
+func (r *redisService) Publish(channel string, message any) error {
+	return r.client.Publish(r.ctx, channel, message).Err()
+}
+
+// Subscribe listens on the given channels until ctx is done or the subscription is closed
+func (r *redisService) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	return r.client.Subscribe(ctx, channels...)
+}
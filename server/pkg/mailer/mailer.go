@@ -0,0 +1,170 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotConfigured is returned when SMTP_HOST or SMTP_FROM is not set
+var ErrNotConfigured = errors.New("mailer is not configured")
+
+// Message is a single email. HTML is optional; when set the email is sent as multipart/alternative.
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers messages. Send uses SMTPSender unless another sender is installed with SetSender.
+type Sender interface {
+	Send(msg Message) error
+}
+
+var sender Sender = SMTPSender{}
+
+// SetSender replaces the sender used by Send, e.g. to capture emails in development
+func SetSender(s Sender) {
+	sender = s
+}
+
+type config struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// Read on every send so credentials can be rotated without a restart
+func loadConfig() config {
+	cfg := config{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     os.Getenv("SMTP_PORT"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+	}
+	if cfg.port == "" {
+		cfg.port = "587"
+	}
+	return cfg
+}
+
+// Enabled reports whether outgoing email is configured
+func Enabled() bool {
+	if _, ok := sender.(SMTPSender); !ok {
+		return true
+	}
+	cfg := loadConfig()
+	return cfg.host != "" && cfg.from != ""
+}
+
+// Send delivers the message through the installed sender
+func Send(msg Message) error {
+	return sender.Send(msg)
+}
+
+// SMTPSender sends through the SMTP server configured by SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM. Port 465 uses implicit TLS, other ports upgrade with STARTTLS
+// when the server offers it, so a local SMTP sink without TLS works for testing.
+type SMTPSender struct{}
+
+func (SMTPSender) Send(msg Message) error {
+	cfg := loadConfig()
+	if cfg.host == "" || cfg.from == "" {
+		return ErrNotConfigured
+	}
+	if len(msg.To) == 0 {
+		return errors.New("mailer: no recipients")
+	}
+
+	body, err := build(cfg.from, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if cfg.username != "" {
+		auth = smtp.PlainAuth("", cfg.username, cfg.password, cfg.host)
+	}
+	addr := net.JoinHostPort(cfg.host, cfg.port)
+
+	if cfg.port != "465" {
+		return smtp.SendMail(addr, auth, cfg.from, msg.To, body)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, &tls.Config{ServerName: cfg.host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, cfg.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(cfg.from); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// build renders the message as an RFC 5322 email
+func build(from string, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(msg.Text)
+		return buf.Bytes(), nil
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := "sot-" + hex.EncodeToString(b)
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.Text)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
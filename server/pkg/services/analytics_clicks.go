@@ -0,0 +1,228 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Page sizes of the click event API
+const (
+	DefaultClicksLimit = 50
+	MaxClicksLimit     = 500
+)
+
+// ClickFilter selects click events, newest first. Empty fields don't filter.
+type ClickFilter struct {
+	UserUID      string
+	ShortLink    string
+	StartDate    string // inclusive, YYYY-MM-DD in TimeZone
+	EndDate      string // inclusive, YYYY-MM-DD in TimeZone
+	TimeZone     string
+	Country      string // country name or ISO code
+	Device       string
+	Browser      string
+	OS           string
+	QR           *bool // true for QR scans, false for everything else
+	ReferrerHost string
+	Cursor       string // next_cursor or prev_cursor of a previous page
+	Limit        int
+}
+
+// ClickEvent is a single row of the analytics table
+type ClickEvent struct {
+	ID             int64     `json:"id"`
+	ShortLink      string    `json:"short_link"`
+	ClickedAt      time.Time `json:"clicked_at"`
+	IPAddress      *string   `json:"ip_address"`
+	UserAgent      *string   `json:"user_agent"`
+	VisitorHash    *string   `json:"visitor_hash,omitempty"`
+	Browser        *string   `json:"browser"`
+	OS             *string   `json:"os"`
+	DeviceType     *string   `json:"device_type"`
+	Country        *string   `json:"country"`
+	CountryCode    *string   `json:"country_code"`
+	City           *string   `json:"city"`
+	Referrer       *string   `json:"referrer"`
+	ReferrerHost   *string   `json:"referrer_host"`
+	ReferrerSource *string   `json:"referrer_source"`
+	IsQRCode       bool      `json:"is_qr_code"`
+}
+
+// ClickPage is a page of click events. NextCursor is empty on the last page and PrevCursor on
+// the first.
+type ClickPage struct {
+	Clicks     []ClickEvent `json:"clicks"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	PrevCursor string       `json:"prev_cursor,omitempty"`
+}
+
+// clickCursorBefore marks a cursor paging back to newer clicks. Cursors without it page on
+// to older clicks, as every cursor did before prev_cursor was added.
+const clickCursorBefore = "before"
+
+// encodeClickCursor encodes the position of the click at the edge of a page
+func encodeClickCursor(clickedAt time.Time, id int64, before bool) string {
+	raw := clickedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(id, 10)
+	if before {
+		raw += "|" + clickCursorBefore
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeClickCursor(cursor string) (time.Time, int64, bool, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, false, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != clickCursorBefore) {
+		return time.Time{}, 0, false, ErrInvalidCursor
+	}
+	clickedAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, false, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false, ErrInvalidCursor
+	}
+	return clickedAt, n, len(parts) == 3, nil
+}
+
+// Validate checks the date range and cursor of the filter
+func (f ClickFilter) Validate() error {
+	scope := StatsScope{StartDate: f.StartDate, EndDate: f.EndDate, TimeZone: f.TimeZone}
+	if _, _, err := scope.Bounds(); err != nil {
+		return err
+	}
+	if f.Cursor != "" {
+		if _, _, _, err := decodeClickCursor(f.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchClicks returns a page of click events matching the filter, using keyset pagination
+// on (click_timestamp, id) so pages stay stable while new clicks arrive. A page before a
+// cursor is read oldest first from the cursor and returned newest first like any other.
+func FetchClicks(f ClickFilter) (ClickPage, error) {
+	page := ClickPage{Clicks: []ClickEvent{}}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultClicksLimit
+	}
+	if limit > MaxClicksLimit {
+		limit = MaxClicksLimit
+	}
+
+	scope := StatsScope{StartDate: f.StartDate, EndDate: f.EndDate, TimeZone: f.TimeZone}
+	from, to, err := scope.Bounds()
+	if err != nil {
+		return page, err
+	}
+
+	var args queryArgs
+	conds := []string{"a.user_uid = " + args.add(f.UserUID)}
+	if f.ShortLink != "" {
+		conds = append(conds, "a.short_link = "+args.add(f.ShortLink))
+	}
+	if !from.IsZero() {
+		conds = append(conds, "a.click_timestamp >= "+args.add(from))
+	}
+	if !to.IsZero() {
+		conds = append(conds, "a.click_timestamp < "+args.add(to))
+	}
+	if f.Country != "" {
+		arg := args.add(f.Country)
+		conds = append(conds, fmt.Sprintf("(LOWER(a.country) = LOWER(%s) OR LOWER(a.country_code) = LOWER(%s))", arg, arg))
+	}
+	if f.Device != "" {
+		conds = append(conds, "LOWER(a.device_type) = LOWER("+args.add(f.Device)+")")
+	}
+	if f.Browser != "" {
+		conds = append(conds, "LOWER(a.browser) = LOWER("+args.add(f.Browser)+")")
+	}
+	if f.OS != "" {
+		conds = append(conds, "LOWER(a.operating_system) = LOWER("+args.add(f.OS)+")")
+	}
+	if f.QR != nil {
+		conds = append(conds, "COALESCE(a.is_qr_code, FALSE) = "+args.add(*f.QR))
+	}
+	if f.ReferrerHost != "" {
+		conds = append(conds, "a.referrer_host = LOWER("+args.add(f.ReferrerHost)+")")
+	}
+	before := false
+	order := "DESC"
+	if f.Cursor != "" {
+		clickedAt, id, isBefore, err := decodeClickCursor(f.Cursor)
+		if err != nil {
+			return page, err
+		}
+		op := "<"
+		if before = isBefore; before {
+			op, order = ">", "ASC"
+		}
+		conds = append(conds, fmt.Sprintf("(a.click_timestamp, a.id) %s (%s, %s)", op, args.add(clickedAt), args.add(id)))
+	}
+
+	// Fetch one extra row to know whether there is another page in the direction read
+	query := fmt.Sprintf(`
+		SELECT a.id, a.short_link, a.click_timestamp, a.ip_address, a.user_agent, a.visitor_hash,
+			a.browser, a.operating_system, a.device_type, a.country, a.country_code, a.city,
+			a.referrer, a.referrer_host, a.referrer_source, COALESCE(a.is_qr_code, FALSE)
+		FROM analytics a
+		WHERE %s
+		ORDER BY a.click_timestamp %[2]s, a.id %[2]s
+		LIMIT %[3]s
+	`, strings.Join(conds, " AND "), order, args.add(limit+1))
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ClickEvent
+		if err := rows.Scan(&e.ID, &e.ShortLink, &e.ClickedAt, &e.IPAddress, &e.UserAgent, &e.VisitorHash,
+			&e.Browser, &e.OS, &e.DeviceType, &e.Country, &e.CountryCode, &e.City,
+			&e.Referrer, &e.ReferrerHost, &e.ReferrerSource, &e.IsQRCode); err != nil {
+			return page, err
+		}
+		page.Clicks = append(page.Clicks, e)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	more := len(page.Clicks) > limit
+	if more {
+		page.Clicks = page.Clicks[:limit]
+	}
+	hasNext, hasPrev := more, f.Cursor != ""
+	if before {
+		for i, j := 0, len(page.Clicks)-1; i < j; i, j = i+1, j-1 {
+			page.Clicks[i], page.Clicks[j] = page.Clicks[j], page.Clicks[i]
+		}
+		hasNext, hasPrev = true, more
+	}
+	if len(page.Clicks) == 0 {
+		return page, nil
+	}
+	if hasNext {
+		last := page.Clicks[len(page.Clicks)-1]
+		page.NextCursor = encodeClickCursor(last.ClickedAt, last.ID, false)
+	}
+	if hasPrev {
+		first := page.Clicks[0]
+		page.PrevCursor = encodeClickCursor(first.ClickedAt, first.ID, true)
+	}
+	return page, nil
+}
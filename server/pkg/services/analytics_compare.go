@@ -0,0 +1,243 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Comparison modes accepted by the compare query parameter
+const (
+	ComparePreviousPeriod = "previous_period"
+	ComparePreviousYear   = "previous_year"
+)
+
+// Number of values compared per breakdown
+const compareTopN = 10
+
+// Breakdowns included in a comparison, with the values each one skips
+var compareBreakdowns = []struct {
+	name    string
+	exclude []string
+}{
+	{"os", []string{"Unknown", ""}},
+	{"device", []string{""}},
+	{"browser", []string{""}},
+	{"country", []string{"Unknown", ""}},
+	{"referrer", []string{""}},
+	{"source", []string{""}},
+}
+
+// MetricChange compares a counter between the current and the comparison window.
+// ChangePercent is nil when the previous value is zero.
+type MetricChange struct {
+	Current       int64    `json:"current"`
+	Previous      int64    `json:"previous"`
+	Delta         int64    `json:"delta"`
+	ChangePercent *float64 `json:"change_percent"`
+}
+
+// BreakdownChange is a MetricChange for a single breakdown value
+type BreakdownChange struct {
+	Value string `json:"value"`
+	MetricChange
+}
+
+// Comparison holds the metrics of the comparison window next to their changes
+type Comparison struct {
+	Mode           string                       `json:"mode"`
+	StartDate      string                       `json:"start_date"`
+	EndDate        string                       `json:"end_date"`
+	TotalClicks    MetricChange                 `json:"total_clicks"`
+	UniqueVisitors MetricChange                 `json:"unique_visitors"`
+	QRClicks       MetricChange                 `json:"qr_clicks"`
+	DirectClicks   MetricChange                 `json:"direct_clicks"`
+	Breakdowns     map[string][]BreakdownChange `json:"breakdowns"`
+}
+
+// ValidateCompareMode checks the compare query parameter. An empty mode disables comparison.
+func ValidateCompareMode(mode string) error {
+	switch mode {
+	case "", ComparePreviousPeriod, ComparePreviousYear:
+		return nil
+	}
+	return fmt.Errorf("invalid compare option: %s", mode)
+}
+
+// DefaultDateRange fills missing dates with the last 30 days in the given time zone
+func DefaultDateRange(startDate, endDate, timeZone string) (string, string) {
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if startDate == "" {
+		startDate = time.Now().In(loc).AddDate(0, 0, -30).Format("2006-01-02")
+	}
+	if endDate == "" {
+		endDate = time.Now().In(loc).Format("2006-01-02")
+	}
+	return startDate, endDate
+}
+
+// CompareCounts builds the change between two counters
+func CompareCounts(current, previous int64) MetricChange {
+	change := MetricChange{Current: current, Previous: previous, Delta: current - previous}
+	if previous != 0 {
+		percent := float64(current-previous) / float64(previous) * 100
+		change.ChangePercent = &percent
+	}
+	return change
+}
+
+// ComparisonScope returns the window the scope is compared against: the same number of days
+// right before it, or the same dates a year earlier
+func ComparisonScope(s StatsScope, mode string) (StatsScope, error) {
+	if s.StartDate == "" || s.EndDate == "" {
+		return StatsScope{}, fmt.Errorf("comparison requires a start and end date")
+	}
+	start, err := time.Parse("2006-01-02", s.StartDate)
+	if err != nil {
+		return StatsScope{}, fmt.Errorf("invalid start date: %s", s.StartDate)
+	}
+	end, err := time.Parse("2006-01-02", s.EndDate)
+	if err != nil {
+		return StatsScope{}, fmt.Errorf("invalid end date: %s", s.EndDate)
+	}
+	if end.Before(start) {
+		return StatsScope{}, fmt.Errorf("end date is before start date")
+	}
+
+	switch mode {
+	case ComparePreviousPeriod:
+		days := int(end.Sub(start).Hours()/24) + 1
+		start, end = start.AddDate(0, 0, -days), start.AddDate(0, 0, -1)
+	case ComparePreviousYear:
+		start, end = start.AddDate(-1, 0, 0), end.AddDate(-1, 0, 0)
+	default:
+		return StatsScope{}, fmt.Errorf("invalid compare option: %s", mode)
+	}
+
+	previous := s
+	previous.StartDate = start.Format("2006-01-02")
+	previous.EndDate = end.Format("2006-01-02")
+	return previous, nil
+}
+
+// FetchComparison computes the comparison of the scope against the window selected by mode.
+// Breakdowns compare the current top values of each dimension, and of the links when the
+// scope isn't limited to a single link.
+func FetchComparison(s StatsScope, mode string) (*Comparison, error) {
+	previous, err := ComparisonScope(s, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &Comparison{
+		Mode:       mode,
+		StartDate:  previous.StartDate,
+		EndDate:    previous.EndDate,
+		Breakdowns: make(map[string][]BreakdownChange),
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	addErr := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		current, err := FetchClickTotals(s)
+		if err != nil {
+			addErr(err)
+			return
+		}
+		prev, err := FetchClickTotals(previous)
+		if err != nil {
+			addErr(err)
+			return
+		}
+		comparison.TotalClicks = CompareCounts(current.TotalClicks, prev.TotalClicks)
+		comparison.UniqueVisitors = CompareCounts(current.UniqueVisitors, prev.UniqueVisitors)
+		comparison.QRClicks = CompareCounts(current.QRClicks, prev.QRClicks)
+		comparison.DirectClicks = CompareCounts(current.DirectClicks, prev.DirectClicks)
+	}()
+
+	for _, b := range compareBreakdowns {
+		wg.Add(1)
+		go func(name string, exclude []string) {
+			defer wg.Done()
+			current, err := FetchDimensionStats(s, name, exclude...)
+			if err != nil {
+				addErr(err)
+				return
+			}
+			prev, err := FetchDimensionStats(previous, name, exclude...)
+			if err != nil {
+				addErr(err)
+				return
+			}
+			changes := compareBreakdown(current, prev)
+			mu.Lock()
+			comparison.Breakdowns[name] = changes
+			mu.Unlock()
+		}(b.name, b.exclude)
+	}
+
+	if s.ShortLink == "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			current, err := FetchTopLinks(s, compareTopN)
+			if err != nil {
+				addErr(err)
+				return
+			}
+			prev, err := FetchTopLinks(previous, 0)
+			if err != nil {
+				addErr(err)
+				return
+			}
+			toCounts := func(links []LinkClickTotals) []DimensionCount {
+				counts := make([]DimensionCount, len(links))
+				for i, l := range links {
+					counts[i] = DimensionCount{Value: l.ShortLink, Clicks: l.TotalClicks}
+				}
+				return counts
+			}
+			changes := compareBreakdown(toCounts(current), toCounts(prev))
+			mu.Lock()
+			comparison.Breakdowns["links"] = changes
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to compare analytics: %v", errs)
+	}
+	return comparison, nil
+}
+
+// compareBreakdown compares the top values of current with the same values in previous
+func compareBreakdown(current, previous []DimensionCount) []BreakdownChange {
+	prev := DimensionMap(previous)
+	if len(current) > compareTopN {
+		current = current[:compareTopN]
+	}
+	changes := make([]BreakdownChange, 0, len(current))
+	for _, dc := range current {
+		changes = append(changes, BreakdownChange{
+			Value:        dc.Value,
+			MetricChange: CompareCounts(dc.Clicks, prev[dc.Value]),
+		})
+	}
+	return changes
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Export kinds and formats
+const (
+	ExportClicks = "clicks" // one row per click
+	ExportDaily  = "daily"  // one row per link and day
+
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// Ranges longer than this are always exported asynchronously
+const ExportSyncMaxDays = 93
+
+// Rows written between flushes of a streamed export
+const exportFlushEvery = 500
+
+// ExportOptions selects what an export contains
+type ExportOptions struct {
+	UserUID   string
+	ShortLink string
+	Kind      string
+	Format    string
+	StartDate string
+	EndDate   string
+	TimeZone  string
+}
+
+type exportColumnType int
+
+const (
+	exportText exportColumnType = iota
+	exportInt
+	exportFloat
+	exportBool
+	exportTime
+)
+
+type exportColumn struct {
+	name string
+	expr string
+	typ  exportColumnType
+}
+
+// {tz} is replaced by the time zone placeholder
+var clickExportColumns = []exportColumn{
+	{"id", "a.id", exportInt},
+	{"short_link", "a.short_link", exportText},
+	{"clicked_at", "a.click_timestamp", exportTime},
+	{"local_time", `to_char(a.click_timestamp AT TIME ZONE 'UTC' AT TIME ZONE {tz}, 'YYYY-MM-DD"T"HH24:MI:SS')`, exportText},
+	{"ip_address", "a.ip_address", exportText},
+	{"user_agent", "a.user_agent", exportText},
+	{"visitor_hash", "a.visitor_hash", exportText},
+	{"browser", "a.browser", exportText},
+	{"browser_version", "a.browser_version", exportText},
+	{"os", "a.operating_system", exportText},
+	{"os_version", "a.os_version", exportText},
+	{"device_type", "a.device_type", exportText},
+	{"country", "a.country", exportText},
+	{"country_code", "a.country_code", exportText},
+	{"region", "a.region", exportText},
+	{"city", "a.city", exportText},
+	{"latitude", "a.latitude", exportFloat},
+	{"longitude", "a.longitude", exportFloat},
+	{"referrer", "a.referrer", exportText},
+	{"referrer_host", "a.referrer_host", exportText},
+	{"referrer_source", "a.referrer_source", exportText},
+	{"is_qr_code", "a.is_qr_code", exportBool},
+}
+
+var dailyExportColumns = []exportColumn{
+	{"date", "to_char((a.click_timestamp AT TIME ZONE 'UTC' AT TIME ZONE {tz})::date, 'YYYY-MM-DD')", exportText},
+	{"short_link", "a.short_link", exportText},
+	{"clicks", "COUNT(*)", exportInt},
+	{"unique_visitors", "COUNT(DISTINCT COALESCE(a.visitor_hash, a.ip_address))", exportInt},
+	{"qr_clicks", "COUNT(*) FILTER (WHERE a.is_qr_code = TRUE)", exportInt},
+	{"direct_clicks", "COUNT(*) FILTER (WHERE COALESCE(a.referrer, '') = '')", exportInt},
+}
+
+// Validate checks the options and returns the number of days they cover
+func (o ExportOptions) Validate() (int, error) {
+	switch o.Kind {
+	case ExportClicks, ExportDaily:
+	default:
+		return 0, fmt.Errorf("invalid export kind: %s", o.Kind)
+	}
+	switch o.Format {
+	case ExportCSV, ExportNDJSON:
+	default:
+		return 0, fmt.Errorf("invalid export format: %s", o.Format)
+	}
+	if o.StartDate == "" || o.EndDate == "" {
+		return 0, fmt.Errorf("start_date and end_date are required")
+	}
+	start, err := time.Parse("2006-01-02", o.StartDate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid start date: %s", o.StartDate)
+	}
+	end, err := time.Parse("2006-01-02", o.EndDate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid end date: %s", o.EndDate)
+	}
+	if end.Before(start) {
+		return 0, fmt.Errorf("end date is before start date")
+	}
+	return int(end.Sub(start).Hours()/24) + 1, nil
+}
+
+// ContentType returns the MIME type of the export format
+func (o ExportOptions) ContentType() string {
+	if o.Format == ExportNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// FileName returns the suggested download name of the export
+func (o ExportOptions) FileName() string {
+	name := "sot-" + o.Kind
+	if o.ShortLink != "" {
+		name += "-" + o.ShortLink
+	}
+	return fmt.Sprintf("%s-%s-%s.%s", name, o.StartDate, o.EndDate, o.Format)
+}
+
+// exportQuery builds the query of an export and returns its columns
+func (o ExportOptions) exportQuery() (string, []exportColumn, queryArgs) {
+	scope := StatsScope{UserUID: o.UserUID, ShortLink: o.ShortLink, StartDate: o.StartDate, EndDate: o.EndDate, TimeZone: o.TimeZone}
+	from, to, _ := scope.Bounds()
+
+	var args queryArgs
+	tzArg := args.add(scope.Location().String())
+
+	columns := clickExportColumns
+	if o.Kind == ExportDaily {
+		columns = dailyExportColumns
+	}
+	exprs := make([]string, len(columns))
+	for i, col := range columns {
+		exprs[i] = strings.ReplaceAll(col.expr, "{tz}", tzArg)
+	}
+
+	conds := []string{
+		"a.user_uid = " + args.add(o.UserUID),
+		"a.click_timestamp >= " + args.add(from),
+		"a.click_timestamp < " + args.add(to),
+	}
+	if o.ShortLink != "" {
+		conds = append(conds, "a.short_link = "+args.add(o.ShortLink))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM analytics a WHERE %s", strings.Join(exprs, ", "), strings.Join(conds, " AND "))
+	if o.Kind == ExportDaily {
+		query += " GROUP BY 1, 2 ORDER BY 1, 2"
+	} else {
+		query += " ORDER BY a.click_timestamp, a.id"
+	}
+	return query, columns, args
+}
+
+// WriteExport streams the export to w row by row and returns the number of rows written.
+// When w is an http.Flusher it is flushed regularly so large exports are never buffered.
+func WriteExport(ctx context.Context, w io.Writer, o ExportOptions) (int64, error) {
+	query, columns, args := o.exportQuery()
+	rows, err := postgres.FindManyContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	flusher, _ := w.(http.Flusher)
+	var (
+		csvWriter *csv.Writer
+		encoder   *json.Encoder
+	)
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.name
+	}
+	if o.Format == ExportCSV {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(names); err != nil {
+			return 0, err
+		}
+	} else {
+		encoder = json.NewEncoder(w)
+	}
+
+	dest := make([]any, len(columns))
+	for i, col := range columns {
+		switch col.typ {
+		case exportInt:
+			dest[i] = new(sql.NullInt64)
+		case exportFloat:
+			dest[i] = new(sql.NullFloat64)
+		case exportBool:
+			dest[i] = new(sql.NullBool)
+		case exportTime:
+			dest[i] = new(sql.NullTime)
+		default:
+			dest[i] = new(sql.NullString)
+		}
+	}
+
+	var count int64
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, err
+		}
+
+		if csvWriter != nil {
+			for i := range columns {
+				record[i] = exportCSVValue(dest[i])
+			}
+			if err := csvWriter.Write(record); err != nil {
+				return count, err
+			}
+		} else {
+			object := make(map[string]any, len(columns))
+			for i, col := range columns {
+				object[col.name] = exportJSONValue(dest[i])
+			}
+			if err := encoder.Encode(object); err != nil {
+				return count, err
+			}
+		}
+
+		count++
+		if count%exportFlushEvery == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return count, err
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return count, nil
+}
+
+func exportCSVValue(v any) string {
+	switch v := v.(type) {
+	case *sql.NullInt64:
+		if v.Valid {
+			return strconv.FormatInt(v.Int64, 10)
+		}
+	case *sql.NullFloat64:
+		if v.Valid {
+			return strconv.FormatFloat(v.Float64, 'f', -1, 64)
+		}
+	case *sql.NullBool:
+		if v.Valid {
+			return strconv.FormatBool(v.Bool)
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return v.Time.UTC().Format(time.RFC3339)
+		}
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	}
+	return ""
+}
+
+func exportJSONValue(v any) any {
+	switch v := v.(type) {
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case *sql.NullFloat64:
+		if v.Valid {
+			return v.Float64
+		}
+	case *sql.NullBool:
+		if v.Valid {
+			return v.Bool
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return v.Time.UTC().Format(time.RFC3339)
+		}
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Export job states
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+const (
+	// How long finished export files can be downloaded
+	exportFileTTL = 24 * time.Hour
+	// Running jobs older than this are assumed lost in a restart and queued again
+	exportStaleAfter = time.Hour
+)
+
+// ErrExportNotFound is returned when an export job or its file is not available
+var ErrExportNotFound = errors.New("export not found")
+
+// ExportJob is an asynchronous export
+type ExportJob struct {
+	ID            string     `json:"id"`
+	ShortLink     string     `json:"short_link,omitempty"`
+	Kind          string     `json:"kind"`
+	Format        string     `json:"format"`
+	StartDate     string     `json:"start_date"`
+	EndDate       string     `json:"end_date"`
+	TimeZone      string     `json:"timezone"`
+	Status        string     `json:"status"`
+	RowCount      int64      `json:"row_count"`
+	Error         *string    `json:"error,omitempty"`
+	DownloadToken *string    `json:"download_token,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+const exportJobColumns = `id, COALESCE(short_link, ''), kind, format, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD'),
+	COALESCE(timezone, 'UTC'), status, row_count, error, download_token, created_at, completed_at, expires_at`
+
+func scanExportJob(row interface{ Scan(...any) error }) (ExportJob, error) {
+	var job ExportJob
+	err := row.Scan(&job.ID, &job.ShortLink, &job.Kind, &job.Format, &job.StartDate, &job.EndDate,
+		&job.TimeZone, &job.Status, &job.RowCount, &job.Error, &job.DownloadToken, &job.CreatedAt, &job.CompletedAt, &job.ExpiresAt)
+	return job, err
+}
+
+// Options returns the export options of the job
+func (j ExportJob) Options(userUID string) ExportOptions {
+	return ExportOptions{
+		UserUID:   userUID,
+		ShortLink: j.ShortLink,
+		Kind:      j.Kind,
+		Format:    j.Format,
+		StartDate: j.StartDate,
+		EndDate:   j.EndDate,
+		TimeZone:  j.TimeZone,
+	}
+}
+
+// exportDir is where export files are written, EXPORT_DIR or a directory in the system temp dir
+func exportDir() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "sot-exports")
+}
+
+// CreateExportJob queues an export for the cron worker
+func CreateExportJob(o ExportOptions) (ExportJob, error) {
+	row, err := postgres.FindOne(`
+		INSERT INTO analytics_exports (user_uid, short_link, kind, format, start_date, end_date, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+exportJobColumns,
+		o.UserUID, o.ShortLink, o.Kind, o.Format, o.StartDate, o.EndDate, o.TimeZone,
+	)
+	if err != nil {
+		return ExportJob{}, err
+	}
+	return scanExportJob(row)
+}
+
+// GetExportJob returns an export job of the user
+func GetExportJob(userUID, id string) (ExportJob, error) {
+	row, err := postgres.FindOne("SELECT "+exportJobColumns+" FROM analytics_exports WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return ExportJob{}, err
+	}
+	job, err := scanExportJob(row)
+	if err == sql.ErrNoRows {
+		return job, ErrExportNotFound
+	}
+	return job, err
+}
+
+// ExportDownload returns the file of a finished export by its download token
+func ExportDownload(userUID, token string) (ExportJob, string, error) {
+	row, err := postgres.FindOne(`
+		SELECT `+exportJobColumns+`, file_path FROM analytics_exports
+		WHERE download_token = $1 AND user_uid = $2 AND status = $3 AND expires_at > $4
+	`, token, userUID, ExportDone, time.Now().UTC())
+	if err != nil {
+		return ExportJob{}, "", err
+	}
+	var job ExportJob
+	var path string
+	err = row.Scan(&job.ID, &job.ShortLink, &job.Kind, &job.Format, &job.StartDate, &job.EndDate,
+		&job.TimeZone, &job.Status, &job.RowCount, &job.Error, &job.DownloadToken, &job.CreatedAt, &job.CompletedAt, &job.ExpiresAt, &path)
+	if err == sql.ErrNoRows {
+		return job, "", ErrExportNotFound
+	}
+	return job, path, err
+}
+
+// ProcessExportJobs removes expired export files and runs queued exports one after another
+func ProcessExportJobs() error {
+	if err := expireExportFiles(); err != nil {
+		log.Printf("Failed to expire export files: %v", err)
+	}
+
+	if _, err := postgres.UpdateOne(`
+		UPDATE analytics_exports SET status = $1
+		WHERE status = $2 AND started_at < $3
+	`, ExportPending, ExportRunning, time.Now().UTC().Add(-exportStaleAfter)); err != nil {
+		return err
+	}
+
+	for {
+		row, err := postgres.FindOne(`
+			UPDATE analytics_exports SET status = $1, started_at = $2
+			WHERE id = (
+				SELECT id FROM analytics_exports WHERE status = $3
+				ORDER BY created_at LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING user_uid, `+exportJobColumns,
+			ExportRunning, time.Now().UTC(), ExportPending,
+		)
+		if err != nil {
+			return err
+		}
+		var userUID string
+		var job ExportJob
+		err = row.Scan(&userUID, &job.ID, &job.ShortLink, &job.Kind, &job.Format, &job.StartDate, &job.EndDate,
+			&job.TimeZone, &job.Status, &job.RowCount, &job.Error, &job.DownloadToken, &job.CreatedAt, &job.CompletedAt, &job.ExpiresAt)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := runExportJob(userUID, job); err != nil {
+			log.Printf("Export %s failed: %v", job.ID, err)
+			if _, err := postgres.UpdateOne(
+				"UPDATE analytics_exports SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+				ExportFailed, err.Error(), time.Now().UTC(), job.ID,
+			); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runExportJob writes the export to a file and marks the job as done
+func runExportJob(userUID string, job ExportJob) error {
+	dir := exportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, job.ID+"."+job.Format)
+
+	// Write to a temporary file so a crash never leaves a truncated export behind
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	count, err := WriteExport(context.Background(), f, job.Options(userUID))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	token, err := GenerateAPIKey(24)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = postgres.UpdateOne(`
+		UPDATE analytics_exports
+		SET status = $1, row_count = $2, file_path = $3, download_token = $4, completed_at = $5, expires_at = $6
+		WHERE id = $7
+	`, ExportDone, count, path, token, now, now.Add(exportFileTTL), job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to complete export: %v", err)
+	}
+	return nil
+}
+
+// expireExportFiles deletes the files of exports past their download window
+func expireExportFiles() error {
+	rows, err := postgres.FindMany(`
+		SELECT id, file_path FROM analytics_exports
+		WHERE status = $1 AND expires_at <= $2
+	`, ExportDone, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	type expired struct {
+		id   string
+		path sql.NullString
+	}
+	var files []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.path); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, e)
+	}
+	rows.Close()
+
+	for _, e := range files {
+		if e.path.Valid {
+			if err := os.Remove(e.path.String); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove export file %s: %v", e.path.String, err)
+				continue
+			}
+		}
+		if _, err := postgres.UpdateOne(
+			"UPDATE analytics_exports SET status = $1, file_path = NULL, download_token = NULL WHERE id = $2",
+			ExportExpired, e.id,
+		); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+const (
+	rollupStateName = "analytics"
+	// Clicks can reach Postgres a little after their hour has closed (the cron drains Redis
+	// every few seconds), so each run recomputes the previous hour as well
+	rollupLateArrival = time.Hour
+)
+
+// RollupWatermark returns the time up to which the rollup tables are complete.
+// A zero time means nothing has been rolled up yet.
+func RollupWatermark() (time.Time, error) {
+	row, err := postgres.FindOne("SELECT watermark FROM analytics_rollup_state WHERE name = $1", rollupStateName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var watermark time.Time
+	if err := row.Scan(&watermark); err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return watermark.UTC(), nil
+}
+
+// RollupAnalytics folds every closed hour since the last run into the hourly, daily and
+// dimension rollup tables. Rows are recomputed from the raw table so reruns are idempotent.
+func RollupAnalytics() error {
+	current := time.Now().UTC().Truncate(time.Hour)
+
+	watermark, err := RollupWatermark()
+	if err != nil {
+		return fmt.Errorf("failed to read rollup watermark: %v", err)
+	}
+	if !watermark.IsZero() && !watermark.Before(current) {
+		return nil
+	}
+
+	from := watermark.Add(-rollupLateArrival)
+	if watermark.IsZero() {
+		// First run: backfill from the oldest click
+		var oldest sql.NullTime
+		row, err := postgres.FindOne("SELECT MIN(click_timestamp) FROM analytics")
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&oldest); err != nil {
+			return err
+		}
+		if !oldest.Valid {
+			oldest.Time = current
+		}
+		from = oldest.Time.UTC().Truncate(time.Hour)
+	}
+	// Daily buckets are always recomputed from the start of the day
+	dayStart := from.Truncate(24 * time.Hour)
+
+	tx, err := postgres.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO analytics_rollup_hourly (user_uid, short_link, bucket_start, clicks, unique_visitors, qr_clicks, direct_clicks)
+		SELECT
+			user_uid,
+			short_link,
+			date_trunc('hour', click_timestamp),
+			COUNT(*),
+			COUNT(DISTINCT COALESCE(visitor_hash, ip_address)),
+			COUNT(*) FILTER (WHERE is_qr_code = TRUE),
+			COUNT(*) FILTER (WHERE COALESCE(referrer, '') = '')
+		FROM analytics
+		WHERE user_uid IS NOT NULL AND click_timestamp >= $1 AND click_timestamp < $2
+		GROUP BY user_uid, short_link, date_trunc('hour', click_timestamp)
+		ON CONFLICT (user_uid, short_link, bucket_start) DO UPDATE SET
+			clicks = EXCLUDED.clicks,
+			unique_visitors = EXCLUDED.unique_visitors,
+			qr_clicks = EXCLUDED.qr_clicks,
+			direct_clicks = EXCLUDED.direct_clicks
+	`, from, current); err != nil {
+		return fmt.Errorf("failed to roll up hourly analytics: %v", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO analytics_rollup_daily (user_uid, short_link, bucket_date, clicks, unique_visitors, qr_clicks, direct_clicks)
+		SELECT
+			user_uid,
+			short_link,
+			click_timestamp::date,
+			COUNT(*),
+			COUNT(DISTINCT COALESCE(visitor_hash, ip_address)),
+			COUNT(*) FILTER (WHERE is_qr_code = TRUE),
+			COUNT(*) FILTER (WHERE COALESCE(referrer, '') = '')
+		FROM analytics
+		WHERE user_uid IS NOT NULL AND click_timestamp >= $1 AND click_timestamp < $2
+		GROUP BY user_uid, short_link, click_timestamp::date
+		ON CONFLICT (user_uid, short_link, bucket_date) DO UPDATE SET
+			clicks = EXCLUDED.clicks,
+			unique_visitors = EXCLUDED.unique_visitors,
+			qr_clicks = EXCLUDED.qr_clicks,
+			direct_clicks = EXCLUDED.direct_clicks
+	`, dayStart, current); err != nil {
+		return fmt.Errorf("failed to roll up daily analytics: %v", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO analytics_rollup_dimensions (user_uid, short_link, bucket_date, dimension, value, value_code, clicks)
+		SELECT
+			a.user_uid,
+			a.short_link,
+			a.click_timestamp::date,
+			d.dimension,
+			d.value,
+			MAX(d.value_code),
+			COUNT(*)
+		FROM analytics a
+		CROSS JOIN LATERAL (VALUES
+			('browser', COALESCE(a.browser, ''), ''),
+			('os', COALESCE(a.operating_system, ''), ''),
+			('device', COALESCE(a.device_type, ''), ''),
+			('country', COALESCE(a.country, ''), COALESCE(a.country_code, '')),
+			('city', COALESCE(a.city, ''), ''),
+			('referrer', COALESCE(a.referrer_host, ''), ''),
+			('source', COALESCE(a.referrer_source, ''), '')
+		) AS d(dimension, value, value_code)
+		WHERE a.user_uid IS NOT NULL AND a.click_timestamp >= $1 AND a.click_timestamp < $2
+		GROUP BY a.user_uid, a.short_link, a.click_timestamp::date, d.dimension, d.value
+		ON CONFLICT (user_uid, short_link, bucket_date, dimension, value) DO UPDATE SET
+			value_code = EXCLUDED.value_code,
+			clicks = EXCLUDED.clicks
+	`, dayStart, current); err != nil {
+		return fmt.Errorf("failed to roll up analytics dimensions: %v", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO analytics_rollup_state (name, watermark) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET watermark = EXCLUDED.watermark
+	`, rollupStateName, current); err != nil {
+		return fmt.Errorf("failed to update rollup watermark: %v", err)
+	}
+
+	return tx.Commit()
+}
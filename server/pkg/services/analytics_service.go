@@ -19,12 +19,14 @@ import (
 
 // AnalyticsData represents the structure of analytics data stored in Redis
 type AnalyticsData struct {
+	ID        string `json:"id,omitempty"`
 	ShortLink string `json:"short_link"`
 	IP        string `json:"ip"`
 	UserAgent string `json:"ua"`
 	Timestamp string `json:"ts"`
 	IsQR      bool   `json:"is_qr"`
 	Referrer  string `json:"referrer,omitempty"`
+	DNT       bool   `json:"dnt,omitempty"`
 }
 
 // ProcessedAnalytics represents the processed analytics data for PostgreSQL
@@ -33,6 +35,7 @@ type ProcessedAnalytics struct {
 	UserUID        *string
 	IPAddress      string
 	UserAgent      string
+	VisitorHash    *string
 	Browser        string
 	BrowserVersion string
 	OS             string
@@ -46,7 +49,11 @@ type ProcessedAnalytics struct {
 	Latitude       float64
 	Longitude      float64
 	Referrer       string
+	ReferrerHost   string
+	ReferrerSource string
 	IsQRCode       bool
+	IsBot          bool
+	ClickID        string
 	ClickTimestamp time.Time
 	ClickDate      time.Time
 	ClickTime      time.Time
@@ -64,6 +71,7 @@ type UserAgentInfo struct {
 	OS             string
 	OSVersion      string
 	DeviceType     string
+	IsBot          bool
 }
 
 // GeoLocation contains geolocation information
@@ -77,22 +85,43 @@ type GeoLocation struct {
 	Timezone    string  `json:"timezone"`
 }
 
-// PushAnalytics stores analytics data in Redis
-func PushAnalytics(shortLink, ip, userAgent string, isQR bool, referrer string) error {
+// PushAnalytics stores analytics data in Redis, announces the click to live viewers and returns its click ID
+// dnt marks clicks sent with a DNT or Sec-GPC header, which are always anonymized
+func PushAnalytics(userUID, shortLink, ip, userAgent string, isQR bool, referrer string, dnt bool) (string, error) {
+	id, err := GenerateAPIKey(8)
+	if err != nil {
+		return "", err
+	}
 	data := AnalyticsData{
+		ID:        id,
 		ShortLink: shortLink,
 		IP:        ip,
 		UserAgent: userAgent,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		IsQR:      isQR,
 		Referrer:  referrer,
+		DNT:       dnt,
 	}
 
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return rdb.RC.LPush("analytics:"+shortLink, jsonData)
+	if err := rdb.RC.LPush("analytics:"+shortLink, jsonData); err != nil {
+		return "", err
+	}
+	// Conversions can arrive before the worker has stored the click
+	rememberClick(id, shortLink)
+
+	clickedAt, _ := time.Parse(time.RFC3339, data.Timestamp)
+	announceReceivedClick(userUID, referrer, LiveClick{
+		ID:        id,
+		Stage:     LiveClickReceived,
+		ShortLink: shortLink,
+		ClickedAt: clickedAt,
+		IsQR:      isQR,
+	})
+	return id, nil
 }
 
 // ProcessAnalyticsData processes and stores analytics data from Redis to PostgreSQL
@@ -103,7 +132,8 @@ func ProcessAnalyticsData() error {
 		return fmt.Errorf("failed to get analytics keys: %v", err)
 	}
 	if len(keys) == 0 {
-		return nil // No analytics data to process
+		// No analytics data to process, just keep rollups current
+		return RollupAnalytics()
 	}
 
 	for _, key := range keys {
@@ -129,12 +159,43 @@ func ProcessAnalyticsData() error {
 				fmt.Printf("Failed to store analytics for ----------------- %s: %v\n", shortLink, err)
 				continue
 			}
+
+			if err := attachConversionClick(processed); err != nil {
+				log.Printf("Failed to attribute conversions to click %s: %v", processed.ClickID, err)
+			}
+
+			if processed.UserUID != nil {
+				click := LiveClick{
+					ID:             data.ID,
+					Stage:          LiveClickProcessed,
+					ShortLink:      processed.ShortLink,
+					ClickedAt:      processed.ClickTimestamp,
+					IsQR:           processed.IsQRCode,
+					ReferrerHost:   processed.ReferrerHost,
+					ReferrerSource: processed.ReferrerSource,
+					Browser:        processed.Browser,
+					OS:             processed.OS,
+					DeviceType:     processed.DeviceType,
+					Country:        processed.Country,
+					CountryCode:    processed.CountryCode,
+					City:           processed.City,
+				}
+				publishLiveClick(*processed.UserUID, click)
+				if err := EnqueueWebhookEvent(*processed.UserUID, EventClickRecorded, click); err != nil {
+					log.Printf("Failed to queue %s webhook for %s: %v", EventClickRecorded, shortLink, err)
+				}
+			}
 		}
 
 		// Remove the processed key from Redis
 		rdb.RC.Del(key)
 	}
 
+	// Fold closed hours into the rollup tables
+	if err := RollupAnalytics(); err != nil {
+		return fmt.Errorf("failed to roll up analytics: %v", err)
+	}
+
 	return nil
 }
 
@@ -146,17 +207,39 @@ func processAnalyticsEntry(data AnalyticsData) ProcessedAnalytics {
 	// Parse user agent
 	uaInfo := parseUserAgent(data.UserAgent)
 
+	// Get user UID and privacy preference from short link
+	userUID, privacyMode := getLinkOwner(data.ShortLink)
+
+	ip := data.IP
+	userAgent := data.UserAgent
+	geoIP := data.IP
+	var visitorHash *string
+
+	// In privacy mode only a daily salted hash of IP+UA is kept for uniqueness
+	// and the IP is truncated before it is used for geolocation
+	if privacyMode || data.DNT {
+		hash, err := VisitorHash(data.IP, data.UserAgent, timestamp)
+		if err != nil {
+			log.Printf("Failed to hash visitor for %s: %v", data.ShortLink, err)
+		} else {
+			visitorHash = &hash
+		}
+		geoIP = TruncateIP(data.IP)
+		ip = ""
+		userAgent = ""
+	}
+
 	// Get geoLocation
-	geoInfo := getGeoLocation(data.IP)
+	geoInfo := getGeoLocation(geoIP)
 
-	// Get user UID from short link
-	userUID := getUserUIDFromShortLink(data.ShortLink)
+	referrerHost, referrerSource := ClassifyReferrer(data.Referrer)
 
 	return ProcessedAnalytics{
 		ShortLink:      data.ShortLink,
 		UserUID:        userUID,
-		IPAddress:      data.IP,
-		UserAgent:      data.UserAgent,
+		IPAddress:      ip,
+		UserAgent:      userAgent,
+		VisitorHash:    visitorHash,
 		Browser:        uaInfo.Browser,
 		BrowserVersion: uaInfo.BrowserVersion,
 		OS:             uaInfo.OS,
@@ -170,7 +253,11 @@ func processAnalyticsEntry(data AnalyticsData) ProcessedAnalytics {
 		Latitude:       geoInfo.Latitude,
 		Longitude:      geoInfo.Longitude,
 		Referrer:       data.Referrer,
+		ReferrerHost:   referrerHost,
+		ReferrerSource: referrerSource,
 		IsQRCode:       data.IsQR,
+		IsBot:          uaInfo.IsBot,
+		ClickID:        data.ID,
 		ClickTimestamp: timestamp,
 		ClickDate:      timestamp,
 		ClickTime:      timestamp,
@@ -182,6 +269,14 @@ func processAnalyticsEntry(data AnalyticsData) ProcessedAnalytics {
 	}
 }
 
+// botMarkers are lowercase user agent fragments of crawlers, link previewers, monitors and HTTP libraries
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "crawl", "headless", "phantomjs", "lighthouse",
+	"facebookexternalhit", "embedly", "preview", "monitor", "uptime", "pingdom",
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "go-http-client",
+	"java/", "okhttp", "apache-httpclient", "libwww", "node-fetch", "axios/", "scrapy",
+}
+
 // parseUserAgent parses user agent string to extract browser and OS information
 func parseUserAgent(userAgent string) UserAgentInfo {
 	ua := strings.ToLower(userAgent)
@@ -257,6 +352,15 @@ func parseUserAgent(userAgent string) UserAgentInfo {
 		info.DeviceType = "Tablet"
 	}
 
+	// Bot detection
+	info.IsBot = ua == ""
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			info.IsBot = true
+			break
+		}
+	}
+
 	return info
 }
 
@@ -318,19 +422,32 @@ func getGeoLocation(ip string) *GeoLocation {
 	return geo
 }
 
-// getUserUIDFromShortLink gets the user UID associated with a short link
-func getUserUIDFromShortLink(shortLink string) *string {
-	row, err := postgres.FindOne("SELECT user_uid FROM links WHERE short_link = $1", shortLink)
+// getLinkOwner gets the user UID associated with a short link and whether the user has privacy mode enabled
+func getLinkOwner(shortLink string) (*string, bool) {
+	row, err := postgres.FindOne(`
+		SELECT l.user_uid, COALESCE(u.privacy_mode, FALSE)
+		FROM links l
+		JOIN users u ON u.uid = l.user_uid
+		WHERE l.short_link = $1`, shortLink)
 	if err != nil {
-		return nil
+		return nil, false
 	}
 
 	var userUID string
-	if err := row.Scan(&userUID); err != nil {
-		return nil
+	var privacyMode bool
+	if err := row.Scan(&userUID, &privacyMode); err != nil {
+		return nil, false
 	}
 
-	return &userUID
+	return &userUID, privacyMode
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
 
 // storeAnalyticsInPostgres stores processed analytics data in PostgreSQL
@@ -341,23 +458,24 @@ func storeAnalyticsInPostgres(data ProcessedAnalytics) error {
 			operating_system, os_version, device_type, country, country_code,
 			city, region, timezone, latitude, longitude, referrer, is_qr_code, click_timestamp,
 			click_date, click_time, day_of_week, hour_of_day, week_of_year,
-			month, year
+			month, year, visitor_hash, referrer_host, referrer_source, is_bot, click_id
 		) VALUES (
 			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
-			$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26
+			$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31
 		)
 	`
 
 	_, err := postgres.InsertOne(
 		query,
-		data.ShortLink, data.UserUID, data.IPAddress, data.UserAgent,
+		data.ShortLink, data.UserUID, nullIfEmpty(data.IPAddress), nullIfEmpty(data.UserAgent),
 		data.Browser, data.BrowserVersion, data.OS, data.OSVersion,
 		data.DeviceType, data.Country, data.CountryCode, data.City,
 		data.Region, data.Timezone, data.Latitude, data.Longitude,
 		data.Referrer, data.IsQRCode,
 		data.ClickTimestamp, data.ClickDate, data.ClickTime,
 		data.DayOfWeek, data.HourOfDay, data.WeekOfYear,
-		data.Month, data.Year,
+		data.Month, data.Year, data.VisitorHash,
+		nullIfEmpty(data.ReferrerHost), nullIfEmpty(data.ReferrerSource), data.IsBot, nullIfEmpty(data.ClickID),
 	)
 	if err != nil {
 		fmt.Println("Error: ", err)
@@ -0,0 +1,530 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// StatsScope narrows analytics queries to an account, optionally a single link or folder and a date range.
+// Dates are calendar days in TimeZone (UTC when empty). Clicks before Watermark are read from the
+// rollup tables, later ones and partial buckets at the edges of the range from the raw analytics table.
+type StatsScope struct {
+	UserUID   string
+	ShortLink string
+	FolderID  string
+	StartDate string // inclusive, YYYY-MM-DD
+	EndDate   string // inclusive, YYYY-MM-DD
+	TimeZone  string // IANA name
+	Watermark time.Time
+}
+
+// ClickTotals contains the headline counters for a scope. UniqueVisitors sums the distinct
+// visitors of each link and UTC day.
+type ClickTotals struct {
+	TotalClicks    int64
+	UniqueVisitors int64
+	QRClicks       int64
+	DirectClicks   int64
+}
+
+// DimensionCount is a single row of a breakdown such as browser or country
+type DimensionCount struct {
+	Value  string
+	Code   string
+	Clicks int64
+}
+
+// LinkClickTotals contains the counters of a single link within a scope
+type LinkClickTotals struct {
+	ShortLink    string
+	OriginalLink string
+	TotalClicks  int64
+	QRClicks     int64
+	DirectClicks int64
+}
+
+// statsSource is the table a time bucketed query is answered from
+type statsSource int
+
+const (
+	sourceDaily  statsSource = iota // analytics_rollup_daily, only usable for UTC days
+	sourceHourly                    // analytics_rollup_hourly, usable for zones with whole-hour offsets
+	sourceRaw                       // analytics only
+)
+
+// Raw analytics columns backing each rollup dimension
+var dimensionColumns = map[string]string{
+	"browser":  "a.browser",
+	"os":       "a.operating_system",
+	"device":   "a.device_type",
+	"country":  "a.country",
+	"city":     "a.city",
+	"referrer": "a.referrer_host",
+	"source":   "a.referrer_source",
+}
+
+// NewStatsScope builds a scope using the current rollup watermark
+func NewStatsScope(userUID, shortLink, startDate, endDate, timeZone string) (StatsScope, error) {
+	watermark, err := RollupWatermark()
+	if err != nil {
+		return StatsScope{}, err
+	}
+	scope := StatsScope{
+		UserUID:   userUID,
+		ShortLink: shortLink,
+		StartDate: startDate,
+		EndDate:   endDate,
+		TimeZone:  timeZone,
+		Watermark: watermark,
+	}
+	if _, _, err := scope.Bounds(); err != nil {
+		return StatsScope{}, err
+	}
+	return scope, nil
+}
+
+// ValidateTimeZone checks that name is an IANA time zone
+func ValidateTimeZone(name string) error {
+	if name == "" || name == "Local" {
+		return fmt.Errorf("invalid time zone: %q", name)
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("invalid time zone: %q", name)
+	}
+	return nil
+}
+
+// ResolveTimeZone returns the requested time zone, or the user's preferred one when none is given
+func ResolveTimeZone(requested, userUID string) (string, error) {
+	if requested != "" {
+		if err := ValidateTimeZone(requested); err != nil {
+			return "", err
+		}
+		return requested, nil
+	}
+
+	tz := "UTC"
+	row, err := postgres.FindOne("SELECT COALESCE(timezone, 'UTC') FROM users WHERE uid = $1", userUID)
+	if err == nil {
+		row.Scan(&tz)
+	}
+	if ValidateTimeZone(tz) != nil {
+		tz = "UTC"
+	}
+	return tz, nil
+}
+
+// Location returns the scope's time zone, UTC when unset or invalid
+func (s StatsScope) Location() *time.Location {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Bounds returns the UTC instants [from, to) covered by the scope's dates. Zero values are open ends.
+func (s StatsScope) Bounds() (from, to time.Time, err error) {
+	loc := s.Location()
+	if s.StartDate != "" {
+		d, err := time.ParseInLocation("2006-01-02", s.StartDate, loc)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid start date: %s", s.StartDate)
+		}
+		from = d.UTC()
+	}
+	if s.EndDate != "" {
+		d, err := time.ParseInLocation("2006-01-02", s.EndDate, loc)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid end date: %s", s.EndDate)
+		}
+		to = d.AddDate(0, 0, 1).UTC()
+	}
+	return from, to, nil
+}
+
+// chartSource picks the cheapest table that can bucket clicks in the scope's time zone.
+// Hourly rollups can be shifted to any zone whose offset is a whole number of hours;
+// zones like Asia/Kolkata (+05:30) fall back to the raw table.
+func (s StatsScope) chartSource(needsHours bool) statsSource {
+	loc := s.Location()
+	if loc == time.UTC && !needsHours {
+		return sourceDaily
+	}
+
+	from, to, _ := s.Bounds()
+	now := time.Now()
+	checks := []time.Time{now, time.Date(now.Year(), 1, 1, 0, 0, 0, 0, loc), time.Date(now.Year(), 7, 1, 0, 0, 0, 0, loc)}
+	for _, t := range []time.Time{from, to} {
+		if !t.IsZero() {
+			checks = append(checks, t)
+		}
+	}
+	for _, t := range checks {
+		if _, offset := t.In(loc).Zone(); offset%3600 != 0 {
+			return sourceRaw
+		}
+	}
+	return sourceHourly
+}
+
+type queryArgs []any
+
+// add appends a query argument and returns its placeholder
+func (q *queryArgs) add(v any) string {
+	*q = append(*q, v)
+	return fmt.Sprintf("$%d", len(*q))
+}
+
+func ceilTime(t time.Time, unit time.Duration) time.Time {
+	if f := t.Truncate(unit); !f.Equal(t) {
+		return f.Add(unit)
+	}
+	return t
+}
+
+// folderLinks returns a subquery selecting the short links in the scope's folder
+func (s StatsScope) folderLinks(args *queryArgs) string {
+	return fmt.Sprintf("(SELECT short_link FROM links WHERE folder_id::text = %s AND user_uid = %s)", args.add(s.FolderID), args.add(s.UserUID))
+}
+
+// rollupFilter returns the WHERE clause for the rollup table of source, limited to buckets fully inside the range
+func (s StatsScope) rollupFilter(args *queryArgs, source statsSource) string {
+	conds := []string{"user_uid = " + args.add(s.UserUID)}
+	if s.ShortLink != "" {
+		conds = append(conds, "short_link = "+args.add(s.ShortLink))
+	}
+	if s.FolderID != "" {
+		conds = append(conds, "short_link IN "+s.folderLinks(args))
+	}
+
+	column, unit := "bucket_date", 24*time.Hour
+	if source == sourceHourly {
+		column, unit = "bucket_start", time.Hour
+	}
+	from, to, _ := s.Bounds()
+	if !from.IsZero() {
+		conds = append(conds, column+" >= "+args.add(ceilTime(from, unit)))
+	}
+	if !to.IsZero() {
+		conds = append(conds, column+" < "+args.add(to.Truncate(unit)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// rawFilter returns the WHERE clause for the raw analytics table (aliased a). Unless source is
+// sourceRaw it only matches clicks the rollups don't cover: those after the watermark and
+// those in partial buckets at the edges of the range.
+func (s StatsScope) rawFilter(args *queryArgs, source statsSource) string {
+	conds := []string{"a.user_uid = " + args.add(s.UserUID)}
+	if s.ShortLink != "" {
+		conds = append(conds, "a.short_link = "+args.add(s.ShortLink))
+	}
+	if s.FolderID != "" {
+		conds = append(conds, "a.short_link IN "+s.folderLinks(args))
+	}
+
+	from, to, _ := s.Bounds()
+	if !from.IsZero() {
+		conds = append(conds, "a.click_timestamp >= "+args.add(from))
+	}
+	if !to.IsZero() {
+		conds = append(conds, "a.click_timestamp < "+args.add(to))
+	}
+
+	if source != sourceRaw {
+		unit := 24 * time.Hour
+		if source == sourceHourly {
+			unit = time.Hour
+		}
+		uncovered := []string{"a.click_timestamp >= " + args.add(s.Watermark)}
+		if !from.IsZero() {
+			uncovered = append(uncovered, "a.click_timestamp < "+args.add(ceilTime(from, unit)))
+		}
+		if !to.IsZero() {
+			uncovered = append(uncovered, "a.click_timestamp >= "+args.add(to.Truncate(unit)))
+		}
+		conds = append(conds, "("+strings.Join(uncovered, " OR ")+")")
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// chartQuery builds a clicks-per-bucket query. bucketExpr is a format string applied to
+// a SQL expression yielding the local timestamp of the click (or rollup bucket).
+func (s StatsScope) chartQuery(args *queryArgs, bucketExpr string, needsHours bool) string {
+	source := s.chartSource(needsHours)
+	tzArg := args.add(s.Location().String())
+
+	rawTs := fmt.Sprintf("(a.click_timestamp AT TIME ZONE 'UTC' AT TIME ZONE %s)", tzArg)
+	raw := fmt.Sprintf(`
+			SELECT %s AS bucket, COUNT(*) AS clicks
+			FROM analytics a
+			WHERE %s
+			GROUP BY 1`, fmt.Sprintf(bucketExpr, rawTs), s.rawFilter(args, source))
+
+	rollup := ""
+	switch source {
+	case sourceDaily:
+		rollup = fmt.Sprintf(`
+			SELECT %s AS bucket, clicks
+			FROM analytics_rollup_daily
+			WHERE %s
+			UNION ALL`, fmt.Sprintf(bucketExpr, "bucket_date::timestamp"), s.rollupFilter(args, source))
+	case sourceHourly:
+		rollupTs := fmt.Sprintf("(bucket_start AT TIME ZONE 'UTC' AT TIME ZONE %s)", tzArg)
+		rollup = fmt.Sprintf(`
+			SELECT %s AS bucket, clicks
+			FROM analytics_rollup_hourly
+			WHERE %s
+			UNION ALL`, fmt.Sprintf(bucketExpr, rollupTs), s.rollupFilter(args, source))
+	}
+
+	return fmt.Sprintf(`
+		SELECT bucket, SUM(clicks)::bigint FROM (%s%s
+		) t
+		GROUP BY bucket ORDER BY bucket
+	`, rollup, raw)
+}
+
+// FetchClickTotals returns total clicks, unique visitors, QR and direct clicks.
+// Unique visitors are counted per link and UTC day, like the daily rollups and the daily rotation
+// of privacy mode hashes, and summed over the range: a visitor coming back on another day counts
+// again. The day the watermark falls in is read from the raw table only, so no day is counted
+// from both sources.
+func FetchClickTotals(s StatsScope) (ClickTotals, error) {
+	raw := s
+	raw.Watermark = s.Watermark.Truncate(24 * time.Hour)
+
+	var args queryArgs
+	query := fmt.Sprintf(`
+		SELECT
+			COALESCE(SUM(clicks), 0)::bigint,
+			COALESCE(SUM(unique_visitors), 0)::bigint,
+			COALESCE(SUM(qr_clicks), 0)::bigint,
+			COALESCE(SUM(direct_clicks), 0)::bigint
+		FROM (
+			SELECT clicks, unique_visitors, qr_clicks, direct_clicks
+			FROM analytics_rollup_daily
+			WHERE %s AND bucket_date < %s
+			UNION ALL
+			SELECT
+				COUNT(*),
+				COUNT(DISTINCT COALESCE(a.visitor_hash, a.ip_address)),
+				COUNT(*) FILTER (WHERE a.is_qr_code = TRUE),
+				COUNT(*) FILTER (WHERE COALESCE(a.referrer, '') = '')
+			FROM analytics a
+			WHERE %s
+			GROUP BY a.short_link, a.click_timestamp::date
+		) t
+	`, s.rollupFilter(&args, sourceDaily), args.add(raw.Watermark), raw.rawFilter(&args, sourceDaily))
+
+	var totals ClickTotals
+	row, err := postgres.FindOne(query, args...)
+	if err != nil {
+		return totals, err
+	}
+	err = row.Scan(&totals.TotalClicks, &totals.UniqueVisitors, &totals.QRClicks, &totals.DirectClicks)
+	return totals, err
+}
+
+// FetchHourlyStats returns clicks per hour of day
+func FetchHourlyStats(s StatsScope) (map[int]int64, error) {
+	var args queryArgs
+	query := s.chartQuery(&args, "EXTRACT(HOUR FROM %s)::int", true)
+	return fetchIntBuckets(query, args)
+}
+
+// FetchDailyStats returns clicks per calendar day keyed by YYYY-MM-DD
+func FetchDailyStats(s StatsScope) (map[string]int64, error) {
+	var args queryArgs
+	query := s.chartQuery(&args, "(%s)::date", false)
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int64)
+	for rows.Next() {
+		var date time.Time
+		var count int64
+		if err := rows.Scan(&date, &count); err != nil {
+			return nil, err
+		}
+		stats[date.Format("2006-01-02")] = count
+	}
+	return stats, rows.Err()
+}
+
+// FetchWeekdayStats returns clicks per day of week (0 = Sunday)
+func FetchWeekdayStats(s StatsScope) (map[int]int64, error) {
+	var args queryArgs
+	query := s.chartQuery(&args, "EXTRACT(DOW FROM %s)::int", false)
+	return fetchIntBuckets(query, args)
+}
+
+// FetchMonthlyStats returns clicks per month number
+func FetchMonthlyStats(s StatsScope) (map[string]int64, error) {
+	var args queryArgs
+	query := s.chartQuery(&args, "EXTRACT(MONTH FROM %s)::int", false)
+
+	buckets, err := fetchIntBuckets(query, args)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]int64, len(buckets))
+	for month, count := range buckets {
+		stats[fmt.Sprintf("%d", month)] = count
+	}
+	return stats, nil
+}
+
+// FetchDimensionStats returns clicks per value of a dimension (browser, os, device, country, city,
+// referrer or source), most clicked first. Values listed in exclude are skipped.
+func FetchDimensionStats(s StatsScope, dimension string, exclude ...string) ([]DimensionCount, error) {
+	column, ok := dimensionColumns[dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown analytics dimension: %s", dimension)
+	}
+	codeColumn := "''::text"
+	if dimension == "country" {
+		codeColumn = "COALESCE(a.country_code, '')"
+	}
+
+	var args queryArgs
+	dimensionArg := args.add(dimension)
+	rollupWhere := s.rollupFilter(&args, sourceDaily)
+	rawWhere := s.rawFilter(&args, sourceDaily)
+
+	excludeFilter := ""
+	if len(exclude) > 0 {
+		placeholders := make([]string, len(exclude))
+		for i, v := range exclude {
+			placeholders[i] = args.add(v)
+		}
+		excludeFilter = fmt.Sprintf("WHERE value NOT IN (%s)", strings.Join(placeholders, ", "))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT value, MAX(value_code), SUM(clicks)::bigint FROM (
+			SELECT value, value_code, clicks
+			FROM analytics_rollup_dimensions
+			WHERE dimension = %s AND %s
+			UNION ALL
+			SELECT COALESCE(%s, ''), MAX(%s), COUNT(*)
+			FROM analytics a
+			WHERE %s
+			GROUP BY COALESCE(%s, '')
+		) t
+		%s
+		GROUP BY value
+		ORDER BY SUM(clicks) DESC, value ASC
+	`, dimensionArg, rollupWhere, column, codeColumn, rawWhere, column, excludeFilter)
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []DimensionCount{}
+	for rows.Next() {
+		var dc DimensionCount
+		if err := rows.Scan(&dc.Value, &dc.Code, &dc.Clicks); err != nil {
+			return nil, err
+		}
+		stats = append(stats, dc)
+	}
+	return stats, rows.Err()
+}
+
+// FetchTopLinks returns the most clicked links of the scope, all of them when limit is not positive
+func FetchTopLinks(s StatsScope, limit int) ([]LinkClickTotals, error) {
+	var args queryArgs
+	userArg := args.add(s.UserUID)
+	rollupWhere := s.rollupFilter(&args, sourceDaily)
+	rawWhere := s.rawFilter(&args, sourceDaily)
+	query := fmt.Sprintf(`
+		SELECT t.short_link, l.original_link, SUM(t.clicks)::bigint, SUM(t.qr_clicks)::bigint, SUM(t.direct_clicks)::bigint
+		FROM (
+			SELECT short_link, clicks, qr_clicks, direct_clicks
+			FROM analytics_rollup_daily
+			WHERE %s
+			UNION ALL
+			SELECT
+				a.short_link,
+				COUNT(*),
+				COUNT(*) FILTER (WHERE a.is_qr_code = TRUE),
+				COUNT(*) FILTER (WHERE COALESCE(a.referrer, '') = '')
+			FROM analytics a
+			WHERE %s
+			GROUP BY a.short_link
+		) t
+		JOIN links l ON l.short_link = t.short_link AND l.user_uid = %s
+		GROUP BY t.short_link, l.original_link
+		ORDER BY SUM(t.clicks) DESC
+	`, rollupWhere, rawWhere, userArg)
+	if limit > 0 {
+		query += "LIMIT " + args.add(limit)
+	}
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []LinkClickTotals{}
+	for rows.Next() {
+		var l LinkClickTotals
+		if err := rows.Scan(&l.ShortLink, &l.OriginalLink, &l.TotalClicks, &l.QRClicks, &l.DirectClicks); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// TopDimensionValue returns the most clicked value of a dimension, or fallback when there are no clicks
+func TopDimensionValue(s StatsScope, dimension, fallback string) (string, error) {
+	stats, err := FetchDimensionStats(s, dimension, "")
+	if err != nil {
+		return fallback, err
+	}
+	if len(stats) == 0 {
+		return fallback, nil
+	}
+	return stats[0].Value, nil
+}
+
+func fetchIntBuckets(query string, args queryArgs) (map[int]int64, error) {
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int]int64)
+	for rows.Next() {
+		var bucket int
+		var count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		stats[bucket] = count
+	}
+	return stats, rows.Err()
+}
+
+// DimensionMap converts a breakdown into a value => clicks map
+func DimensionMap(stats []DimensionCount) map[string]int64 {
+	m := make(map[string]int64, len(stats))
+	for _, dc := range stats {
+		m[dc.Value] = dc.Clicks
+	}
+	return m
+}
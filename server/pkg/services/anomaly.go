@@ -0,0 +1,337 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Alert kinds
+const (
+	AlertSpike     = "spike"
+	AlertDrop      = "drop"
+	AlertGeography = "geography"
+	AlertBotShare  = "bot_share"
+)
+
+const (
+	anomalyStateName = "anomaly_detection"
+	// Hours of history each link's last hour is compared against
+	anomalyBaselineHours = 7 * 24
+	// A spike needs this many clicks in the hour, mean + anomalySpikeSigmas standard deviations
+	// and anomalySpikeFactor times the hourly mean
+	anomalySpikeMinClicks = 20
+	anomalySpikeSigmas    = 4
+	anomalySpikeFactor    = 3
+	// A drop is anomalyDropHours hours without clicks on a link averaging anomalyDropMinMean clicks an hour
+	anomalyDropHours   = 3
+	anomalyDropMinMean = 5
+	// Geography and bot share are only judged on busy hours of links with enough history
+	anomalyShareMinClicks   = 20
+	anomalyShareMinBaseline = 50
+	anomalyCountryShare     = 0.5
+	anomalyCountryBaseline  = 0.05
+	anomalyBotShare         = 0.5
+	anomalyBotShareBaseline = 0.1
+	anomalyAlertCooldown    = 24 * time.Hour
+)
+
+// ErrAlertNotFound is returned when an alert doesn't exist or belongs to another user
+var ErrAlertNotFound = errors.New("alert not found")
+
+// Alert is a traffic anomaly detected on a link
+type Alert struct {
+	ID          string          `json:"id"`
+	ShortLink   string          `json:"short_link"`
+	Kind        string          `json:"kind"`
+	Message     string          `json:"message"`
+	Details     json.RawMessage `json:"details"`
+	WindowStart time.Time       `json:"window_start"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ReadAt      *time.Time      `json:"read_at"`
+}
+
+// linkTraffic is the hourly click history of a link
+type linkTraffic struct {
+	userUID      string
+	shortLink    string
+	lastHour     int64
+	recent       int64
+	baselineMean float64
+	baselineStd  float64
+}
+
+// DetectTrafficAnomalies compares the last rolled up hour of every link with its rolling
+// 7 day baseline and stores an alert for spikes, drops to zero and unusual geography or
+// bot share. Each hour is evaluated once; a link gets at most one alert per kind a day.
+func DetectTrafficAnomalies() error {
+	watermark, err := RollupWatermark()
+	if err != nil || watermark.IsZero() {
+		return err
+	}
+
+	var evaluated sql.NullTime
+	row, err := postgres.FindOne("SELECT watermark FROM analytics_rollup_state WHERE name = $1", anomalyStateName)
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&evaluated); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if evaluated.Valid && !evaluated.Time.Before(watermark) {
+		return nil
+	}
+
+	lastHour := watermark.Add(-time.Hour)
+	baselineStart := lastHour.Add(-anomalyBaselineHours * time.Hour)
+
+	links, err := fetchLinkTraffic(baselineStart, lastHour, watermark)
+	if err != nil {
+		return fmt.Errorf("failed to fetch link traffic: %v", err)
+	}
+
+	for _, t := range links {
+		for _, a := range detectLinkAnomalies(t, baselineStart, lastHour, watermark) {
+			if err := raiseAlert(t.userUID, a); err != nil {
+				log.Printf("Failed to raise %s alert for %s: %v", a.Kind, t.shortLink, err)
+			}
+		}
+	}
+
+	_, err = postgres.UpdateOne(`
+		INSERT INTO analytics_rollup_state (name, watermark) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET watermark = EXCLUDED.watermark
+	`, anomalyStateName, watermark)
+	return err
+}
+
+// fetchLinkTraffic returns the last hour, the last anomalyDropHours hours and the baseline
+// statistics of every live link old enough to have a full baseline. Hours without a rollup
+// row count as zero clicks.
+func fetchLinkTraffic(baselineStart, lastHour, watermark time.Time) ([]linkTraffic, error) {
+	rows, err := postgres.FindMany(`
+		SELECT h.user_uid, h.short_link,
+			COALESCE(SUM(h.clicks) FILTER (WHERE h.bucket_start >= $2), 0)::bigint,
+			COALESCE(SUM(h.clicks) FILTER (WHERE h.bucket_start >= $3), 0)::bigint,
+			COALESCE(SUM(h.clicks) FILTER (WHERE h.bucket_start < $2), 0)::float8,
+			COALESCE(SUM(h.clicks * h.clicks) FILTER (WHERE h.bucket_start < $2), 0)::float8
+		FROM analytics_rollup_hourly h
+		JOIN links l ON l.short_link = h.short_link AND l.deleted = false
+		WHERE h.bucket_start >= $1 AND h.bucket_start < $4 AND l.created_at <= $1
+		GROUP BY h.user_uid, h.short_link
+	`, baselineStart, lastHour, watermark.Add(-anomalyDropHours*time.Hour), watermark)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []linkTraffic
+	for rows.Next() {
+		var t linkTraffic
+		var sum, sumSquares float64
+		if err := rows.Scan(&t.userUID, &t.shortLink, &t.lastHour, &t.recent, &sum, &sumSquares); err != nil {
+			return nil, err
+		}
+		t.baselineMean = sum / anomalyBaselineHours
+		t.baselineStd = math.Sqrt(math.Max(sumSquares/anomalyBaselineHours-t.baselineMean*t.baselineMean, 0))
+		links = append(links, t)
+	}
+	return links, rows.Err()
+}
+
+// detectLinkAnomalies returns the alerts of a link for the hour starting at lastHour
+func detectLinkAnomalies(t linkTraffic, baselineStart, lastHour, watermark time.Time) []Alert {
+	var alerts []Alert
+	newAlert := func(kind, message string, details map[string]any) {
+		details["last_hour_clicks"] = t.lastHour
+		details["baseline_mean"] = math.Round(t.baselineMean*100) / 100
+		raw, _ := json.Marshal(details)
+		alerts = append(alerts, Alert{ShortLink: t.shortLink, Kind: kind, Message: message, Details: raw, WindowStart: lastHour})
+	}
+
+	if t.lastHour >= anomalySpikeMinClicks &&
+		float64(t.lastHour) >= anomalySpikeFactor*t.baselineMean &&
+		float64(t.lastHour) > t.baselineMean+anomalySpikeSigmas*t.baselineStd {
+		newAlert(AlertSpike, fmt.Sprintf("/%s received %d clicks in an hour, about %.1f an hour is usual", t.shortLink, t.lastHour, t.baselineMean),
+			map[string]any{"baseline_std": math.Round(t.baselineStd*100) / 100})
+	}
+
+	if t.recent == 0 && t.baselineMean >= anomalyDropMinMean {
+		newAlert(AlertDrop, fmt.Sprintf("/%s received no clicks in the last %d hours, about %.1f an hour is usual", t.shortLink, anomalyDropHours, t.baselineMean),
+			map[string]any{"hours_without_clicks": anomalyDropHours})
+	}
+
+	if t.lastHour < anomalyShareMinClicks {
+		return alerts
+	}
+
+	share, err := fetchTrafficShares(t.shortLink, baselineStart, lastHour, watermark)
+	if err != nil {
+		log.Printf("Failed to fetch traffic shares of %s: %v", t.shortLink, err)
+		return alerts
+	}
+	if share.baselineTotal < anomalyShareMinBaseline {
+		return alerts
+	}
+
+	if share.country != "" && share.countryShare >= anomalyCountryShare && share.countryBaselineShare < anomalyCountryBaseline {
+		newAlert(AlertGeography, fmt.Sprintf("%.0f%% of the clicks on /%s in the last hour came from %s, usually %.1f%%",
+			share.countryShare*100, t.shortLink, share.country, share.countryBaselineShare*100),
+			map[string]any{"country": share.country, "share": share.countryShare, "baseline_share": share.countryBaselineShare})
+	}
+
+	if share.botShare >= anomalyBotShare && share.botBaselineShare < anomalyBotShareBaseline {
+		newAlert(AlertBotShare, fmt.Sprintf("%.0f%% of the clicks on /%s in the last hour came from bots, usually %.1f%%",
+			share.botShare*100, t.shortLink, share.botBaselineShare*100),
+			map[string]any{"share": share.botShare, "baseline_share": share.botBaselineShare})
+	}
+	return alerts
+}
+
+// trafficShares compares the top country and bot share of a link's last hour with its baseline
+type trafficShares struct {
+	country              string
+	countryShare         float64
+	countryBaselineShare float64
+	botShare             float64
+	botBaselineShare     float64
+	baselineTotal        int64
+}
+
+// fetchTrafficShares reads the raw clicks of a link; it only runs for links with a busy last hour
+func fetchTrafficShares(shortLink string, baselineStart, lastHour, watermark time.Time) (trafficShares, error) {
+	var s trafficShares
+	var total, bots, countryClicks int64
+
+	row, err := postgres.FindOne(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE is_bot = TRUE) FROM analytics
+		WHERE short_link = $1 AND click_timestamp >= $2 AND click_timestamp < $3
+	`, shortLink, lastHour, watermark)
+	if err != nil {
+		return s, err
+	}
+	if err := row.Scan(&total, &bots); err != nil || total == 0 {
+		return s, err
+	}
+	s.botShare = float64(bots) / float64(total)
+
+	row, err = postgres.FindOne(`
+		SELECT country, COUNT(*) FROM analytics
+		WHERE short_link = $1 AND click_timestamp >= $2 AND click_timestamp < $3
+			AND COALESCE(country, '') NOT IN ('', 'N/A', 'Unknown')
+		GROUP BY country
+		ORDER BY COUNT(*) DESC
+		LIMIT 1
+	`, shortLink, lastHour, watermark)
+	if err != nil {
+		return s, err
+	}
+	if err := row.Scan(&s.country, &countryClicks); err != nil && err != sql.ErrNoRows {
+		return s, err
+	}
+	s.countryShare = float64(countryClicks) / float64(total)
+
+	row, err = postgres.FindOne(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE is_bot = TRUE), COUNT(*) FILTER (WHERE country = $4)
+		FROM analytics
+		WHERE short_link = $1 AND click_timestamp >= $2 AND click_timestamp < $3
+	`, shortLink, baselineStart, lastHour, s.country)
+	if err != nil {
+		return s, err
+	}
+	if err := row.Scan(&s.baselineTotal, &bots, &countryClicks); err != nil || s.baselineTotal == 0 {
+		return s, err
+	}
+	s.botBaselineShare = float64(bots) / float64(s.baselineTotal)
+	s.countryBaselineShare = float64(countryClicks) / float64(s.baselineTotal)
+	return s, nil
+}
+
+// raiseAlert stores an alert unless the link had one of the same kind during the cooldown,
+// then sends it to the user's notification channels
+func raiseAlert(userUID string, a Alert) error {
+	row, err := postgres.FindOne(`
+		INSERT INTO analytics_alerts (user_uid, short_link, kind, message, details, window_start)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE NOT EXISTS (
+			SELECT 1 FROM analytics_alerts WHERE short_link = $2 AND kind = $3 AND created_at > $7
+		)
+		RETURNING id, created_at
+	`, userUID, a.ShortLink, a.Kind, a.Message, string(a.Details), a.WindowStart, time.Now().UTC().Add(-anomalyAlertCooldown))
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&a.ID, &a.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	err = Notify(userUID, Notification{
+		Event:   "alert." + a.Kind,
+		Subject: "Traffic alert: " + strings.ReplaceAll(a.Kind, "_", " ") + " on /" + a.ShortLink,
+		Text:    a.Message + "\n",
+		Data:    a,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deliver alert %s: %v", a.ID, err)
+	}
+	_, err = postgres.UpdateOne("UPDATE analytics_alerts SET notified_at = $1 WHERE id = $2", time.Now().UTC(), a.ID)
+	return err
+}
+
+// ListAlerts returns the most recent alerts of a user, only unread ones when unreadOnly is set
+func ListAlerts(userUID string, unreadOnly bool, limit int) ([]Alert, error) {
+	query := `
+		SELECT id, short_link, kind, message, details, window_start, created_at, read_at
+		FROM analytics_alerts WHERE user_uid = $1`
+	if unreadOnly {
+		query += " AND read_at IS NULL"
+	}
+	query += " ORDER BY created_at DESC LIMIT $2"
+
+	rows, err := postgres.FindMany(query, userUID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		var details []byte
+		if err := rows.Scan(&a.ID, &a.ShortLink, &a.Kind, &a.Message, &details, &a.WindowStart, &a.CreatedAt, &a.ReadAt); err != nil {
+			return nil, err
+		}
+		a.Details = details
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// MarkAlertRead marks an alert of the user as read
+func MarkAlertRead(userUID, id string) error {
+	res, err := postgres.UpdateOne(`
+		UPDATE analytics_alerts SET read_at = COALESCE(read_at, $1)
+		WHERE id::text = $2 AND user_uid = $3
+	`, time.Now().UTC(), id, userUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlertNotFound
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -147,7 +148,8 @@ func Authenticate() gin.HandlerFunc {
 func ExternalAuthenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		type APIKeyProjection struct {
-			Uid string `bson:"uid"`
+			ID  primitive.ObjectID `bson:"_id"`
+			Uid string             `bson:"uid"`
 		}
 
 		ah := c.GetHeader("Authorization")
@@ -162,7 +164,7 @@ func ExternalAuthenticate() gin.HandlerFunc {
 
 		var result APIKeyProjection
 
-		opts := options.FindOne().SetProjection(bson.M{"uid": 1})
+		opts := options.FindOne().SetProjection(bson.M{"_id": 1, "uid": 1})
 		api_doc := mongodb.FindOne("api_keys", bson.M{"hash": hrkey}, opts)
 		if api_doc.Err() != nil {
 			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid API key"})
@@ -175,6 +177,8 @@ func ExternalAuthenticate() gin.HandlerFunc {
 			return
 		}
 		c.Set("uid", result.Uid)
+		// Identifies the key in link revisions, the raw key is never stored
+		c.Set("api_key_id", result.ID.Hex())
 		c.Next()
 	}
 }
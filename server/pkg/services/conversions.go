@@ -0,0 +1,355 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+// ClickIDParam is the query parameter carrying the click ID appended to the destination on redirect.
+// Destinations pass it back when recording a conversion.
+const ClickIDParam = "sot_cid"
+
+const (
+	defaultConversionGoal = "conversion"
+	maxConversionGoal     = 100
+	maxConversionEventID  = 100
+	// The pixel is unauthenticated, so a click can't record more conversions through it than this
+	maxPixelConversionsPerClick = 20
+	// Clicks waiting in Redis for the ingestion worker are remembered this long
+	pendingClickTTL = 24 * time.Hour
+)
+
+var (
+	clickIDPattern  = regexp.MustCompile(`^[0-9a-f]{8,32}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// ErrClickNotFound is returned when a conversion names a click that doesn't exist or belongs to another user
+var ErrClickNotFound = errors.New("click not found")
+
+// Conversion is a downstream goal reached by a visitor of a short link. Value is in Currency,
+// an ISO 4217 code, and EventID optionally makes recording idempotent.
+type Conversion struct {
+	ClickID  string  `json:"click_id"`
+	Goal     string  `json:"goal"`
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency"`
+	EventID  string  `json:"event_id"`
+}
+
+// ConversionTotals contains the conversion counters of a scope. Revenue is keyed by currency.
+type ConversionTotals struct {
+	Conversions int64
+	Revenue     map[string]float64
+}
+
+// ConversionCount is a single row of a conversion breakdown such as country or referrer
+type ConversionCount struct {
+	Value       string
+	Code        string
+	Conversions int64
+	Revenue     map[string]float64
+}
+
+// Conversion columns backing each conversion breakdown
+var conversionDimensionColumns = map[string]string{
+	"link":     "c.short_link",
+	"country":  "c.country",
+	"referrer": "c.referrer_host",
+	"source":   "c.referrer_source",
+}
+
+// AppendClickID adds the click ID to a destination URL, leaving URLs it can't parse untouched.
+// The existing query is kept byte for byte, so signed URLs, parameter order and escaping survive.
+func AppendClickID(destination, clickID string) string {
+	if clickID == "" {
+		return destination
+	}
+	if u, err := url.Parse(destination); err != nil || u.Host == "" {
+		return destination
+	}
+	base, fragment, hasFragment := strings.Cut(destination, "#")
+	switch {
+	case !strings.Contains(base, "?"):
+		base += "?"
+	case !strings.HasSuffix(base, "?") && !strings.HasSuffix(base, "&"):
+		base += "&"
+	}
+	base += ClickIDParam + "=" + url.QueryEscape(clickID)
+	if hasFragment {
+		base += "#" + fragment
+	}
+	return base
+}
+
+// rememberClick maps a click ID to its link until the ingestion worker stores the click
+func rememberClick(clickID, shortLink string) {
+	ttl := pendingClickTTL
+	rdb.RC.Set("clicks:"+clickID, shortLink, &ttl)
+}
+
+// Normalize fills the defaults of a conversion and validates it
+func (c *Conversion) Normalize() error {
+	c.ClickID = strings.ToLower(strings.TrimSpace(c.ClickID))
+	c.Goal = strings.TrimSpace(c.Goal)
+	c.Currency = strings.ToUpper(strings.TrimSpace(c.Currency))
+	c.EventID = strings.TrimSpace(c.EventID)
+
+	if !clickIDPattern.MatchString(c.ClickID) {
+		return errors.New("a valid click_id is required")
+	}
+	if c.Goal == "" {
+		c.Goal = defaultConversionGoal
+	}
+	if len(c.Goal) > maxConversionGoal {
+		return fmt.Errorf("goal must be at most %d characters", maxConversionGoal)
+	}
+	if len(c.EventID) > maxConversionEventID {
+		return fmt.Errorf("event_id must be at most %d characters", maxConversionEventID)
+	}
+	// Written so NaN fails too
+	if !(c.Value >= 0 && c.Value < 1e12) {
+		return errors.New("value must be a non-negative amount")
+	}
+	if c.Currency != "" && !currencyPattern.MatchString(c.Currency) {
+		return errors.New("currency must be an ISO 4217 code")
+	}
+	if c.Value > 0 && c.Currency == "" {
+		return errors.New("currency is required with a value")
+	}
+	return nil
+}
+
+// conversionClick is the click a conversion is attributed to. Country and referrer are
+// unknown until the ingestion worker has stored the click.
+type conversionClick struct {
+	shortLink      string
+	userUID        string
+	country        sql.NullString
+	countryCode    sql.NullString
+	referrerHost   sql.NullString
+	referrerSource sql.NullString
+	clickedAt      sql.NullTime
+}
+
+func findConversionClick(clickID string) (conversionClick, error) {
+	var click conversionClick
+	row, err := postgres.FindOne(`
+		SELECT short_link, user_uid, country, country_code, referrer_host, referrer_source, click_timestamp
+		FROM analytics WHERE click_id = $1 AND user_uid IS NOT NULL
+		LIMIT 1
+	`, clickID)
+	if err != nil {
+		return click, err
+	}
+	err = row.Scan(&click.shortLink, &click.userUID, &click.country, &click.countryCode,
+		&click.referrerHost, &click.referrerSource, &click.clickedAt)
+	if err != sql.ErrNoRows {
+		return click, err
+	}
+
+	shortLink, err := rdb.RC.Get("clicks:" + clickID)
+	if err != nil || shortLink == "" {
+		return click, ErrClickNotFound
+	}
+	row, err = postgres.FindOne("SELECT short_link, user_uid FROM links WHERE short_link = $1", shortLink)
+	if err != nil {
+		return click, err
+	}
+	if err := row.Scan(&click.shortLink, &click.userUID); err != nil {
+		if err == sql.ErrNoRows {
+			return click, ErrClickNotFound
+		}
+		return click, err
+	}
+	return click, nil
+}
+
+// RecordConversion attributes a conversion to its click. userUID is the account recording it, or
+// empty for the conversion pixel, where the click decides the account. It returns false when a
+// conversion with the same event ID was already recorded. The pixel also records a goal once per
+// click when no event ID is given, and stops at maxPixelConversionsPerClick per click.
+func RecordConversion(userUID string, c Conversion) (bool, error) {
+	if err := c.Normalize(); err != nil {
+		return false, err
+	}
+	click, err := findConversionClick(c.ClickID)
+	if err != nil {
+		return false, err
+	}
+	if userUID != "" && click.userUID != userUID {
+		return false, ErrClickNotFound
+	}
+	fromPixel := userUID == ""
+	if fromPixel {
+		row, err := postgres.FindOne("SELECT COUNT(*) FROM conversions WHERE click_id = $1 AND from_pixel", c.ClickID)
+		if err != nil {
+			return false, err
+		}
+		var recorded int
+		if err := row.Scan(&recorded); err != nil {
+			return false, err
+		}
+		if recorded >= maxPixelConversionsPerClick {
+			return false, nil
+		}
+	}
+
+	// No conflict target, so both the event ID and the pixel's per-goal index skip duplicates
+	res, err := postgres.UpdateOne(`
+		INSERT INTO conversions (
+			user_uid, short_link, click_id, goal, value, currency, event_id,
+			country, country_code, referrer_host, referrer_source, clicked_at, converted_at, from_pixel
+		) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT DO NOTHING
+	`, click.userUID, click.shortLink, c.ClickID, c.Goal, c.Value, c.Currency, c.EventID,
+		click.country, click.countryCode, click.referrerHost, click.referrerSource, click.clickedAt, time.Now().UTC(), fromPixel)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// attachConversionClick copies a newly stored click's country and referrer onto the conversions
+// recorded before the worker got to it
+func attachConversionClick(click ProcessedAnalytics) error {
+	if click.ClickID == "" {
+		return nil
+	}
+	_, err := postgres.UpdateOne(`
+		UPDATE conversions SET country = $2, country_code = $3, referrer_host = $4, referrer_source = $5, clicked_at = $6
+		WHERE click_id = $1 AND clicked_at IS NULL
+	`, click.ClickID, nullIfEmpty(click.Country), nullIfEmpty(click.CountryCode),
+		nullIfEmpty(click.ReferrerHost), nullIfEmpty(click.ReferrerSource), click.ClickTimestamp)
+	return err
+}
+
+// conversionFilter returns the WHERE clause selecting the conversions of a scope by conversion time
+func (s StatsScope) conversionFilter(args *queryArgs) string {
+	conds := []string{"c.user_uid = " + args.add(s.UserUID)}
+	if s.ShortLink != "" {
+		conds = append(conds, "c.short_link = "+args.add(s.ShortLink))
+	}
+	if s.FolderID != "" {
+		conds = append(conds, "c.short_link IN "+s.folderLinks(args))
+	}
+	from, to, _ := s.Bounds()
+	if !from.IsZero() {
+		conds = append(conds, "c.converted_at >= "+args.add(from))
+	}
+	if !to.IsZero() {
+		conds = append(conds, "c.converted_at < "+args.add(to))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// FetchConversionTotals returns the conversions and revenue of a scope
+func FetchConversionTotals(s StatsScope) (ConversionTotals, error) {
+	var args queryArgs
+	query := fmt.Sprintf(`
+		SELECT COALESCE(c.currency, ''), COUNT(*), COALESCE(SUM(c.value), 0)::float8
+		FROM conversions c
+		WHERE %s
+		GROUP BY COALESCE(c.currency, '')
+	`, s.conversionFilter(&args))
+
+	totals := ConversionTotals{Revenue: map[string]float64{}}
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return totals, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var currency string
+		var count int64
+		var revenue float64
+		if err := rows.Scan(&currency, &count, &revenue); err != nil {
+			return totals, err
+		}
+		totals.Conversions += count
+		if currency != "" {
+			totals.Revenue[currency] += revenue
+		}
+	}
+	return totals, rows.Err()
+}
+
+// FetchConversionStats returns conversions and revenue per value of a dimension (link, country,
+// referrer or source), most converting first
+func FetchConversionStats(s StatsScope, dimension string) ([]ConversionCount, error) {
+	column, ok := conversionDimensionColumns[dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown conversion dimension: %s", dimension)
+	}
+	codeColumn := "''::text"
+	if dimension == "country" {
+		codeColumn = "COALESCE(c.country_code, '')"
+	}
+
+	var args queryArgs
+	query := fmt.Sprintf(`
+		SELECT COALESCE(%s, ''), MAX(%s), COALESCE(c.currency, ''), COUNT(*), COALESCE(SUM(c.value), 0)::float8
+		FROM conversions c
+		WHERE %s
+		GROUP BY COALESCE(%s, ''), COALESCE(c.currency, '')
+	`, column, codeColumn, s.conversionFilter(&args), column)
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byValue := map[string]*ConversionCount{}
+	for rows.Next() {
+		var value, code, currency string
+		var count int64
+		var revenue float64
+		if err := rows.Scan(&value, &code, &currency, &count, &revenue); err != nil {
+			return nil, err
+		}
+		cc, ok := byValue[value]
+		if !ok {
+			cc = &ConversionCount{Value: value, Code: code, Revenue: map[string]float64{}}
+			byValue[value] = cc
+		}
+		cc.Conversions += count
+		if currency != "" {
+			cc.Revenue[currency] += revenue
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make([]ConversionCount, 0, len(byValue))
+	for _, cc := range byValue {
+		stats = append(stats, *cc)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Conversions != stats[j].Conversions {
+			return stats[i].Conversions > stats[j].Conversions
+		}
+		return stats[i].Value < stats[j].Value
+	})
+	return stats, nil
+}
+
+// ConversionRate returns conversions per click as a percentage
+func ConversionRate(conversions, clicks int64) float64 {
+	if clicks <= 0 {
+		return 0
+	}
+	return float64(conversions) / float64(clicks) * 100
+}
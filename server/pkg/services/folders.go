@@ -0,0 +1,279 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+const (
+	maxFolderName        = 100
+	maxFolderDescription = 1000
+)
+
+var (
+	// ErrFolderNotFound is returned when a folder doesn't exist or belongs to another user
+	ErrFolderNotFound = errors.New("folder not found")
+	// ErrFolderExists is returned when the account already has a folder with the same name
+	ErrFolderExists = errors.New("folder already exists")
+)
+
+// Folder groups links of an account into a campaign. StartDate and EndDate are the optional
+// calendar days the campaign runs, Links counts the links in it.
+type Folder struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	StartDate   *string   `json:"start_date"`
+	EndDate     *string   `json:"end_date"`
+	Links       int       `json:"links"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// FolderClicks contains the clicks on the links of a folder within a scope
+type FolderClicks struct {
+	FolderID    string `json:"folder_id"`
+	Name        string `json:"name"`
+	Links       int    `json:"links"`
+	TotalClicks int64  `json:"total_clicks"`
+}
+
+// Validate checks the name, description and date range of a folder
+func (f *Folder) Validate() error {
+	f.Name = strings.TrimSpace(f.Name)
+	f.Description = strings.TrimSpace(f.Description)
+	if f.Name == "" || len(f.Name) > maxFolderName {
+		return fmt.Errorf("name is required and must be at most %d characters", maxFolderName)
+	}
+	if len(f.Description) > maxFolderDescription {
+		return fmt.Errorf("description must be at most %d characters", maxFolderDescription)
+	}
+
+	start, err := folderDate("start_date", &f.StartDate)
+	if err != nil {
+		return err
+	}
+	end, err := folderDate("end_date", &f.EndDate)
+	if err != nil {
+		return err
+	}
+	if start != nil && end != nil && end.Before(*start) {
+		return errors.New("end_date is before start_date")
+	}
+	return nil
+}
+
+// folderDate parses an optional date of a folder, clearing it when empty
+func folderDate(name string, value **string) (*time.Time, error) {
+	if *value == nil || **value == "" {
+		*value = nil
+		return nil, nil
+	}
+	t, err := time.Parse(time.DateOnly, **value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date as YYYY-MM-DD", name)
+	}
+	return &t, nil
+}
+
+const folderColumns = `f.id, f.name, COALESCE(f.description, ''), to_char(f.start_date, 'YYYY-MM-DD'),
+	to_char(f.end_date, 'YYYY-MM-DD'), (SELECT COUNT(*) FROM links l WHERE l.folder_id = f.id AND l.deleted = FALSE),
+	f.created_at, f.updated_at`
+
+func scanFolder(row interface{ Scan(...any) error }) (Folder, error) {
+	var f Folder
+	err := row.Scan(&f.ID, &f.Name, &f.Description, &f.StartDate, &f.EndDate, &f.Links, &f.CreatedAt, &f.UpdatedAt)
+	return f, err
+}
+
+// ListFolders returns the folders of a user by name
+func ListFolders(userUID string) ([]Folder, error) {
+	rows, err := postgres.FindMany("SELECT "+folderColumns+" FROM folders f WHERE f.user_uid = $1 ORDER BY lower(f.name)", userUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// GetFolder returns a folder of a user
+func GetFolder(userUID, id string) (Folder, error) {
+	row, err := postgres.FindOne("SELECT "+folderColumns+" FROM folders f WHERE f.id::text = $1 AND f.user_uid = $2", id, userUID)
+	if err != nil {
+		return Folder{}, err
+	}
+	f, err := scanFolder(row)
+	if err == sql.ErrNoRows {
+		return f, ErrFolderNotFound
+	}
+	return f, err
+}
+
+// CreateFolder adds a folder to an account
+func CreateFolder(userUID string, f Folder) (Folder, error) {
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	row, err := postgres.FindOne(`
+		WITH f AS (
+			INSERT INTO folders (user_uid, name, description, start_date, end_date) VALUES ($1, $2, NULLIF($3, ''), $4::date, $5::date)
+			ON CONFLICT (user_uid, lower(name)) DO NOTHING
+			RETURNING *
+		)
+		SELECT f.id, f.name, COALESCE(f.description, ''), to_char(f.start_date, 'YYYY-MM-DD'),
+			to_char(f.end_date, 'YYYY-MM-DD'), 0, f.created_at, f.updated_at
+		FROM f
+	`, userUID, f.Name, f.Description, f.StartDate, f.EndDate)
+	if err != nil {
+		return f, err
+	}
+	created, err := scanFolder(row)
+	if err == sql.ErrNoRows {
+		return f, ErrFolderExists
+	}
+	return created, err
+}
+
+// UpdateFolder renames a folder or changes its description and dates
+func UpdateFolder(userUID string, f Folder) (Folder, error) {
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	res, err := postgres.UpdateOne(`
+		UPDATE folders SET name = $1, description = NULLIF($2, ''), start_date = $3::date, end_date = $4::date, updated_at = $5
+		WHERE id::text = $6 AND user_uid = $7
+			AND NOT EXISTS (
+				SELECT 1 FROM folders o
+				WHERE o.user_uid = $7 AND lower(o.name) = lower($1) AND o.id::text <> $6
+			)
+	`, f.Name, f.Description, f.StartDate, f.EndDate, time.Now().UTC(), f.ID, userUID)
+	if err != nil {
+		return f, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return f, err
+	} else if n == 0 {
+		// Either the folder is missing or another one has the same name
+		if _, err := GetFolder(userUID, f.ID); err != nil {
+			return f, err
+		}
+		return f, ErrFolderExists
+	}
+	return GetFolder(userUID, f.ID)
+}
+
+// DeleteFolder removes a folder from the account. Its links are kept outside any folder.
+func DeleteFolder(userUID, id string) error {
+	// The cached details of its links name the folder
+	rows, err := postgres.FindMany("SELECT short_link FROM links WHERE folder_id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return err
+	}
+	var shortLinks []string
+	for rows.Next() {
+		var sl string
+		if err := rows.Scan(&sl); err != nil {
+			rows.Close()
+			return err
+		}
+		shortLinks = append(shortLinks, sl)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	res, err := postgres.DeleteOne("DELETE FROM folders WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFolderNotFound
+	}
+	for _, sl := range shortLinks {
+		rdb.RC.Del("links:" + sl)
+	}
+	return nil
+}
+
+// LinkFolder resolves the folder a link is assigned to. An empty ID assigns no folder.
+func LinkFolder(userUID string, id *string) (*string, error) {
+	if id == nil || strings.TrimSpace(*id) == "" {
+		return nil, nil
+	}
+	var folderID string
+	row, err := postgres.FindOne("SELECT id FROM folders WHERE id::text = $1 AND user_uid = $2", strings.TrimSpace(*id), userUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&folderID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFolderNotFound
+		}
+		return nil, err
+	}
+	return &folderID, nil
+}
+
+// FetchFolderClicks returns the clicks on the links of each folder of the scope, most clicked first
+func FetchFolderClicks(s StatsScope) ([]FolderClicks, error) {
+	var args queryArgs
+	userArg := args.add(s.UserUID)
+	rollupWhere := s.rollupFilter(&args, sourceDaily)
+	rawWhere := s.rawFilter(&args, sourceDaily)
+	query := fmt.Sprintf(`
+		SELECT f.id, f.name,
+			(SELECT COUNT(*) FROM links l WHERE l.folder_id = f.id AND l.deleted = FALSE),
+			COALESCE(SUM(t.clicks), 0)::bigint
+		FROM folders f
+		LEFT JOIN links l ON l.folder_id = f.id
+		LEFT JOIN (
+			SELECT short_link, clicks
+			FROM analytics_rollup_daily
+			WHERE %s
+			UNION ALL
+			SELECT a.short_link, COUNT(*)
+			FROM analytics a
+			WHERE %s
+			GROUP BY a.short_link
+		) t ON t.short_link = l.short_link
+		WHERE f.user_uid = %s
+		GROUP BY f.id, f.name
+		ORDER BY 4 DESC, lower(f.name)
+	`, rollupWhere, rawWhere, userArg)
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []FolderClicks{}
+	for rows.Next() {
+		var f FolderClicks
+		if err := rows.Scan(&f.FolderID, &f.Name, &f.Links, &f.TotalClicks); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Link batch states
+const (
+	BatchPending = "pending"
+	BatchRunning = "running"
+	BatchDone    = "done"
+	BatchFailed  = "failed"
+	BatchExpired = "expired"
+)
+
+// Link batch sources
+const (
+	BatchSourceJSON   = "json"
+	BatchSourceCSV    = "csv"
+	BatchSourceImport = "import"
+)
+
+const (
+	// MaxBatchRows is the most links a single batch can create
+	MaxBatchRows = 5000
+	// How long result files can be downloaded
+	batchFileTTL = 24 * time.Hour
+	// Running batches older than this were interrupted by a restart
+	batchStaleAfter = time.Hour
+	// Largest request body accepted for a batch
+	maxBatchBody = 8 << 20
+)
+
+// ErrLinkBatchNotFound is returned when a batch or its result file is not available
+var ErrLinkBatchNotFound = errors.New("link batch not found")
+
+// BatchLinkRow is an input row of a batch, with the fields of a created link. ExpiryDate is
+// RFC 3339 or YYYY-MM-DD and is validated when the row runs, so a bad row never fails the batch.
+// Title, CreatedAt and Clicks are only read from import files.
+type BatchLinkRow struct {
+	OriginalURL   string   `json:"original_url"`
+	CustomBackoff string   `json:"custom_backoff,omitempty"`
+	ExpiryDate    string   `json:"expiry_date,omitempty"`
+	Password      *string  `json:"password,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Title         string   `json:"title,omitempty"`
+	CreatedAt     string   `json:"created_at,omitempty"`
+	Clicks        string   `json:"clicks,omitempty"`
+}
+
+// BatchRowResult is the outcome of an input row, written to the result file
+type BatchRowResult struct {
+	Row           int
+	OriginalURL   string
+	CustomBackoff string
+	ShortLink     string
+	ShortURL      string
+	Flagged       bool
+	FlagReason    string
+	Conflict      bool // the alias was taken
+	Error         string
+}
+
+// LinkBatch is a background job creating links in bulk. FailedRows includes ConflictRows,
+// the rows whose alias was already taken.
+type LinkBatch struct {
+	ID            string     `json:"id"`
+	UserUID       string     `json:"-"`
+	Source        string     `json:"source"`
+	Status        string     `json:"status"`
+	TotalRows     int        `json:"total_rows"`
+	ProcessedRows int        `json:"processed_rows"`
+	CreatedRows   int        `json:"created_rows"`
+	FailedRows    int        `json:"failed_rows"`
+	ConflictRows  int        `json:"conflict_rows"`
+	Provider      *string    `json:"provider,omitempty"`
+	Error         *string    `json:"error,omitempty"`
+	DownloadToken *string    `json:"download_token,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+const linkBatchColumns = `id, user_uid, source, status, total_rows, processed_rows, created_rows, failed_rows,
+	conflict_rows, provider, error, download_token, created_at, completed_at, expires_at`
+
+func scanLinkBatch(row interface{ Scan(...any) error }, extra ...any) (LinkBatch, error) {
+	var b LinkBatch
+	dest := []any{&b.ID, &b.UserUID, &b.Source, &b.Status, &b.TotalRows, &b.ProcessedRows, &b.CreatedRows, &b.FailedRows,
+		&b.ConflictRows, &b.Provider, &b.Error, &b.DownloadToken, &b.CreatedAt, &b.CompletedAt, &b.ExpiresAt}
+	err := row.Scan(append(dest, extra...)...)
+	return b, err
+}
+
+// ParseBatchCSV reads batch rows from a CSV file with a header row. original_url is required;
+// custom_backoff, expiry_date, password and tags (comma separated) are optional.
+func ParseBatchCSV(r io.Reader) ([]BatchLinkRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("the CSV header must include original_url")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []BatchLinkRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if len(rows) == MaxBatchRows {
+			return nil, fmt.Errorf("a batch can create at most %d links", MaxBatchRows)
+		}
+		row := BatchLinkRow{
+			OriginalURL:   field(record, "original_url"),
+			CustomBackoff: field(record, "custom_backoff"),
+			ExpiryDate:    field(record, "expiry_date"),
+		}
+		if password := field(record, "password"); password != "" {
+			row.Password = &password
+		}
+		for _, tag := range strings.Split(field(record, "tags"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				row.Tags = append(row.Tags, tag)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ReadLinkBatchRequest reads the rows of a batch from a JSON array, a CSV body or a CSV file
+// uploaded as the multipart field "file", and returns them with their source
+func ReadLinkBatchRequest(w http.ResponseWriter, r *http.Request) (string, []BatchLinkRow, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBody)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "multipart/form-data" || mediaType == "text/csv" {
+		file, err := csvUpload(r)
+		if err != nil {
+			return "", nil, err
+		}
+		defer file.Close()
+		rows, err := ParseBatchCSV(file)
+		return BatchSourceCSV, rows, err
+	}
+	var rows []BatchLinkRow
+	if err := json.NewDecoder(r.Body).Decode(&rows); err != nil {
+		return "", nil, errors.New("the request body must be a JSON array of links")
+	}
+	return BatchSourceJSON, rows, nil
+}
+
+// csvUpload returns the CSV file uploaded as the multipart field "file", or else the request body
+func csvUpload(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, errors.New("a CSV file is required in the file field")
+	}
+	return file, nil
+}
+
+// CreateLinkBatch queues a batch for the cron worker
+func CreateLinkBatch(userUID, source string, rows []BatchLinkRow) (LinkBatch, error) {
+	return createLinkBatch(userUID, source, "", rows)
+}
+
+func createLinkBatch(userUID, source, provider string, rows []BatchLinkRow) (LinkBatch, error) {
+	if len(rows) == 0 {
+		return LinkBatch{}, errors.New("a batch needs at least one link")
+	}
+	if len(rows) > MaxBatchRows {
+		return LinkBatch{}, fmt.Errorf("a batch can create at most %d links", MaxBatchRows)
+	}
+	input, err := json.Marshal(rows)
+	if err != nil {
+		return LinkBatch{}, err
+	}
+	row, err := postgres.FindOne(`
+		INSERT INTO link_batches (user_uid, source, provider, input, total_rows)
+		VALUES ($1, $2, NULLIF($3, ''), $4::jsonb, $5)
+		RETURNING `+linkBatchColumns,
+		userUID, source, provider, string(input), len(rows),
+	)
+	if err != nil {
+		return LinkBatch{}, err
+	}
+	return scanLinkBatch(row)
+}
+
+// GetLinkBatch returns a batch of the user
+func GetLinkBatch(userUID, id string) (LinkBatch, error) {
+	row, err := postgres.FindOne("SELECT "+linkBatchColumns+" FROM link_batches WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return LinkBatch{}, err
+	}
+	b, err := scanLinkBatch(row)
+	if err == sql.ErrNoRows {
+		return b, ErrLinkBatchNotFound
+	}
+	return b, err
+}
+
+// LinkBatchDownload returns the result file of a finished batch by its download token
+func LinkBatchDownload(userUID, token string) (LinkBatch, string, error) {
+	row, err := postgres.FindOne(`
+		SELECT `+linkBatchColumns+`, file_path FROM link_batches
+		WHERE download_token = $1 AND user_uid = $2 AND status = $3 AND expires_at > $4
+	`, token, userUID, BatchDone, time.Now().UTC())
+	if err != nil {
+		return LinkBatch{}, "", err
+	}
+	var path string
+	b, err := scanLinkBatch(row, &path)
+	if err == sql.ErrNoRows {
+		return b, "", ErrLinkBatchNotFound
+	}
+	return b, path, err
+}
+
+// ClaimLinkBatch marks the oldest queued batch as running and returns it with its rows,
+// or nil when none is queued. Batches interrupted by a restart are failed first rather than
+// run again, as their links were partly created.
+func ClaimLinkBatch() (*LinkBatch, []BatchLinkRow, error) {
+	now := time.Now().UTC()
+	if _, err := postgres.UpdateOne(`
+		UPDATE link_batches SET status = $1, completed_at = $2, input = '[]'::jsonb,
+			error = 'interrupted after ' || processed_rows || ' of ' || total_rows || ' rows'
+		WHERE status = $3 AND started_at < $4
+	`, BatchFailed, now, BatchRunning, now.Add(-batchStaleAfter)); err != nil {
+		return nil, nil, err
+	}
+
+	row, err := postgres.FindOne(`
+		UPDATE link_batches SET status = $1, started_at = $2
+		WHERE id = (
+			SELECT id FROM link_batches WHERE status = $3
+			ORDER BY created_at LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+linkBatchColumns+`, input`,
+		BatchRunning, now, BatchPending,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var input []byte
+	b, err := scanLinkBatch(row, &input)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []BatchLinkRow
+	if err := json.Unmarshal(input, &rows); err != nil {
+		return nil, nil, err
+	}
+	return &b, rows, nil
+}
+
+// UpdateLinkBatchProgress records how many rows of a running batch are done
+func UpdateLinkBatchProgress(b LinkBatch) error {
+	_, err := postgres.UpdateOne(`
+		UPDATE link_batches SET processed_rows = $1, created_rows = $2, failed_rows = $3, conflict_rows = $4
+		WHERE id = $5
+	`, b.ProcessedRows, b.CreatedRows, b.FailedRows, b.ConflictRows, b.ID)
+	return err
+}
+
+// CompleteLinkBatch marks a batch as done with its result file and drops its input
+func CompleteLinkBatch(b LinkBatch, path string) error {
+	token, err := GenerateAPIKey(24)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = postgres.UpdateOne(`
+		UPDATE link_batches
+		SET status = $1, processed_rows = $2, created_rows = $3, failed_rows = $4, conflict_rows = $5,
+			file_path = $6, download_token = $7, completed_at = $8, expires_at = $9, input = '[]'::jsonb
+		WHERE id = $10
+	`, BatchDone, b.ProcessedRows, b.CreatedRows, b.FailedRows, b.ConflictRows, path, token, now, now.Add(batchFileTTL), b.ID)
+	return err
+}
+
+// FailLinkBatch marks a batch as failed
+func FailLinkBatch(b LinkBatch, cause error) error {
+	_, err := postgres.UpdateOne(`
+		UPDATE link_batches SET status = $1, error = $2, completed_at = $3, input = '[]'::jsonb,
+			processed_rows = $4, created_rows = $5, failed_rows = $6, conflict_rows = $7
+		WHERE id = $8
+	`, BatchFailed, cause.Error(), time.Now().UTC(), b.ProcessedRows, b.CreatedRows, b.FailedRows, b.ConflictRows, b.ID)
+	return err
+}
+
+// LinkBatchFile returns where the result file of a batch is written
+func LinkBatchFile(b LinkBatch) string {
+	return filepath.Join(exportDir(), "batch-"+b.ID+".csv")
+}
+
+// ExpireLinkBatchFiles deletes the result files of batches past their download window
+func ExpireLinkBatchFiles() error {
+	rows, err := postgres.FindMany(`
+		SELECT id, file_path FROM link_batches
+		WHERE status = $1 AND expires_at <= $2
+	`, BatchDone, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	type expired struct {
+		id   string
+		path sql.NullString
+	}
+	var files []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.path); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, e)
+	}
+	rows.Close()
+
+	for _, e := range files {
+		if e.path.Valid {
+			if err := os.Remove(e.path.String); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove link batch file %s: %v", e.path.String, err)
+				continue
+			}
+		}
+		if _, err := postgres.UpdateOne(
+			"UPDATE link_batches SET status = $1, file_path = NULL, download_token = NULL WHERE id = $2",
+			BatchExpired, e.id,
+		); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
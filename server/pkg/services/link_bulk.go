@@ -0,0 +1,412 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+// Bulk link actions
+const (
+	BulkDelete        = "delete"
+	BulkRestore       = "restore"
+	BulkExtendExpiry  = "extend_expiry"
+	BulkAddTags       = "add_tags"
+	BulkRemoveTags    = "remove_tags"
+	BulkSetPassword   = "set_password"
+	BulkClearPassword = "clear_password"
+	BulkMoveToFolder  = "move_to_folder"
+)
+
+// Outcomes of a link in a bulk action
+const (
+	BulkUpdated  = "updated"
+	BulkSkipped  = "skipped"
+	BulkNotFound = "not_found"
+)
+
+// MaxBulkLinks is the most links a single bulk action can change
+const MaxBulkLinks = 10000
+
+// ErrTooManyBulkLinks is returned when a bulk action selects more than MaxBulkLinks links
+var ErrTooManyBulkLinks = fmt.Errorf("a bulk action can change at most %d links", MaxBulkLinks)
+
+// BulkLinkFilter selects links like the search endpoint, optionally narrowed to links having every
+// tag or to the links of a folder
+type BulkLinkFilter struct {
+	Query    string   `json:"query"`
+	Tags     []string `json:"tags"`
+	FolderID string   `json:"folder_id"`
+}
+
+// BulkLinkAction applies one action to the links in IDs (short links) or matching Filter.
+// ExpiryDate or ExtendDays is used by extend_expiry, Tags by add_tags and remove_tags,
+// Password by set_password and FolderID by move_to_folder, where no folder takes links out of theirs.
+type BulkLinkAction struct {
+	Action     string          `json:"action"`
+	IDs        []string        `json:"ids"`
+	Filter     *BulkLinkFilter `json:"filter"`
+	ExpiryDate *time.Time      `json:"expiry_date"`
+	ExtendDays int             `json:"extend_days"`
+	Tags       []string        `json:"tags"`
+	Password   string          `json:"password"`
+	FolderID   *string         `json:"folder_id"`
+}
+
+// BulkLinkResult is the outcome of a bulk action for a single link
+type BulkLinkResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// BulkLinkReport summarises a bulk action with the outcome of every selected link
+type BulkLinkReport struct {
+	Action   string           `json:"action"`
+	Matched  int              `json:"matched"`
+	Updated  int              `json:"updated"`
+	Skipped  int              `json:"skipped"`
+	NotFound int              `json:"not_found"`
+	Results  []BulkLinkResult `json:"results"`
+}
+
+// bulkLink is a link selected by a bulk action
+type bulkLink struct {
+	uid         string
+	shortLink   string
+	originalURL string
+	expiryDate  time.Time
+	deleted     bool
+	hasPassword bool
+	tags        []string
+	folderID    *string
+}
+
+// Validate checks the action, its selection and its parameters
+func (a *BulkLinkAction) Validate() error {
+	if (len(a.IDs) == 0) == (a.Filter == nil) {
+		return errors.New("either ids or filter is required")
+	}
+	if len(a.IDs) > MaxBulkLinks {
+		return ErrTooManyBulkLinks
+	}
+	a.Tags = cleanTags(a.Tags)
+	if a.Filter != nil {
+		a.Filter.Query = strings.TrimSpace(a.Filter.Query)
+		a.Filter.Tags = cleanTags(a.Filter.Tags)
+		a.Filter.FolderID = strings.TrimSpace(a.Filter.FolderID)
+	}
+
+	switch a.Action {
+	case BulkDelete, BulkRestore, BulkClearPassword, BulkMoveToFolder:
+	case BulkExtendExpiry:
+		if (a.ExpiryDate == nil) == (a.ExtendDays == 0) {
+			return errors.New("either expiry_date or extend_days is required")
+		}
+		if a.ExtendDays < 0 || a.ExtendDays > 3650 {
+			return errors.New("extend_days must be between 1 and 3650")
+		}
+		if a.ExpiryDate != nil && !a.ExpiryDate.After(time.Now()) {
+			return errors.New("expiry_date must be in the future")
+		}
+	case BulkAddTags, BulkRemoveTags:
+		if len(a.Tags) == 0 {
+			return errors.New("tags are required")
+		}
+	case BulkSetPassword:
+		if a.Password == "" || len(a.Password) > 255 {
+			return errors.New("password is required and must be at most 255 characters")
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", a.Action)
+	}
+	return nil
+}
+
+// cleanTags trims tags and drops empty and repeated ones
+func cleanTags(tags []string) []string {
+	seen := map[string]bool{}
+	cleaned := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			cleaned = append(cleaned, tag)
+		}
+	}
+	return cleaned
+}
+
+// skipReason explains why an action leaves a link unchanged, or is empty when it applies
+func (a BulkLinkAction) skipReason(l bulkLink) string {
+	switch {
+	case a.Action == BulkRestore && !l.deleted:
+		return "link is not deleted"
+	case a.Action != BulkRestore && l.deleted:
+		return "link is deleted"
+	case a.Action == BulkClearPassword && !l.hasPassword:
+		return "link has no password"
+	case a.Action == BulkMoveToFolder && sameFolder(a.FolderID, l.folderID):
+		return "link is already in the folder"
+	}
+	return ""
+}
+
+// sameFolder reports whether two optional folder IDs name the same folder
+func sameFolder(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// setClause returns the SET clause of the action, adding its parameters to args
+func (a BulkLinkAction) setClause(args *queryArgs) (string, error) {
+	switch a.Action {
+	case BulkDelete:
+		return "deleted = TRUE, deleted_at = " + args.add(time.Now().UTC()), nil
+	case BulkRestore:
+		return "deleted = FALSE, deleted_at = NULL", nil
+	case BulkExtendExpiry:
+		if a.ExpiryDate != nil {
+			return "expiry_date = " + args.add(a.ExpiryDate.UTC()), nil
+		}
+		// Expired links are extended from now rather than from their old expiry
+		return fmt.Sprintf("expiry_date = GREATEST(expiry_date, (NOW() AT TIME ZONE 'UTC')) + make_interval(days => %s::int)", args.add(a.ExtendDays)), nil
+	case BulkAddTags:
+		tags, err := json.Marshal(a.Tags)
+		if err != nil {
+			return "", err
+		}
+		p := args.add(string(tags))
+		return fmt.Sprintf(`tags = COALESCE(tags, '[]'::jsonb) || COALESCE((
+			SELECT jsonb_agg(t) FROM jsonb_array_elements_text(%s::jsonb) t
+			WHERE NOT COALESCE(tags, '[]'::jsonb) ? t
+		), '[]'::jsonb)`, p), nil
+	case BulkRemoveTags:
+		tags, err := json.Marshal(a.Tags)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("tags = COALESCE(tags, '[]'::jsonb) - ARRAY(SELECT jsonb_array_elements_text(%s::jsonb))", args.add(string(tags))), nil
+	case BulkSetPassword:
+		return "password = " + args.add(a.Password), nil
+	case BulkClearPassword:
+		return "password = NULL", nil
+	case BulkMoveToFolder:
+		return "folder_id = " + args.add(a.FolderID), nil
+	}
+	return "", fmt.Errorf("unknown action: %s", a.Action)
+}
+
+// selectBulkLinks locks the links selected by an action, in the order they were given or created
+func selectBulkLinks(tx *sql.Tx, userUID string, a BulkLinkAction) ([]bulkLink, error) {
+	var args queryArgs
+	conds := []string{"user_uid = " + args.add(userUID)}
+	if len(a.IDs) > 0 {
+		ids, err := json.Marshal(a.IDs)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, fmt.Sprintf("short_link IN (SELECT jsonb_array_elements_text(%s::jsonb))", args.add(string(ids))))
+	} else {
+		// A filter only selects links the action can change
+		conds = append(conds, "deleted = "+args.add(a.Action == BulkRestore))
+		if a.Filter.Query != "" {
+			p := args.add("%" + a.Filter.Query + "%")
+			conds = append(conds, fmt.Sprintf("(short_link ILIKE %s OR original_link ILIKE %s)", p, p))
+		}
+		if len(a.Filter.Tags) > 0 {
+			tags, err := json.Marshal(a.Filter.Tags)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, fmt.Sprintf("tags @> %s::jsonb", args.add(string(tags))))
+		}
+		if a.Filter.FolderID != "" {
+			conds = append(conds, "folder_id::text = "+args.add(a.Filter.FolderID))
+		}
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT uid, short_link, original_link, expiry_date, COALESCE(deleted, FALSE), password IS NOT NULL AND password <> '', tags, folder_id
+		FROM links
+		WHERE %s
+		ORDER BY created_at DESC
+		LIMIT %d
+		FOR UPDATE
+	`, strings.Join(conds, " AND "), MaxBulkLinks+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []bulkLink
+	for rows.Next() {
+		var l bulkLink
+		var tagsJSON []byte
+		if err := rows.Scan(&l.uid, &l.shortLink, &l.originalURL, &l.expiryDate, &l.deleted, &l.hasPassword, &tagsJSON, &l.folderID); err != nil {
+			return nil, err
+		}
+		if len(tagsJSON) > 0 {
+			if err := json.Unmarshal(tagsJSON, &l.tags); err != nil {
+				return nil, err
+			}
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(links) > MaxBulkLinks {
+		return nil, ErrTooManyBulkLinks
+	}
+	return links, nil
+}
+
+// ApplyBulkLinkAction applies an action to the selected links of a user in a single transaction,
+// so either every applicable link changes or none does. Afterwards the cache of every changed
+// link is refreshed and a webhook event is queued for it.
+func ApplyBulkLinkAction(userUID string, a BulkLinkAction) (BulkLinkReport, error) {
+	report := BulkLinkReport{Action: a.Action, Results: []BulkLinkResult{}}
+	if err := a.Validate(); err != nil {
+		return report, err
+	}
+	if a.Action == BulkMoveToFolder {
+		folderID, err := LinkFolder(userUID, a.FolderID)
+		if err != nil {
+			return report, err
+		}
+		a.FolderID = folderID
+	}
+
+	tx, err := postgres.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	selected, err := selectBulkLinks(tx, userUID, a)
+	if err != nil {
+		return report, err
+	}
+	byShortLink := make(map[string]bulkLink, len(selected))
+	for _, l := range selected {
+		byShortLink[l.shortLink] = l
+	}
+
+	// Report links in the order they were asked for, or the order the filter found them
+	order := a.IDs
+	if len(order) == 0 {
+		order = make([]string, len(selected))
+		for i, l := range selected {
+			order[i] = l.shortLink
+		}
+	}
+	var uids []string
+	seen := map[string]bool{}
+	for _, id := range order {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		l, ok := byShortLink[id]
+		if !ok {
+			report.NotFound++
+			report.Results = append(report.Results, BulkLinkResult{ID: id, Status: BulkNotFound})
+			continue
+		}
+		report.Matched++
+		if reason := a.skipReason(l); reason != "" {
+			report.Skipped++
+			report.Results = append(report.Results, BulkLinkResult{ID: id, Status: BulkSkipped, Reason: reason})
+			continue
+		}
+		uids = append(uids, l.uid)
+		report.Results = append(report.Results, BulkLinkResult{ID: id, Status: BulkUpdated})
+	}
+	if len(uids) == 0 {
+		return report, nil
+	}
+
+	var args queryArgs
+	set, err := a.setClause(&args)
+	if err != nil {
+		return report, err
+	}
+	uidsJSON, err := json.Marshal(uids)
+	if err != nil {
+		return report, err
+	}
+	rows, err := tx.Query(fmt.Sprintf(`
+		UPDATE links SET %s, updated_at = NOW()
+		WHERE uid IN (SELECT jsonb_array_elements_text(%s::jsonb))
+		RETURNING short_link, original_link, expiry_date, tags
+	`, set, args.add(string(uidsJSON))), args...)
+	if err != nil {
+		return report, err
+	}
+	var changed []bulkLink
+	for rows.Next() {
+		var l bulkLink
+		var tagsJSON []byte
+		if err := rows.Scan(&l.shortLink, &l.originalURL, &l.expiryDate, &tagsJSON); err != nil {
+			rows.Close()
+			return report, err
+		}
+		if len(tagsJSON) > 0 {
+			if err := json.Unmarshal(tagsJSON, &l.tags); err != nil {
+				rows.Close()
+				return report, err
+			}
+		}
+		changed = append(changed, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	report.Updated = len(changed)
+
+	for _, l := range changed {
+		refreshLinkCache(l, a.Action == BulkDelete)
+
+		event := EventLinkUpdated
+		if a.Action == BulkDelete {
+			event = EventLinkDeleted
+		}
+		expiry := l.expiryDate.UTC()
+		EmitLinkEvent(userUID, event, LinkEvent{
+			ShortLink:   l.shortLink,
+			OriginalURL: l.originalURL,
+			ExpiryDate:  &expiry,
+			Tags:        l.tags,
+		})
+	}
+	return report, nil
+}
+
+// refreshLinkCache drops the cached details of a link and re-caches its destination until
+// it expires, or drops the destination too when the link was deleted
+func refreshLinkCache(l bulkLink, deleted bool) {
+	rdb.RC.Del("links:" + l.shortLink)
+	if deleted {
+		rdb.RC.Del(l.shortLink)
+		return
+	}
+	ttl := time.Hour * 5
+	if diff := time.Until(l.expiryDate); diff > 0 {
+		ttl = diff
+	}
+	rdb.RC.Set(l.shortLink, l.originalURL, &ttl)
+}
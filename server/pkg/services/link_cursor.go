@@ -0,0 +1,125 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCursor is returned for a cursor that can't be decoded or was made for another sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// linkCursor is the position of a page boundary in a link listing: the sort key and uid of the
+// link at the edge of the page and whether the next request pages back before it or on past it.
+// The key is kept as text and is nil when the link had none. The sort and search are kept so a
+// cursor can't be applied to a listing ordered differently.
+type linkCursor struct {
+	UID    string  `json:"u"`
+	Key    *string `json:"k,omitempty"`
+	Before bool    `json:"b,omitempty"`
+	Sort   string  `json:"s"`
+	Asc    bool    `json:"a,omitempty"`
+	Query  string  `json:"q,omitempty"`
+}
+
+// LinkKey is where a fetched link stands in its listing: its uid and the sort key selected by
+// LinkQuery.SortKey
+type LinkKey struct {
+	UID  string
+	Sort sql.NullString
+}
+
+func (f LinkFilter) encodeCursor(key LinkKey, before bool) string {
+	c := linkCursor{UID: key.UID, Before: before, Sort: f.Sort, Asc: f.Ascending}
+	if key.Sort.Valid {
+		c.Key = &key.Sort.String
+	}
+	if f.Sort == SortRelevance {
+		c.Query = f.Query
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// setCursor decodes an opaque cursor of a previous page of the same listing
+func (f *LinkFilter) setCursor(cursor string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	var c linkCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.UID == "" {
+		return ErrInvalidCursor
+	}
+	if c.Sort != f.Sort || c.Asc != f.Ascending {
+		return ErrInvalidCursor
+	}
+	if f.Sort == SortRelevance && c.Query != f.Query {
+		return ErrInvalidCursor
+	}
+	f.cursor = &c
+	return nil
+}
+
+// HasCursor reports whether the listing is paged by cursor rather than by page number
+func (f LinkFilter) HasCursor() bool {
+	return f.cursor != nil
+}
+
+// cursorCondition returns the keyset condition selecting the links past the cursor in the
+// listing's order, or before it when paging back. Links are compared with the key the cursor
+// was made with, so a page starts where the previous one ended even when the cursor's link has
+// since moved or been deleted. Null keys sort last.
+func (f LinkFilter) cursorCondition(args *queryArgs, key string) string {
+	uid := args.add(f.cursor.UID)
+
+	after, before := ">", "<"
+	if !f.Ascending {
+		after, before = "<", ">"
+	}
+	if f.cursor.Key == nil {
+		if f.cursor.Before {
+			return fmt.Sprintf("(%s IS NOT NULL OR uid %s %s)", key, before, uid)
+		}
+		return fmt.Sprintf("(%s IS NULL AND uid %s %s)", key, after, uid)
+	}
+
+	boundary := fmt.Sprintf("%s::%s", args.add(*f.cursor.Key), f.sortType())
+	if f.cursor.Before {
+		return fmt.Sprintf("(%[1]s %[4]s %[2]s OR (%[1]s = %[2]s AND uid %[4]s %[3]s))", key, boundary, uid, before)
+	}
+	return fmt.Sprintf("(%[1]s %[4]s %[2]s OR (%[1]s = %[2]s AND uid %[4]s %[3]s) OR %[1]s IS NULL)", key, boundary, uid, after)
+}
+
+// PageLinks trims a page of links fetched with one extra row (LIMIT limit+1) and returns it in
+// listing order with the cursors of the pages after and before it. keys holds where each row
+// stands in the listing. hasPrevious tells whether a page fetched by page number has pages
+// before it.
+func PageLinks[T any](f LinkFilter, rows []T, keys []LinkKey, limit int, hasPrevious bool) (page []T, next, prev string) {
+	more := len(rows) > limit
+	if more {
+		rows, keys = rows[:limit], keys[:limit]
+	}
+	if f.cursor != nil && f.cursor.Before {
+		// Fetched in reverse order, nearest to the cursor first
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		hasPrevious, more = more, true
+	} else if f.cursor != nil {
+		hasPrevious = true
+	}
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+	if more {
+		next = f.encodeCursor(keys[len(keys)-1], false)
+	}
+	if hasPrevious {
+		prev = f.encodeCursor(keys[0], true)
+	}
+	return rows, next, prev
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func linkKey(uid string, sort ...string) LinkKey {
+	key := LinkKey{UID: uid}
+	if len(sort) > 0 {
+		key.Sort = sql.NullString{String: sort[0], Valid: true}
+	}
+	return key
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// decodeCursor reads back a cursor made by the filter's listing
+func decodeCursor(t *testing.T, f LinkFilter, cursor string) linkCursor {
+	t.Helper()
+	if err := f.setCursor(cursor); err != nil {
+		t.Fatalf("setCursor(%q): %v", cursor, err)
+	}
+	return *f.cursor
+}
+
+func TestLinkCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter LinkFilter
+		key    LinkKey
+		before bool
+		want   linkCursor
+	}{
+		{
+			name:   "created, newest first",
+			filter: LinkFilter{Sort: SortCreated},
+			key:    linkKey("a1", "2024-05-01T10:00:00Z"),
+			want:   linkCursor{UID: "a1", Key: strPtr("2024-05-01T10:00:00Z"), Sort: SortCreated},
+		},
+		{
+			name:   "null sort key",
+			filter: LinkFilter{Sort: SortExpiry, Ascending: true},
+			key:    linkKey("b2"),
+			before: true,
+			want:   linkCursor{UID: "b2", Before: true, Sort: SortExpiry, Asc: true},
+		},
+		{
+			name:   "relevance keeps the search",
+			filter: LinkFilter{Sort: SortRelevance, Query: "launch"},
+			key:    linkKey("c3", "0.5"),
+			want:   linkCursor{UID: "c3", Key: strPtr("0.5"), Sort: SortRelevance, Query: "launch"},
+		},
+		{
+			name:   "other sorts ignore the search",
+			filter: LinkFilter{Sort: SortAlphabetical, Ascending: true, Query: "launch"},
+			key:    linkKey("d4", "abc"),
+			want:   linkCursor{UID: "d4", Key: strPtr("abc"), Sort: SortAlphabetical, Asc: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeCursor(t, tt.filter, tt.filter.encodeCursor(tt.key, tt.before))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cursor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCursorRejects(t *testing.T) {
+	created := LinkFilter{Sort: SortCreated}
+	tests := []struct {
+		name   string
+		filter LinkFilter
+		cursor string
+	}{
+		{"not base64", created, "not a cursor!"},
+		{"not JSON", created, "bm90IGpzb24"},
+		{"no uid", created, created.encodeCursor(LinkKey{}, false)},
+		{"other sort", LinkFilter{Sort: SortClicks}, created.encodeCursor(linkKey("a1"), false)},
+		{"other order", LinkFilter{Sort: SortCreated, Ascending: true}, created.encodeCursor(linkKey("a1"), false)},
+		{
+			"other search",
+			LinkFilter{Sort: SortRelevance, Query: "spring"},
+			LinkFilter{Sort: SortRelevance, Query: "launch"}.encodeCursor(linkKey("a1", "0.5"), false),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.filter
+			if err := f.setCursor(tt.cursor); err != ErrInvalidCursor {
+				t.Errorf("setCursor = %v, want ErrInvalidCursor", err)
+			}
+			if f.HasCursor() {
+				t.Error("a rejected cursor was kept")
+			}
+		})
+	}
+}
+
+func TestCursorCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   LinkFilter
+		cursor   linkCursor
+		key      string
+		want     string
+		wantArgs queryArgs
+	}{
+		{
+			name:     "after, descending",
+			filter:   LinkFilter{Sort: SortCreated},
+			cursor:   linkCursor{UID: "a1", Key: strPtr("2024-05-01T10:00:00Z")},
+			key:      "created_at",
+			want:     "(created_at < $3::timestamp OR (created_at = $3::timestamp AND uid < $2) OR created_at IS NULL)",
+			wantArgs: queryArgs{"user", "a1", "2024-05-01T10:00:00Z"},
+		},
+		{
+			name:     "before, descending",
+			filter:   LinkFilter{Sort: SortCreated},
+			cursor:   linkCursor{UID: "a1", Key: strPtr("2024-05-01T10:00:00Z"), Before: true},
+			key:      "created_at",
+			want:     "(created_at > $3::timestamp OR (created_at = $3::timestamp AND uid > $2))",
+			wantArgs: queryArgs{"user", "a1", "2024-05-01T10:00:00Z"},
+		},
+		{
+			name:     "after, ascending",
+			filter:   LinkFilter{Sort: SortAlphabetical, Ascending: true},
+			cursor:   linkCursor{UID: "a1", Key: strPtr("abc")},
+			key:      "lower(short_link)",
+			want:     "(lower(short_link) > $3::text OR (lower(short_link) = $3::text AND uid > $2) OR lower(short_link) IS NULL)",
+			wantArgs: queryArgs{"user", "a1", "abc"},
+		},
+		{
+			name:     "after a null key",
+			filter:   LinkFilter{Sort: SortExpiry, Ascending: true},
+			cursor:   linkCursor{UID: "a1"},
+			key:      "expiry_date",
+			want:     "(expiry_date IS NULL AND uid > $2)",
+			wantArgs: queryArgs{"user", "a1"},
+		},
+		{
+			name:     "before a null key",
+			filter:   LinkFilter{Sort: SortExpiry, Ascending: true},
+			cursor:   linkCursor{UID: "a1", Before: true},
+			key:      "expiry_date",
+			want:     "(expiry_date IS NOT NULL OR uid < $2)",
+			wantArgs: queryArgs{"user", "a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.filter
+			f.cursor = &tt.cursor
+			args := queryArgs{"user"}
+			if got := f.cursorCondition(&args, tt.key); got != tt.want {
+				t.Errorf("condition = %s, want %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter LinkFilter
+		cursor *linkCursor
+		want   string
+	}{
+		{"descending", LinkFilter{Sort: SortCreated}, nil, "k DESC NULLS LAST, uid DESC"},
+		{"ascending", LinkFilter{Sort: SortExpiry, Ascending: true}, nil, "k ASC NULLS LAST, uid ASC"},
+		{"descending, paging back", LinkFilter{Sort: SortCreated}, &linkCursor{UID: "a1", Before: true}, "k ASC NULLS FIRST, uid ASC"},
+		{"ascending, paging back", LinkFilter{Sort: SortExpiry, Ascending: true}, &linkCursor{UID: "a1", Before: true}, "k DESC NULLS FIRST, uid DESC"},
+		{"ascending, paging on", LinkFilter{Sort: SortExpiry, Ascending: true}, &linkCursor{UID: "a1"}, "k ASC NULLS LAST, uid ASC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.filter
+			f.cursor = tt.cursor
+			if got := f.orderBy("k"); got != tt.want {
+				t.Errorf("orderBy = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageLinks(t *testing.T) {
+	keys := map[string]LinkKey{
+		"a": linkKey("a", "2024-05-03"),
+		"b": linkKey("b", "2024-05-02"),
+		"c": linkKey("c"),
+		"d": linkKey("d"),
+	}
+	tests := []struct {
+		name        string
+		cursor      *linkCursor
+		rows        []string
+		limit       int
+		hasPrevious bool
+		want        []string
+		wantNext    *linkCursor
+		wantPrev    *linkCursor
+	}{
+		{
+			name:     "first page with more",
+			rows:     []string{"a", "b", "c"},
+			limit:    2,
+			want:     []string{"a", "b"},
+			wantNext: &linkCursor{UID: "b", Key: strPtr("2024-05-02"), Sort: SortCreated},
+		},
+		{
+			name:        "numbered page, last one",
+			rows:        []string{"c", "d"},
+			limit:       2,
+			hasPrevious: true,
+			want:        []string{"c", "d"},
+			wantPrev:    &linkCursor{UID: "c", Before: true, Sort: SortCreated},
+		},
+		{
+			name:     "paging on into null keys",
+			cursor:   &linkCursor{UID: "b", Key: strPtr("2024-05-02"), Sort: SortCreated},
+			rows:     []string{"c", "d", "e"},
+			limit:    2,
+			want:     []string{"c", "d"},
+			wantNext: &linkCursor{UID: "d", Sort: SortCreated},
+			wantPrev: &linkCursor{UID: "c", Before: true, Sort: SortCreated},
+		},
+		{
+			name:     "paging back with more before",
+			cursor:   &linkCursor{UID: "d", Before: true, Sort: SortCreated},
+			rows:     []string{"c", "b", "a"},
+			limit:    2,
+			want:     []string{"b", "c"},
+			wantNext: &linkCursor{UID: "c", Sort: SortCreated},
+			wantPrev: &linkCursor{UID: "b", Key: strPtr("2024-05-02"), Before: true, Sort: SortCreated},
+		},
+		{
+			name:     "paging back to the start",
+			cursor:   &linkCursor{UID: "c", Before: true, Sort: SortCreated},
+			rows:     []string{"b", "a"},
+			limit:    2,
+			want:     []string{"a", "b"},
+			wantNext: &linkCursor{UID: "b", Key: strPtr("2024-05-02"), Sort: SortCreated},
+		},
+		{
+			name:   "past the end",
+			cursor: &linkCursor{UID: "d", Sort: SortCreated},
+			rows:   []string{},
+			limit:  2,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := LinkFilter{Sort: SortCreated, cursor: tt.cursor}
+			rowKeys := make([]LinkKey, len(tt.rows))
+			for i, uid := range tt.rows {
+				rowKeys[i] = keys[uid]
+				if rowKeys[i].UID == "" {
+					rowKeys[i] = linkKey(uid)
+				}
+			}
+			page, next, prev := PageLinks(f, tt.rows, rowKeys, tt.limit, tt.hasPrevious)
+			if !reflect.DeepEqual(page, tt.want) {
+				t.Errorf("page = %v, want %v", page, tt.want)
+			}
+			checkCursor(t, "next", next, tt.wantNext)
+			checkCursor(t, "prev", prev, tt.wantPrev)
+		})
+	}
+}
+
+func checkCursor(t *testing.T, name, cursor string, want *linkCursor) {
+	t.Helper()
+	if want == nil {
+		if cursor != "" {
+			t.Errorf("%s = %q, want none", name, cursor)
+		}
+		return
+	}
+	if cursor == "" {
+		t.Errorf("%s is missing, want %+v", name, *want)
+		return
+	}
+	if got := decodeCursor(t, LinkFilter{Sort: SortCreated}, cursor); !reflect.DeepEqual(got, *want) {
+		t.Errorf("%s = %+v, want %+v", name, got, *want)
+	}
+}
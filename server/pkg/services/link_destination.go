@@ -0,0 +1,24 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+)
+
+// ErrInvalidDestination is returned for a link destination that isn't an absolute http or https URL
+var ErrInvalidDestination = errors.New("URL must be an absolute http or https URL")
+
+// IsWebURL reports whether a destination is an absolute http or https URL. Anything else, such
+// as a javascript: URL, must never reach a visitor's browser from the short link's origin.
+func IsWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ValidateDestination checks that a link destination is an absolute http or https URL
+func ValidateDestination(raw string) error {
+	if !IsWebURL(raw) {
+		return ErrInvalidDestination
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How a link filter matches several tags
+const (
+	TagMatchAll = "all"
+	TagMatchAny = "any"
+)
+
+// Link statuses a listing can be filtered by. A link matches every status asked for.
+const (
+	LinkActive            = "active"
+	LinkExpired           = "expired"
+	LinkPasswordProtected = "password_protected"
+	LinkFlagged           = "flagged"
+	LinkCustomAlias       = "custom_alias"
+)
+
+// Orders of a link listing. Each has a default direction, reversed by order=asc or order=desc.
+const (
+	SortCreated      = "created"
+	SortClicks       = "clicks"
+	SortLastClicked  = "last_clicked"
+	SortExpiry       = "expiry"
+	SortAlphabetical = "alphabetical"
+	SortRelevance    = "relevance"
+)
+
+// Conditions of each link status on the links table
+var linkStatusConditions = map[string]string{
+	LinkActive:            "(expiry_date IS NULL OR expiry_date > (NOW() AT TIME ZONE 'UTC'))",
+	LinkExpired:           "expiry_date <= (NOW() AT TIME ZONE 'UTC')",
+	LinkPasswordProtected: "(password IS NOT NULL AND password <> '')",
+	LinkFlagged:           "is_flagged = TRUE",
+	LinkCustomAlias:       "is_custom_backoff = TRUE",
+}
+
+// Sort expressions on the links table with the SQL type of their values and their default
+// direction. Clicks add the daily rollups to the raw clicks after the rollup watermark, as the
+// analytics queries do; the last click falls back to the hourly rollups once raw clicks have
+// been purged.
+var linkSorts = map[string]struct {
+	expr    string
+	sqlType string
+	desc    bool
+}{
+	SortCreated: {"created_at", "timestamp", true},
+	SortClicks: {fmt.Sprintf(`((SELECT COALESCE(SUM(r.clicks), 0) FROM analytics_rollup_daily r WHERE r.short_link = links.short_link)
+		+ (SELECT COUNT(*) FROM analytics a WHERE a.short_link = links.short_link
+			AND a.click_timestamp >= COALESCE((SELECT watermark FROM analytics_rollup_state WHERE name = '%s'), '-infinity'::timestamp)))`, rollupStateName), "numeric", true},
+	SortLastClicked: {`COALESCE(
+		(SELECT MAX(a.click_timestamp) FROM analytics a WHERE a.short_link = links.short_link),
+		(SELECT MAX(h.bucket_start) FROM analytics_rollup_hourly h WHERE h.short_link = links.short_link))`, "timestamp", true},
+	SortExpiry:       {"expiry_date", "timestamp", false},
+	SortAlphabetical: {"lower(short_link)", "text", false},
+	// Ranked against the search of each filter, see LinkFilter.sortKey
+	SortRelevance: {"", "real", true},
+}
+
+// linkHostExpr is the lower case host of a link's destination without a leading www.
+const linkHostExpr = `regexp_replace(lower(substring(original_link from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^:/?#]+)')), '^www\.', '')`
+
+// LinkFilter narrows and orders a listing of a user's live links. Query matches the words of
+// the title, description, notes, tags and destination host, or part of the alias or URL. Tags are matched all together or any of them according to TagMode and Domain
+// matches the destination host and its subdomains. Date bounds are inclusive.
+type LinkFilter struct {
+	Query       string
+	FolderID    string
+	Tags        []string
+	TagMode     string
+	Statuses    []string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	ExpiresFrom *time.Time
+	ExpiresTo   *time.Time
+	Domain      string
+	Sort        string
+	Ascending   bool
+
+	cursor *linkCursor
+}
+
+// ParseLinkFilter reads a filter from query parameters: q, folder, tags and status (repeated or
+// comma separated), tag_mode, created_from, created_to, expires_from, expires_to (RFC 3339
+// times or dates in UTC), domain, sort, order and the cursor of a previous page. Searches are
+// sorted by relevance unless another sort is asked for.
+func ParseLinkFilter(q url.Values) (LinkFilter, error) {
+	f := LinkFilter{
+		Query:    strings.TrimSpace(q.Get("q")),
+		FolderID: strings.TrimSpace(q.Get("folder")),
+		TagMode:  q.Get("tag_mode"),
+		Tags:     cleanTags(listParam(q, "tags")),
+		Statuses: cleanTags(listParam(q, "status")),
+		Sort:     q.Get("sort"),
+	}
+
+	switch f.TagMode {
+	case "":
+		f.TagMode = TagMatchAll
+	case TagMatchAll, TagMatchAny:
+	default:
+		return f, fmt.Errorf("tag_mode must be %s or %s", TagMatchAll, TagMatchAny)
+	}
+	for _, status := range f.Statuses {
+		if _, ok := linkStatusConditions[status]; !ok {
+			return f, fmt.Errorf("unknown status: %s", status)
+		}
+	}
+
+	var err error
+	if f.CreatedFrom, err = filterTime(q, "created_from", false); err != nil {
+		return f, err
+	}
+	if f.CreatedTo, err = filterTime(q, "created_to", true); err != nil {
+		return f, err
+	}
+	if f.ExpiresFrom, err = filterTime(q, "expires_from", false); err != nil {
+		return f, err
+	}
+	if f.ExpiresTo, err = filterTime(q, "expires_to", true); err != nil {
+		return f, err
+	}
+
+	if domain := strings.ToLower(strings.TrimSpace(q.Get("domain"))); domain != "" {
+		if u, err := url.Parse(domain); err == nil && u.Host != "" {
+			domain = u.Hostname()
+		}
+		domain, _, _ = strings.Cut(domain, "/")
+		f.Domain = strings.TrimPrefix(domain, "www.")
+	}
+
+	if f.Sort == "" {
+		f.Sort = SortCreated
+		if f.Query != "" {
+			f.Sort = SortRelevance
+		}
+	}
+	if f.Sort == SortRelevance && f.Query == "" {
+		return f, fmt.Errorf("sort %s needs a search query", SortRelevance)
+	}
+	sort, ok := linkSorts[f.Sort]
+	if !ok {
+		return f, fmt.Errorf("unknown sort: %s", f.Sort)
+	}
+	switch q.Get("order") {
+	case "":
+		f.Ascending = !sort.desc
+	case "asc":
+		f.Ascending = true
+	case "desc":
+		f.Ascending = false
+	default:
+		return f, fmt.Errorf("order must be asc or desc")
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		if err := f.setCursor(cursor); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// listParam returns the values of a parameter given repeated or comma separated
+func listParam(q url.Values, name string) []string {
+	var values []string
+	for _, v := range q[name] {
+		values = append(values, strings.Split(v, ",")...)
+	}
+	return values
+}
+
+// filterTime parses an optional RFC 3339 time or UTC date. A date that ends a range includes
+// the whole day.
+func filterTime(q url.Values, name string, end bool) (*time.Time, error) {
+	value := strings.TrimSpace(q.Get(name))
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		t = t.UTC()
+		return &t, nil
+	}
+	d, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time or YYYY-MM-DD", name)
+	}
+	if end {
+		d = d.Add(24*time.Hour - time.Microsecond)
+	}
+	return &d, nil
+}
+
+// LinkQuery is the SQL of a filtered link listing, with its arguments numbered from $1. SortKey
+// selects the sort key of a link as text, for the cursors of LinkKey.
+type LinkQuery struct {
+	Where   string
+	OrderBy string
+	SortKey string
+	Args    []any
+}
+
+// Build returns the clauses selecting the live links of a user matching the filter and
+// ordering them by its sort
+func (f LinkFilter) Build(userUID string) LinkQuery {
+	var args queryArgs
+	userArg := args.add(userUID)
+	conds := []string{"user_uid = " + userArg, "deleted = false"}
+	var search string
+	if f.Query != "" {
+		search = linkSearchQuery(args.add(searchTerms(f.Query)))
+		p := args.add("%" + f.Query + "%")
+		conds = append(conds, fmt.Sprintf("(search_vector @@ %s OR short_link ILIKE %s OR original_link ILIKE %s)", search, p, p))
+	}
+	if f.FolderID != "" {
+		conds = append(conds, "folder_id::text = "+args.add(f.FolderID))
+	}
+	if len(f.Tags) > 0 {
+		// Both operators are answered by the GIN index on tags
+		tags, _ := json.Marshal(f.Tags)
+		if f.TagMode == TagMatchAny {
+			conds = append(conds, fmt.Sprintf("tags ?| ARRAY(SELECT jsonb_array_elements_text(%s::jsonb))", args.add(string(tags))))
+		} else {
+			conds = append(conds, fmt.Sprintf("tags @> %s::jsonb", args.add(string(tags))))
+		}
+	}
+	for _, status := range f.Statuses {
+		conds = append(conds, linkStatusConditions[status])
+	}
+	if f.CreatedFrom != nil {
+		conds = append(conds, "created_at >= "+args.add(*f.CreatedFrom))
+	}
+	if f.CreatedTo != nil {
+		conds = append(conds, "created_at <= "+args.add(*f.CreatedTo))
+	}
+	if f.ExpiresFrom != nil {
+		conds = append(conds, "expiry_date >= "+args.add(*f.ExpiresFrom))
+	}
+	if f.ExpiresTo != nil {
+		conds = append(conds, "expiry_date <= "+args.add(*f.ExpiresTo))
+	}
+	if f.Domain != "" {
+		p := args.add(f.Domain)
+		// Subdomains are matched on the suffix as is, so % and _ in the domain are plain characters
+		conds = append(conds, fmt.Sprintf("(%[1]s = %[2]s OR right(%[1]s, length(%[2]s) + 1) = '.' || %[2]s)", linkHostExpr, p))
+	}
+
+	key := f.sortKey(search)
+	if f.cursor != nil {
+		conds = append(conds, f.cursorCondition(&args, key))
+	}
+	return LinkQuery{Where: strings.Join(conds, " AND "), OrderBy: f.orderBy(key), SortKey: "(" + key + ")::text", Args: args}
+}
+
+// sortKey returns the expression the filter sorts on. search is the tsquery of the filter's
+// search, which relevance ranks links against.
+func (f LinkFilter) sortKey(search string) string {
+	if f.Sort == SortRelevance {
+		return "ts_rank_cd(search_vector, " + search + ")"
+	}
+	sort, ok := linkSorts[f.Sort]
+	if !ok {
+		sort = linkSorts[SortCreated]
+	}
+	return sort.expr
+}
+
+// sortType returns the SQL type of the filter's sort key
+func (f LinkFilter) sortType() string {
+	sort, ok := linkSorts[f.Sort]
+	if !ok {
+		sort = linkSorts[SortCreated]
+	}
+	return sort.sqlType
+}
+
+// orderBy returns the ORDER BY clause of the filter's sort. Ties are broken by uid in the same
+// direction, so each link has a single place cursors can page from. A page before a cursor is
+// read in reverse, nearest to the cursor first.
+func (f LinkFilter) orderBy(key string) string {
+	asc := f.Ascending
+	if f.cursor != nil && f.cursor.Before {
+		asc = !asc
+	}
+	dir, nulls := "DESC", "LAST"
+	if asc {
+		dir = "ASC"
+	}
+	if asc != f.Ascending {
+		nulls = "FIRST"
+	}
+	return fmt.Sprintf("%s %s NULLS %s, uid %s", key, dir, nulls, dir)
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Shorteners whose export files can be imported. Their columns are recognised by name, so
+// ImportOther covers any CSV with a long URL column.
+const (
+	ImportBitly     = "bitly"
+	ImportRebrandly = "rebrandly"
+	ImportTinyURL   = "tinyurl"
+	ImportShortIO   = "shortio"
+	ImportYOURLS    = "yourls"
+	ImportOther     = "other"
+)
+
+// ImportProviders are the shorteners an import can name
+var ImportProviders = []string{ImportBitly, ImportRebrandly, ImportTinyURL, ImportShortIO, ImportYOURLS, ImportOther}
+
+const maxImportTitle = 500
+
+// Column names used by shortener exports for each field, most specific first. Names are
+// compared lower case with spaces and punctuation as underscores.
+var importColumns = map[string][]string{
+	"original_url": {"long_url", "original_url", "destination_url", "destination", "target_url", "long_link", "original_link", "originalurl", "longurl", "url"},
+	"alias":        {"custom_back_half", "back_half", "backhalf", "custom_backoff", "alias", "slug", "keyword", "short_code", "path", "bitlink", "short_url", "short_link", "shorturl", "tiny_url", "link"},
+	"title":        {"title", "name"},
+	"tags":         {"tags", "tag", "labels"},
+	"created_at":   {"created_at", "createdat", "created", "created_date", "creation_date", "date_created", "date", "timestamp"},
+	"clicks":       {"clicks", "total_clicks", "click_count", "clicks_count", "visits", "hits"},
+	"expiry_date":  {"expiry_date", "expires_at", "expiration_date"},
+}
+
+// Layouts accepted for the created date of imported links
+var importDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.DateOnly,
+	"01/02/2006 15:04",
+	"01/02/2006",
+	"Jan 2, 2006",
+}
+
+// ImportBaseline is what a link brought from the shortener it was imported from
+type ImportBaseline struct {
+	Provider          string     `json:"provider"`
+	Title             string     `json:"title,omitempty"`
+	Clicks            int64      `json:"clicks"`
+	OriginalCreatedAt *time.Time `json:"original_created_at,omitempty"`
+	ImportedAt        time.Time  `json:"imported_at"`
+}
+
+// ValidImportProvider reports whether provider is a known shortener
+func ValidImportProvider(provider string) bool {
+	for _, p := range ImportProviders {
+		if provider == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadLinkImportRequest reads the rows of an import from a CSV file uploaded as the multipart
+// field "file" or sent as the body
+func ReadLinkImportRequest(w http.ResponseWriter, r *http.Request) ([]BatchLinkRow, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBody)
+	file, err := csvUpload(r)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseImportCSV(file)
+}
+
+// ParseImportCSV reads the links of a shortener export file, recognising its columns by name.
+// Only the long URL is required.
+func ParseImportCSV(r io.Reader) ([]BatchLinkRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	names := make(map[string]int, len(header))
+	for i, name := range header {
+		names[importColumnName(name)] = i
+	}
+	columns := map[string]int{}
+	for field, candidates := range importColumns {
+		for _, name := range candidates {
+			if i, ok := names[name]; ok {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("the CSV header must include a long URL column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []BatchLinkRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if len(rows) == MaxBatchRows {
+			return nil, fmt.Errorf("an import can create at most %d links", MaxBatchRows)
+		}
+		row := BatchLinkRow{
+			OriginalURL:   field(record, "original_url"),
+			CustomBackoff: importAlias(field(record, "alias")),
+			ExpiryDate:    field(record, "expiry_date"),
+			Title:         field(record, "title"),
+			CreatedAt:     field(record, "created_at"),
+			Clicks:        field(record, "clicks"),
+		}
+		for _, tag := range strings.FieldsFunc(field(record, "tags"), func(r rune) bool {
+			return r == ',' || r == ';' || r == '|'
+		}) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				row.Tags = append(row.Tags, tag)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importColumnName normalises a header name, e.g. "Long URL" to long_url
+func importColumnName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	var b strings.Builder
+	underscore := false
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// importAlias returns the back half of a short link, which exports give either alone or as a
+// full short URL such as bit.ly/abc
+func importAlias(value string) string {
+	if i := strings.IndexAny(value, "?#"); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.TrimRight(value, "/")
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	return value
+}
+
+// ParseImportDetails validates the title, created date and click total of an imported row
+func ParseImportDetails(row BatchLinkRow) (ImportBaseline, error) {
+	details := ImportBaseline{Title: row.Title}
+	if title := []rune(details.Title); len(title) > maxImportTitle {
+		details.Title = string(title[:maxImportTitle])
+	}
+	if row.CreatedAt != "" {
+		created, err := parseImportDate(row.CreatedAt)
+		if err != nil {
+			return details, err
+		}
+		details.OriginalCreatedAt = &created
+	}
+	if clicks := strings.NewReplacer(",", "", " ", "").Replace(row.Clicks); clicks != "" {
+		n, err := strconv.ParseInt(clicks, 10, 64)
+		if err != nil || n < 0 {
+			return details, errors.New("clicks must be a whole number")
+		}
+		details.Clicks = n
+	}
+	return details, nil
+}
+
+func parseImportDate(value string) (time.Time, error) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	// Some exports give Unix timestamps
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil && secs > 0 {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised created date: %s", value)
+}
+
+// CreateLinkImport queues an import of links exported from another shortener
+func CreateLinkImport(userUID, provider string, rows []BatchLinkRow) (LinkBatch, error) {
+	return createLinkBatch(userUID, BatchSourceImport, provider, rows)
+}
+
+// RecordLinkImport keeps the title and click total of an imported link and backdates it to
+// when it was created at the previous shortener. The title also becomes the link's own unless
+// one was already captured from the destination.
+func RecordLinkImport(b LinkBatch, linkUID string, details ImportBaseline) error {
+	provider := ImportOther
+	if b.Provider != nil {
+		provider = *b.Provider
+	}
+	_, err := postgres.UpdateOne(`
+		WITH l AS (
+			UPDATE links SET created_at = COALESCE($5, created_at), title = COALESCE(title, NULLIF($6, ''))
+			WHERE uid = $1 RETURNING uid
+		)
+		INSERT INTO link_imports (link_uid, user_uid, batch_id, provider, title, clicks, original_created_at)
+		SELECT uid, $2, $3, $4, NULLIF($6, ''), $7, $5 FROM l
+	`, linkUID, b.UserUID, b.ID, provider, details.OriginalCreatedAt, details.Title, details.Clicks)
+	return err
+}
+
+// FetchImportBaseline returns what a link brought from the shortener it was imported from,
+// or nil when it was created here
+func FetchImportBaseline(userUID, shortLink string) (*ImportBaseline, error) {
+	row, err := postgres.FindOne(`
+		SELECT i.provider, COALESCE(i.title, ''), i.clicks, i.original_created_at, i.imported_at
+		FROM link_imports i
+		JOIN links l ON l.uid = i.link_uid
+		WHERE l.short_link = $1 AND l.user_uid = $2
+	`, shortLink, userUID)
+	if err != nil {
+		return nil, err
+	}
+	var b ImportBaseline
+	err = row.Scan(&b.Provider, &b.Title, &b.Clicks, &b.OriginalCreatedAt, &b.ImportedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
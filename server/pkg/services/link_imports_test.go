@@ -0,0 +1,168 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []BatchLinkRow
+		wantErr string
+	}{
+		{
+			name: "bitly export",
+			csv: "Long URL,Bitlink,Title,Tags,Created,Clicks\n" +
+				"https://example.com/a,bit.ly/abc?x=1,Spring sale,\"promo, spring\",2021-03-04T05:06:07Z,\"1,204\"\n",
+			want: []BatchLinkRow{{
+				OriginalURL:   "https://example.com/a",
+				CustomBackoff: "abc",
+				Title:         "Spring sale",
+				Tags:          []string{"promo", "spring"},
+				CreatedAt:     "2021-03-04T05:06:07Z",
+				Clicks:        "1,204",
+			}},
+		},
+		{
+			name: "byte order mark before the header",
+			csv:  "\ufefflong_url,slug\nhttps://example.com/b,launch\n",
+			want: []BatchLinkRow{{OriginalURL: "https://example.com/b", CustomBackoff: "launch"}},
+		},
+		{
+			name: "most specific column wins",
+			csv:  "url,destination,link\nhttps://example.com/ignored,https://example.com/c,https://tinyurl.com/xyz/\n",
+			want: []BatchLinkRow{{OriginalURL: "https://example.com/c", CustomBackoff: "xyz"}},
+		},
+		{
+			name: "short rows and other tag separators",
+			csv:  "original_url,tags,alias\nhttps://example.com/d,a;b| c\nhttps://example.com/e\n",
+			want: []BatchLinkRow{
+				{OriginalURL: "https://example.com/d", Tags: []string{"a", "b", "c"}},
+				{OriginalURL: "https://example.com/e"},
+			},
+		},
+		{
+			name: "header only",
+			csv:  "long_url\n",
+		},
+		{
+			name:    "empty file",
+			csv:     "",
+			wantErr: "the CSV file is empty",
+		},
+		{
+			name:    "no long URL column",
+			csv:     "title,clicks\nHome,3\n",
+			wantErr: "the CSV header must include a long URL column",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := ParseImportCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportCSVRowLimit(t *testing.T) {
+	csv := "long_url\n" + strings.Repeat("https://example.com\n", MaxBatchRows+1)
+	if _, err := ParseImportCSV(strings.NewReader(csv)); err == nil {
+		t.Fatalf("expected an error for more than %d rows", MaxBatchRows)
+	}
+}
+
+func TestImportColumnName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Long URL", "long_url"},
+		{"\ufeffLong URL", "long_url"},
+		{"  Created At  ", "created_at"},
+		{"Custom Back-Half", "custom_back_half"},
+		{"Clicks (total)", "clicks_total"},
+		{"__id__", "id"},
+		{"originalURL", "originalurl"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := importColumnName(tt.name); got != tt.want {
+			t.Errorf("importColumnName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportAlias(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"abc", "abc"},
+		{"bit.ly/abc", "abc"},
+		{"bit.ly/abc?x", "abc"},
+		{"https://bit.ly/abc?x=1#top", "abc"},
+		{"https://short.io/abc/", "abc"},
+		{"https://rebrand.ly/campaign/abc", "abc"},
+		{"abc#section", "abc"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := importAlias(tt.value); got != tt.want {
+			t.Errorf("importAlias(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseImportDate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2021-03-04T05:06:07Z", want: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{value: "2021-03-04T05:06:07+02:00", want: time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC)},
+		{value: "2021-03-04T05:06:07", want: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{value: "2021-03-04 05:06:07", want: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{value: "2021-03-04 05:06", want: time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC)},
+		{value: "2021-03-04", want: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{value: "03/04/2021 05:06", want: time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC)},
+		{value: "03/04/2021", want: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{value: "Mar 4, 2021", want: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{value: "1614834367", want: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{value: "0", wantErr: true},
+		{value: "-1614834367", wantErr: true},
+		{value: "yesterday", wantErr: true},
+		{value: "2021-13-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseImportDate(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseImportDate(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseImportDate(%q) returned %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("parseImportDate(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/config/response"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+	"github.com/gin-gonic/gin"
+)
+
+// Where a link revision came from
+const (
+	RevisionOriginal  = "original" // the link as it was before its first recorded change
+	RevisionDashboard = "dashboard"
+	RevisionAPI       = "api"
+	RevisionRollback  = "rollback"
+)
+
+// Fields compared between revisions
+const (
+	RevisionDestination = "destination"
+	RevisionAlias       = "alias"
+	RevisionExpiry      = "expiry_date"
+	RevisionTags        = "tags"
+	RevisionPassword    = "password"
+)
+
+var (
+	// ErrLinkNotFound is returned when a link doesn't exist, is deleted or belongs to another user
+	ErrLinkNotFound = errors.New("link not found")
+	// ErrRevisionNotFound is returned when a revision doesn't exist or belongs to another link
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrRevisionAliasTaken is returned when rolling back to an alias another link now uses
+	ErrRevisionAliasTaken = errors.New("the alias of the revision is used by another link")
+)
+
+// LinkState is the part of a link recorded in its history. Only whether a password is set is
+// stored; Password is compared to detect password changes.
+type LinkState struct {
+	OriginalURL     string
+	ShortLink       string
+	ExpiryDate      time.Time
+	Tags            []string
+	Password        *string
+	IsFlagged       bool
+	IsCustomBackoff bool
+}
+
+// RevisionChange is the value of a field before and after a revision
+type RevisionChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// LinkRevision is the state of a link after a change, with what changed and who changed it
+type LinkRevision struct {
+	ID          int64                     `json:"id"`
+	OriginalURL string                    `json:"original_url"`
+	ShortLink   string                    `json:"short_link"`
+	ExpiryDate  time.Time                 `json:"expiry_date"`
+	Tags        []string                  `json:"tags"`
+	PasswordSet bool                      `json:"password_set"`
+	Changes     map[string]RevisionChange `json:"changes"`
+	ChangedBy   string                    `json:"changed_by,omitempty"`
+	Source      string                    `json:"source"`
+	RollbackOf  *int64                    `json:"rollback_of,omitempty"`
+	CreatedAt   time.Time                 `json:"created_at"`
+}
+
+func (s LinkState) passwordSet() bool {
+	return s.Password != nil && *s.Password != ""
+}
+
+func (s LinkState) tags() []string {
+	if s.Tags == nil {
+		return []string{}
+	}
+	return s.Tags
+}
+
+// revisionChanges returns the recorded fields that differ between two states of a link
+func revisionChanges(before, after LinkState) map[string]RevisionChange {
+	changes := map[string]RevisionChange{}
+	if before.OriginalURL != after.OriginalURL {
+		changes[RevisionDestination] = RevisionChange{before.OriginalURL, after.OriginalURL}
+	}
+	if before.ShortLink != after.ShortLink {
+		changes[RevisionAlias] = RevisionChange{before.ShortLink, after.ShortLink}
+	}
+	if !before.ExpiryDate.Truncate(time.Second).Equal(after.ExpiryDate.Truncate(time.Second)) {
+		changes[RevisionExpiry] = RevisionChange{before.ExpiryDate.UTC(), after.ExpiryDate.UTC()}
+	}
+	beforeTags, _ := json.Marshal(before.tags())
+	afterTags, _ := json.Marshal(after.tags())
+	if string(beforeTags) != string(afterTags) {
+		changes[RevisionTags] = RevisionChange{before.tags(), after.tags()}
+	}
+	// Passwords are never recorded, only that one was set, removed or replaced
+	if before.passwordSet() != after.passwordSet() || (after.passwordSet() && *before.Password != *after.Password) {
+		changes[RevisionPassword] = RevisionChange{before.passwordSet(), after.passwordSet()}
+	}
+	return changes
+}
+
+// RecordLinkRevision records a change to a link in the transaction updating it, so a change is
+// never saved without its history. The first change also records the link as it was before, so
+// every change can be rolled back. Changes to unrecorded fields are ignored.
+func RecordLinkRevision(tx *sql.Tx, userUID, linkUID string, before, after LinkState, changedBy, source string) error {
+	changes := revisionChanges(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	return insertLinkRevision(tx, userUID, linkUID, after, changes, changedBy, source, nil, before)
+}
+
+// insertLinkRevision records the state of a link after a change, preceded by its original state
+// when the link has no history yet
+func insertLinkRevision(tx *sql.Tx, userUID, linkUID string, state LinkState, changes map[string]RevisionChange,
+	changedBy, source string, rollbackOf *int64, original LinkState) error {
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM link_revisions WHERE link_uid = $1)", linkUID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		if err := execLinkRevision(tx, userUID, linkUID, original, map[string]RevisionChange{}, "", RevisionOriginal, nil); err != nil {
+			return err
+		}
+	}
+	return execLinkRevision(tx, userUID, linkUID, state, changes, changedBy, source, rollbackOf)
+}
+
+func execLinkRevision(tx *sql.Tx, userUID, linkUID string, state LinkState, changes map[string]RevisionChange,
+	changedBy, source string, rollbackOf *int64) error {
+	tags, err := json.Marshal(state.tags())
+	if err != nil {
+		return err
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO link_revisions (
+			link_uid, user_uid, original_link, short_link, expiry_date, tags, password_set,
+			is_flagged, is_custom_backoff, changes, changed_by, source, rollback_of, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8, $9, $10::jsonb, NULLIF($11, ''), $12, $13, $14)
+	`, linkUID, userUID, state.OriginalURL, state.ShortLink, state.ExpiryDate.UTC(), string(tags), state.passwordSet(),
+		state.IsFlagged, state.IsCustomBackoff, string(changesJSON), changedBy, source, rollbackOf, time.Now().UTC())
+	return err
+}
+
+const linkRevisionColumns = `id, original_link, short_link, expiry_date, tags, password_set, changes,
+	COALESCE(changed_by, ''), source, rollback_of, created_at`
+
+func scanLinkRevision(row interface{ Scan(...any) error }, extra ...any) (LinkRevision, error) {
+	var r LinkRevision
+	var tags, changes []byte
+	dest := []any{&r.ID, &r.OriginalURL, &r.ShortLink, &r.ExpiryDate, &tags, &r.PasswordSet, &changes,
+		&r.ChangedBy, &r.Source, &r.RollbackOf, &r.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return r, err
+	}
+	r.ExpiryDate = r.ExpiryDate.UTC()
+	r.Tags = []string{}
+	if len(tags) > 0 {
+		if err := json.Unmarshal(tags, &r.Tags); err != nil {
+			return r, err
+		}
+	}
+	r.Changes = map[string]RevisionChange{}
+	if len(changes) > 0 {
+		if err := json.Unmarshal(changes, &r.Changes); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// ListLinkRevisions returns the history of a link by its current short link, newest first
+func ListLinkRevisions(userUID, shortLink string) ([]LinkRevision, error) {
+	row, err := postgres.FindOne("SELECT uid FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false", shortLink, userUID)
+	if err != nil {
+		return nil, err
+	}
+	var linkUID string
+	if err := row.Scan(&linkUID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLinkNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := postgres.FindMany("SELECT "+linkRevisionColumns+" FROM link_revisions WHERE link_uid = $1 ORDER BY id DESC", linkUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []LinkRevision{}
+	for rows.Next() {
+		r, err := scanLinkRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// RollbackLink restores the destination, alias, expiry and tags a link had after a revision and
+// records the rollback as a new revision. The password is left as it is, since revisions don't
+// keep it.
+func RollbackLink(userUID, shortLink, revisionID, changedBy, source string) (LinkRevision, error) {
+	id, err := strconv.ParseInt(revisionID, 10, 64)
+	if err != nil {
+		return LinkRevision{}, ErrRevisionNotFound
+	}
+
+	tx, err := postgres.Begin()
+	if err != nil {
+		return LinkRevision{}, err
+	}
+	defer tx.Rollback()
+
+	var linkUID string
+	var current LinkState
+	var tagsJSON []byte
+	err = tx.QueryRow(`
+		SELECT uid, original_link, short_link, expiry_date, tags, password, is_flagged, is_custom_backoff
+		FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = false
+		FOR UPDATE
+	`, shortLink, userUID).Scan(&linkUID, &current.OriginalURL, &current.ShortLink, &current.ExpiryDate,
+		&tagsJSON, &current.Password, &current.IsFlagged, &current.IsCustomBackoff)
+	if err == sql.ErrNoRows {
+		return LinkRevision{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return LinkRevision{}, err
+	}
+	if len(tagsJSON) > 0 {
+		if err := json.Unmarshal(tagsJSON, &current.Tags); err != nil {
+			return LinkRevision{}, err
+		}
+	}
+
+	var target LinkState
+	target.Password = current.Password
+	revision, err := scanLinkRevision(tx.QueryRow(`
+		SELECT `+linkRevisionColumns+`, is_flagged, is_custom_backoff FROM link_revisions
+		WHERE id = $1 AND link_uid = $2
+	`, id, linkUID), &target.IsFlagged, &target.IsCustomBackoff)
+	if err == sql.ErrNoRows {
+		return LinkRevision{}, ErrRevisionNotFound
+	}
+	if err != nil {
+		return LinkRevision{}, err
+	}
+	target.OriginalURL = revision.OriginalURL
+	target.ShortLink = revision.ShortLink
+	target.ExpiryDate = revision.ExpiryDate
+	target.Tags = revision.Tags
+
+	changes := revisionChanges(current, target)
+	if len(changes) == 0 {
+		return revision, nil
+	}
+	if target.ShortLink != current.ShortLink {
+		var taken bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM links WHERE short_link = $1 AND uid <> $2)", target.ShortLink, linkUID).Scan(&taken); err != nil {
+			return LinkRevision{}, err
+		}
+		if taken {
+			return LinkRevision{}, ErrRevisionAliasTaken
+		}
+	}
+
+	tags, err := json.Marshal(target.tags())
+	if err != nil {
+		return LinkRevision{}, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE links SET original_link = $1, short_link = $2, expiry_date = $3, tags = $4::jsonb,
+			is_flagged = $5, is_custom_backoff = $6, updated_at = NOW(),
+			title = CASE WHEN original_link = $1 THEN title END, description = CASE WHEN original_link = $1 THEN description END
+		WHERE uid = $7
+	`, target.OriginalURL, target.ShortLink, target.ExpiryDate, string(tags), target.IsFlagged, target.IsCustomBackoff, linkUID); err != nil {
+		return LinkRevision{}, err
+	}
+	if err := insertLinkRevision(tx, userUID, linkUID, target, changes, changedBy, source, &revision.ID, current); err != nil {
+		return LinkRevision{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return LinkRevision{}, err
+	}
+
+	// Refresh the cache under the restored alias
+	rdb.RC.Del("links:" + current.ShortLink)
+	if target.ShortLink != current.ShortLink {
+		rdb.RC.Del(current.ShortLink)
+	}
+	refreshLinkCache(bulkLink{shortLink: target.ShortLink, originalURL: target.OriginalURL, expiryDate: target.ExpiryDate}, false)
+
+	event := LinkEvent{
+		ShortLink:   target.ShortLink,
+		OriginalURL: target.OriginalURL,
+		ExpiryDate:  &target.ExpiryDate,
+		Tags:        target.tags(),
+	}
+	if target.ShortLink != current.ShortLink {
+		event.PreviousShortLink = current.ShortLink
+	}
+	EmitLinkEvent(userUID, EventLinkUpdated, event)
+	return revision, nil
+}
+
+// LinkHistoryHandler lists the revisions of a link, newest first
+func LinkHistoryHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revisions, err := ListLinkRevisions(c.GetString("uid"), c.Param("id"))
+		if err != nil {
+			if err == ErrLinkNotFound {
+				response.SendNotFoundError(c, "Link not found")
+				return
+			}
+			response.SendServerError(c, err)
+			return
+		}
+		response.SendJSON(c, gin.H{"revisions": revisions})
+	}
+}
+
+// RollbackLinkHandler restores the destination, alias, expiry and tags of a link to an earlier
+// revision. The password is left as it is. actor names who rolled back and restored runs once
+// the link has been restored.
+func RollbackLinkHandler(actor func(c *gin.Context) string, restored func(revision LinkRevision)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revision, err := RollbackLink(c.GetString("uid"), c.Param("id"), c.Param("revision"), actor(c), RevisionRollback)
+		if err != nil {
+			switch err {
+			case ErrLinkNotFound:
+				response.SendNotFoundError(c, "Link not found")
+			case ErrRevisionNotFound:
+				response.SendNotFoundError(c, "Revision not found")
+			case ErrRevisionAliasTaken:
+				response.SendConflictError(c, "The alias of this revision is now used by another link")
+			default:
+				response.SendServerError(c, err)
+			}
+			return
+		}
+		restored(revision)
+		response.SendJSON(c, gin.H{"message": "Link rolled back", "short_link": revision.ShortLink, "revision": revision})
+	}
+}
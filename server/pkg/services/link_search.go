@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+// MaxLinkNotes is the longest note a link can have
+const MaxLinkNotes = 2000
+
+// linkSearchQuery returns the tsquery of the search terms in arg, with words stemmed in English
+// or taken as they are, so tags and hosts match as typed
+func linkSearchQuery(arg string) string {
+	return fmt.Sprintf("(to_tsquery('english', %[1]s) || to_tsquery('simple', %[1]s))", arg)
+}
+
+// escapeHTML escapes a text column for HTML in SQL, so the markup ts_headline adds around
+// matches is the only markup of a snippet
+func escapeHTML(column string) string {
+	return fmt.Sprintf(`replace(replace(replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`, column)
+}
+
+var searchWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// searchTerms turns free text into a tsquery matching every word as a prefix, so a search
+// typed halfway already finds its links. Other characters are dropped.
+func searchTerms(query string) string {
+	words := searchWordPattern.FindAllString(strings.ToLower(query), -1)
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// LinkMatch tells why a link was found by a search. The fields that matched are given as
+// HTML-escaped snippets with the matching words wrapped in <mark> tags.
+type LinkMatch struct {
+	Rank        float64  `json:"rank"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// headline returns a highlighted snippet of a column when it matches the search
+func headline(column, search string) string {
+	return fmt.Sprintf(`COALESCE(CASE WHEN to_tsvector('english', COALESCE(%[1]s, '')) @@ %[2]s
+		THEN ts_headline('english', %[3]s, %[2]s, 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2') END, '')`, column, search, escapeHTML(column))
+}
+
+// FetchLinkMatches returns how each of the given links of a user matched a search, by link uid
+func FetchLinkMatches(userUID, query string, uids []string) (map[string]LinkMatch, error) {
+	matches := map[string]LinkMatch{}
+	terms := searchTerms(query)
+	if terms == "" || len(uids) == 0 {
+		return matches, nil
+	}
+	ids, err := json.Marshal(uids)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	userArg := args.add(userUID)
+	search := linkSearchQuery(args.add(terms))
+	idsArg := args.add(string(ids))
+	rows, err := postgres.FindMany(fmt.Sprintf(`
+		SELECT uid, ts_rank_cd(search_vector, %[1]s), %[2]s, %[3]s, %[4]s,
+			COALESCE((SELECT jsonb_agg(t) FROM jsonb_array_elements_text(COALESCE(tags, '[]'::jsonb)) t
+				WHERE to_tsvector('simple', t) @@ %[1]s), '[]'::jsonb)
+		FROM links
+		WHERE user_uid = %[5]s AND uid IN (SELECT jsonb_array_elements_text(%[6]s::jsonb))
+	`, search, headline("title", search), headline("description", search), headline("notes", search), userArg, idsArg), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid string
+		var m LinkMatch
+		var tagsJSON []byte
+		if err := rows.Scan(&uid, &m.Rank, &m.Title, &m.Description, &m.Notes, &tagsJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(tagsJSON, &m.Tags); err != nil {
+			return nil, err
+		}
+		matches[uid] = m
+	}
+	return matches, rows.Err()
+}
+
+// CleanLinkNotes trims the notes of a link, leaving nil alone so an update keeps the current
+// notes. Empty notes clear them.
+func CleanLinkNotes(notes *string) (*string, error) {
+	if notes == nil {
+		return nil, nil
+	}
+	n := strings.TrimSpace(*notes)
+	if len(n) > MaxLinkNotes {
+		return nil, fmt.Errorf("notes must be at most %d characters", MaxLinkNotes)
+	}
+	return &n, nil
+}
+
+// SaveLinkMetadata stores the title and description captured from a link's destination, unless
+// the link has moved to another destination since
+func SaveLinkMetadata(shortLink, originalURL, title, description string) error {
+	_, err := postgres.UpdateOne(`
+		UPDATE links SET title = NULLIF($3, ''), description = NULLIF($4, '')
+		WHERE short_link = $1 AND original_link = $2
+	`, shortLink, originalURL, strings.TrimSpace(title), strings.TrimSpace(description))
+	if err != nil {
+		return err
+	}
+	rdb.RC.Del("links:" + shortLink)
+	return nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+const (
+	// Days deleted links stay in the trash, overridable with TRASH_RETENTION_DAYS
+	defaultTrashRetentionDays = 30
+	// Links purged per batch of PurgeExpiredTrash
+	trashPurgeBatch = 100
+)
+
+// ErrLinkNotInTrash is returned when a link to purge doesn't exist or isn't deleted
+var ErrLinkNotInTrash = errors.New("link not in trash")
+
+// Tables keyed by short link that hold a link's history. They have no foreign key to links,
+// so they are cleared before the alias can be reused by a new link.
+var linkHistoryTables = []string{
+	"analytics",
+	"analytics_rollup_hourly",
+	"analytics_rollup_daily",
+	"analytics_rollup_dimensions",
+	"analytics_alerts",
+	"conversions",
+	"report_subscriptions",
+}
+
+// TrashRetentionDays returns how long deleted links are kept before they are purged
+func TrashRetentionDays() int {
+	if v, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil && v >= 1 && v <= MaxRetentionDays {
+		return v
+	}
+	return defaultTrashRetentionDays
+}
+
+// TrashPurgeAt returns when a link deleted at deletedAt is purged
+func TrashPurgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.AddDate(0, 0, TrashRetentionDays())
+}
+
+// PurgeLink permanently deletes a link in the trash with its clicks, conversions and
+// rollups, freeing its alias
+func PurgeLink(userUID, shortLink string) error {
+	tx, err := postgres.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var uid string
+	err = tx.QueryRow(
+		"DELETE FROM links WHERE short_link = $1 AND user_uid = $2 AND deleted = TRUE RETURNING uid",
+		shortLink, userUID,
+	).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return ErrLinkNotInTrash
+	}
+	if err != nil {
+		return err
+	}
+	for _, table := range linkHistoryTables {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE short_link = $1", table), shortLink); err != nil {
+			return fmt.Errorf("failed to purge %s: %v", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	rdb.RC.Del(shortLink)
+	rdb.RC.Del("links:" + shortLink)
+	return nil
+}
+
+// EmptyTrash purges every deleted link of a user and returns how many were purged
+func EmptyTrash(userUID string) (int, error) {
+	purged := 0
+	for {
+		links, err := trashedLinks("user_uid = $1", userUID)
+		if err != nil {
+			return purged, err
+		}
+		if len(links) == 0 {
+			return purged, nil
+		}
+		for _, l := range links {
+			if err := PurgeLink(userUID, l[1]); err == nil {
+				purged++
+			} else if err != ErrLinkNotInTrash {
+				return purged, err
+			}
+		}
+	}
+}
+
+// PurgeExpiredTrash purges the links deleted longer ago than the trash retention. Links deleted
+// before deletion times were recorded count from their last update.
+func PurgeExpiredTrash() error {
+	cutoff := time.Now().UTC().AddDate(0, 0, -TrashRetentionDays())
+	for {
+		links, err := trashedLinks("COALESCE(deleted_at, updated_at) < $1", cutoff)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		for _, l := range links {
+			if err := PurgeLink(l[0], l[1]); err != nil && err != ErrLinkNotInTrash {
+				return err
+			}
+		}
+		log.Printf("Purged %d links from the trash", len(links))
+	}
+}
+
+// trashedLinks returns a batch of deleted links matching cond as user UID and short link pairs
+func trashedLinks(cond string, args ...any) ([][2]string, error) {
+	rows, err := postgres.FindMany(fmt.Sprintf(`
+		SELECT user_uid, short_link FROM links
+		WHERE deleted = TRUE AND %s
+		ORDER BY deleted_at NULLS FIRST
+		LIMIT %d
+	`, cond, trashPurgeBatch), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links [][2]string
+	for rows.Next() {
+		var l [2]string
+		if err := rows.Scan(&l[0], &l[1]); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
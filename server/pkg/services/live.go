@@ -0,0 +1,89 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+// Live click stages. A click is published when it is received and again once the
+// ingestion worker has enriched it with device and geo fields.
+const (
+	LiveClickReceived  = "received"
+	LiveClickProcessed = "processed"
+)
+
+// LiveClick is the payload published for every click. It never carries the visitor's IP or user agent.
+type LiveClick struct {
+	ID             string    `json:"id"`
+	Stage          string    `json:"stage"`
+	ShortLink      string    `json:"short_link"`
+	ClickedAt      time.Time `json:"clicked_at"`
+	IsQR           bool      `json:"is_qr"`
+	ReferrerHost   string    `json:"referrer_host,omitempty"`
+	ReferrerSource string    `json:"referrer_source,omitempty"`
+	Browser        string    `json:"browser,omitempty"`
+	OS             string    `json:"os,omitempty"`
+	DeviceType     string    `json:"device_type,omitempty"`
+	Country        string    `json:"country,omitempty"`
+	CountryCode    string    `json:"country_code,omitempty"`
+	City           string    `json:"city,omitempty"`
+}
+
+// LiveLinkChannel is the pub/sub channel carrying the clicks of a single link
+func LiveLinkChannel(shortLink string) string {
+	return "live:link:" + shortLink
+}
+
+// LiveUserChannel is the pub/sub channel carrying the clicks of all links of an account
+func LiveUserChannel(userUID string) string {
+	return "live:user:" + userUID
+}
+
+// receivedClick is a click announced to live viewers as soon as it is received
+type receivedClick struct {
+	userUID  string
+	referrer string
+	click    LiveClick
+}
+
+// receivedClicks hands received clicks from redirects to PublishReceivedClicks. When it is full
+// the announcement is dropped, the processed stage of the click still follows.
+var receivedClicks = make(chan receivedClick, 1024)
+
+// announceReceivedClick queues a received click for live viewers without waiting
+func announceReceivedClick(userUID, referrer string, click LiveClick) {
+	select {
+	case receivedClicks <- receivedClick{userUID: userUID, referrer: referrer, click: click}:
+	default:
+	}
+}
+
+// PublishReceivedClicks publishes the clicks queued by redirects, with their referrers classified
+func PublishReceivedClicks() {
+	for r := range receivedClicks {
+		r.click.ReferrerHost, r.click.ReferrerSource = ClassifyReferrer(r.referrer)
+		publishLiveClick(r.userUID, r.click)
+	}
+}
+
+// publishLiveClick sends a click to the link's channel and, when the owner is known, the account's channel.
+// Publishing is best effort; live viewers are optional and must never block tracking.
+func publishLiveClick(userUID string, click LiveClick) {
+	payload, err := json.Marshal(click)
+	if err != nil {
+		log.Printf("Failed to encode live click for %s: %v", click.ShortLink, err)
+		return
+	}
+	if err := rdb.RC.Publish(LiveLinkChannel(click.ShortLink), payload); err != nil {
+		log.Printf("Failed to publish live click for %s: %v", click.ShortLink, err)
+		return
+	}
+	if userUID != "" {
+		if err := rdb.RC.Publish(LiveUserChannel(userUID), payload); err != nil {
+			log.Printf("Failed to publish live click for %s: %v", userUID, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/mailer"
+)
+
+// NotificationSettings are the email settings of an account's alerts. An empty AlertEmail sends
+// to the account's email. Alerts reach webhooks through the endpoints subscribed to them.
+type NotificationSettings struct {
+	EmailAlerts bool   `json:"email_alerts"`
+	AlertEmail  string `json:"alert_email"`
+}
+
+// Notification is a message sent to every enabled channel of an account
+type Notification struct {
+	Event   string
+	Subject string
+	Text    string
+	Data    any
+}
+
+// GetNotificationSettings returns the notification settings of a user, email only when none are saved
+func GetNotificationSettings(userUID string) (NotificationSettings, error) {
+	settings := NotificationSettings{EmailAlerts: true}
+
+	row, err := postgres.FindOne(`
+		SELECT COALESCE(email_alerts, TRUE), COALESCE(alert_email, '')
+		FROM notification_settings WHERE user_uid = $1
+	`, userUID)
+	if err != nil {
+		return settings, err
+	}
+	err = row.Scan(&settings.EmailAlerts, &settings.AlertEmail)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	return settings, err
+}
+
+// SaveNotificationSettings stores the settings of a user
+func SaveNotificationSettings(userUID string, s NotificationSettings) (NotificationSettings, error) {
+	row, err := postgres.FindOne(`
+		INSERT INTO notification_settings (user_uid, email_alerts, alert_email, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		ON CONFLICT (user_uid) DO UPDATE SET
+			email_alerts = EXCLUDED.email_alerts,
+			alert_email = EXCLUDED.alert_email,
+			updated_at = EXCLUDED.updated_at
+		RETURNING COALESCE(email_alerts, TRUE), COALESCE(alert_email, '')
+	`, userUID, s.EmailAlerts, s.AlertEmail, time.Now().UTC())
+	if err != nil {
+		return s, err
+	}
+	var saved NotificationSettings
+	err = row.Scan(&saved.EmailAlerts, &saved.AlertEmail)
+	return saved, err
+}
+
+// Notify sends a notification by email and queues it for the webhook endpoints subscribed to its
+// event, which retry and log it like any other event. It returns nil when at least one channel
+// took it, or when there is no channel to send it through.
+func Notify(userUID string, n Notification) error {
+	settings, err := GetNotificationSettings(userUID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	delivered, attempted := false, false
+
+	if settings.EmailAlerts && mailer.Enabled() {
+		attempted = true
+		if err := sendNotificationEmail(userUID, settings.AlertEmail, n); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		} else {
+			delivered = true
+		}
+	}
+	queued, err := enqueueWebhookEvent(userUID, n.Event, n.Data)
+	if err != nil {
+		attempted = true
+		errs = append(errs, fmt.Errorf("webhook: %w", err))
+	} else if queued > 0 {
+		attempted, delivered = true, true
+	}
+
+	if attempted && !delivered {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func sendNotificationEmail(userUID, to string, n Notification) error {
+	if to == "" {
+		row, err := postgres.FindOne("SELECT email FROM users WHERE uid = $1", userUID)
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&to); err != nil {
+			return err
+		}
+	}
+	return mailer.Send(mailer.Message{To: []string{to}, Subject: n.Subject, Text: n.Text})
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Retargeting pixel types fired by the interstitial page
+const (
+	PixelFacebook  = "facebook"
+	PixelGoogle    = "google" // Google Analytics (G-) and Google Ads (AW-) tags
+	PixelLinkedIn  = "linkedin"
+	PixelTwitter   = "twitter"
+	PixelPinterest = "pinterest"
+)
+
+// PixelTypes are the pixel types an account can add
+var PixelTypes = []string{PixelFacebook, PixelGoogle, PixelLinkedIn, PixelTwitter, PixelPinterest}
+
+// InterstitialBudget is the longest the interstitial waits for pixels before forwarding the visitor
+const InterstitialBudget = 1500 * time.Millisecond
+
+const maxPixelName = 100
+
+var trackingIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	// ErrPixelNotFound is returned when a pixel doesn't exist or belongs to another user
+	ErrPixelNotFound = errors.New("pixel not found")
+	// ErrPixelExists is returned when the account already has a pixel of the same type and tracking ID
+	ErrPixelExists = errors.New("pixel already exists")
+)
+
+// Pixel is a retargeting pixel of an account, identified at its vendor by type and tracking ID.
+// Links counts the links it is attached to.
+type Pixel struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	TrackingID string    `json:"tracking_id"`
+	Links      int       `json:"links"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Validate checks the name, type and tracking ID of a pixel
+func (p *Pixel) Validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.TrackingID = strings.TrimSpace(p.TrackingID)
+	if p.Name == "" || len(p.Name) > maxPixelName {
+		return fmt.Errorf("name is required and must be at most %d characters", maxPixelName)
+	}
+	known := false
+	for _, t := range PixelTypes {
+		if p.Type == t {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown pixel type: %s", p.Type)
+	}
+	if !trackingIDPattern.MatchString(p.TrackingID) {
+		return errors.New("tracking_id must be 1 to 64 letters, digits, dashes or underscores")
+	}
+	return nil
+}
+
+const pixelColumns = `p.id, p.name, p.type, p.tracking_id,
+	(SELECT COUNT(*) FROM link_pixels lp WHERE lp.pixel_id = p.id), p.created_at, p.updated_at`
+
+func scanPixel(row interface{ Scan(...any) error }) (Pixel, error) {
+	var p Pixel
+	err := row.Scan(&p.ID, &p.Name, &p.Type, &p.TrackingID, &p.Links, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// ListPixels returns the pixels of a user
+func ListPixels(userUID string) ([]Pixel, error) {
+	rows, err := postgres.FindMany("SELECT "+pixelColumns+" FROM retargeting_pixels p WHERE p.user_uid = $1 ORDER BY p.created_at", userUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pixels := []Pixel{}
+	for rows.Next() {
+		p, err := scanPixel(rows)
+		if err != nil {
+			return nil, err
+		}
+		pixels = append(pixels, p)
+	}
+	return pixels, rows.Err()
+}
+
+// CreatePixel adds a pixel to an account
+func CreatePixel(userUID string, p Pixel) (Pixel, error) {
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	row, err := postgres.FindOne(`
+		WITH p AS (
+			INSERT INTO retargeting_pixels (user_uid, name, type, tracking_id) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_uid, type, tracking_id) DO NOTHING
+			RETURNING *
+		)
+		SELECT p.id, p.name, p.type, p.tracking_id, 0, p.created_at, p.updated_at FROM p
+	`, userUID, p.Name, p.Type, p.TrackingID)
+	if err != nil {
+		return p, err
+	}
+	created, err := scanPixel(row)
+	if err == sql.ErrNoRows {
+		return p, ErrPixelExists
+	}
+	return created, err
+}
+
+// UpdatePixel renames a pixel or changes its type and tracking ID, for every link it is attached to
+func UpdatePixel(userUID string, p Pixel) (Pixel, error) {
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	res, err := postgres.UpdateOne(`
+		UPDATE retargeting_pixels SET name = $1, type = $2, tracking_id = $3, updated_at = $4
+		WHERE id::text = $5 AND user_uid = $6
+			AND NOT EXISTS (
+				SELECT 1 FROM retargeting_pixels o
+				WHERE o.user_uid = $6 AND o.type = $2 AND o.tracking_id = $3 AND o.id::text <> $5
+			)
+	`, p.Name, p.Type, p.TrackingID, time.Now().UTC(), p.ID, userUID)
+	if err != nil {
+		return p, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return p, err
+	} else if n == 0 {
+		// Either the pixel is missing or another one has the same tracking ID
+		if _, err := getPixel(userUID, p.ID); err != nil {
+			return p, err
+		}
+		return p, ErrPixelExists
+	}
+	return getPixel(userUID, p.ID)
+}
+
+func getPixel(userUID, id string) (Pixel, error) {
+	row, err := postgres.FindOne("SELECT "+pixelColumns+" FROM retargeting_pixels p WHERE p.id::text = $1 AND p.user_uid = $2", id, userUID)
+	if err != nil {
+		return Pixel{}, err
+	}
+	p, err := scanPixel(row)
+	if err == sql.ErrNoRows {
+		return p, ErrPixelNotFound
+	}
+	return p, err
+}
+
+// DeletePixel removes a pixel from the account and every link it is attached to
+func DeletePixel(userUID, id string) error {
+	res, err := postgres.DeleteOne("DELETE FROM retargeting_pixels WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPixelNotFound
+	}
+	return nil
+}
+
+// LinkPixels returns the pixels attached to a link
+func LinkPixels(linkUID string) ([]Pixel, error) {
+	rows, err := postgres.FindMany(`
+		SELECT `+pixelColumns+` FROM link_pixels lp
+		JOIN retargeting_pixels p ON p.id = lp.pixel_id
+		WHERE lp.link_uid = $1
+		ORDER BY p.created_at
+	`, linkUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pixels := []Pixel{}
+	for rows.Next() {
+		p, err := scanPixel(rows)
+		if err != nil {
+			return nil, err
+		}
+		pixels = append(pixels, p)
+	}
+	return pixels, rows.Err()
+}
+
+// SetLinkPixels replaces the pixels attached to a link. Every pixel must belong to the link's owner.
+func SetLinkPixels(userUID, linkUID string, pixelIDs []string) error {
+	tx, err := postgres.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM link_pixels WHERE link_uid = $1", linkUID); err != nil {
+		return err
+	}
+	for _, id := range pixelIDs {
+		res, err := tx.Exec(`
+			INSERT INTO link_pixels (link_uid, pixel_id)
+			SELECT $1, id FROM retargeting_pixels WHERE id::text = $2 AND user_uid = $3
+			ON CONFLICT DO NOTHING
+		`, linkUID, id, userUID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			var exists bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM retargeting_pixels WHERE id::text = $1 AND user_uid = $2)", id, userUID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return ErrPixelNotFound
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"time"
+
+	rdb "github.com/RishiKendai/sot/pkg/database/redis"
+)
+
+const (
+	privacySaltPrefix = "privacy:salt:"
+	privacySaltTTL    = 48 * time.Hour
+)
+
+// TruncateIP drops the host part of an IP address (/24 for IPv4, /48 for IPv6)
+// so it can still be geolocated without identifying the visitor
+func TruncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// dailySalt returns the random salt for the given UTC day, creating it on first use.
+// Salts expire shortly after the day ends so old hashes can't be recomputed.
+func dailySalt(day time.Time) (string, error) {
+	key := privacySaltPrefix + day.UTC().Format("2006-01-02")
+
+	salt, err := GenerateAPIKey(32)
+	if err != nil {
+		return "", err
+	}
+	if _, err := rdb.RC.SetNX(key, salt, privacySaltTTL); err != nil {
+		return "", err
+	}
+	return rdb.RC.Get(key)
+}
+
+// VisitorHash returns a salted hash of IP and user agent that is only stable within a single day
+func VisitorHash(ip, userAgent string, clickedAt time.Time) (string, error) {
+	salt, err := dailySalt(clickedAt)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(salt + "|" + ip + "|" + userAgent))
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+const (
+	referrerBackfillState = "referrer_backfill"
+	referrerBackfillBatch = 1000
+)
+
+// Referrer source categories
+const (
+	ReferrerSearch   = "search"
+	ReferrerSocial   = "social"
+	ReferrerEmail    = "email"
+	ReferrerInternal = "internal"
+	ReferrerOther    = "other"
+)
+
+// Domains are matched on themselves and their subdomains
+var referrerDomains = map[string]string{
+	"bing.com":           ReferrerSearch,
+	"duckduckgo.com":     ReferrerSearch,
+	"search.yahoo.com":   ReferrerSearch,
+	"baidu.com":          ReferrerSearch,
+	"ecosia.org":         ReferrerSearch,
+	"search.brave.com":   ReferrerSearch,
+	"startpage.com":      ReferrerSearch,
+	"naver.com":          ReferrerSearch,
+	"facebook.com":       ReferrerSocial,
+	"fb.com":             ReferrerSocial,
+	"instagram.com":      ReferrerSocial,
+	"twitter.com":        ReferrerSocial,
+	"x.com":              ReferrerSocial,
+	"t.co":               ReferrerSocial,
+	"linkedin.com":       ReferrerSocial,
+	"lnkd.in":            ReferrerSocial,
+	"reddit.com":         ReferrerSocial,
+	"pinterest.com":      ReferrerSocial,
+	"tiktok.com":         ReferrerSocial,
+	"youtube.com":        ReferrerSocial,
+	"threads.net":        ReferrerSocial,
+	"whatsapp.com":       ReferrerSocial,
+	"wa.me":              ReferrerSocial,
+	"t.me":               ReferrerSocial,
+	"discord.com":        ReferrerSocial,
+	"snapchat.com":       ReferrerSocial,
+	"quora.com":          ReferrerSocial,
+	"mail.google.com":    ReferrerEmail,
+	"outlook.live.com":   ReferrerEmail,
+	"outlook.office.com": ReferrerEmail,
+	"mail.yahoo.com":     ReferrerEmail,
+	"mail.proton.me":     ReferrerEmail,
+}
+
+// Android apps send referrers like android-app://com.google.android.gm
+var referrerApps = map[string]string{
+	"com.google.android.gm":                   ReferrerEmail,
+	"com.microsoft.office.outlook":            ReferrerEmail,
+	"com.google.android.googlequicksearchbox": ReferrerSearch,
+	"com.facebook.katana":                     ReferrerSocial,
+	"com.instagram.android":                   ReferrerSocial,
+	"com.twitter.android":                     ReferrerSocial,
+	"com.linkedin.android":                    ReferrerSocial,
+	"com.reddit.frontpage":                    ReferrerSocial,
+	"org.telegram.messenger":                  ReferrerSocial,
+	"com.whatsapp":                            ReferrerSocial,
+}
+
+// ClassifyReferrer normalizes a Referer header into its host (lowercase, without www.)
+// and a source category. Both are empty for direct visits.
+func ClassifyReferrer(referrer string) (host, source string) {
+	referrer = strings.TrimSpace(referrer)
+	if referrer == "" {
+		return "", ""
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return "", ReferrerOther
+	}
+	host = strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	if u.Scheme == "android-app" {
+		if source, ok := referrerApps[host]; ok {
+			return host, source
+		}
+		return host, ReferrerOther
+	}
+
+	if domain := sotHost(); domain != "" && matchesDomain(host, domain) {
+		return host, ReferrerInternal
+	}
+	// Google has a search domain per country (google.com, google.co.in, ...)
+	if strings.HasPrefix(host, "google.") || strings.Contains(host, ".google.") {
+		if matchesDomain(host, "mail.google.com") {
+			return host, ReferrerEmail
+		}
+		return host, ReferrerSearch
+	}
+	if strings.HasPrefix(host, "yandex.") {
+		return host, ReferrerSearch
+	}
+	for domain, source := range referrerDomains {
+		if matchesDomain(host, domain) {
+			return host, source
+		}
+	}
+	if strings.HasPrefix(host, "mail.") || strings.HasPrefix(host, "webmail.") {
+		return host, ReferrerEmail
+	}
+	return host, ReferrerOther
+}
+
+// matchesDomain reports whether host is domain or one of its subdomains
+func matchesDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// sotHost returns SERVER_DOMAIN without its port
+func sotHost() string {
+	domain := strings.ToLower(os.Getenv("SERVER_DOMAIN"))
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		return h
+	}
+	return domain
+}
+
+// BackfillReferrers classifies clicks stored before referrer hosts were recorded, a batch per
+// transaction, then rebuilds the referrer and source rollups of the already rolled up period.
+// It runs once at startup and does nothing once it has completed.
+func BackfillReferrers() error {
+	row, err := postgres.FindOne("SELECT 1 FROM analytics_rollup_state WHERE name = $1", referrerBackfillState)
+	if err != nil {
+		return err
+	}
+	var done int
+	if err := row.Scan(&done); err == nil {
+		return nil
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	for {
+		classified, err := classifyReferrerBatch()
+		if err != nil {
+			return err
+		}
+		if classified == 0 {
+			break
+		}
+	}
+	return rollupBackfilledReferrers()
+}
+
+// classifyReferrerBatch classifies the referrers of up to referrerBackfillBatch clicks and
+// returns how many it classified
+func classifyReferrerBatch() (int, error) {
+	rows, err := postgres.FindMany(`
+		SELECT id, referrer FROM analytics
+		WHERE referrer_source IS NULL AND COALESCE(referrer, '') <> ''
+		LIMIT $1
+	`, referrerBackfillBatch)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id       int64
+		referrer string
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.referrer); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	tx, err := postgres.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, p := range batch {
+		host, source := ClassifyReferrer(p.referrer)
+		if _, err := tx.Exec("UPDATE analytics SET referrer_host = $1, referrer_source = $2 WHERE id = $3",
+			nullIfEmpty(host), source, p.id); err != nil {
+			return 0, fmt.Errorf("failed to classify referrer of click %d: %v", p.id, err)
+		}
+	}
+	return len(batch), tx.Commit()
+}
+
+// rollupBackfilledReferrers rebuilds the referrer and source rollups up to the rollup watermark
+// and marks the backfill done
+func rollupBackfilledReferrers() error {
+	watermark, err := RollupWatermark()
+	if err != nil {
+		return err
+	}
+
+	tx, err := postgres.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO analytics_rollup_dimensions (user_uid, short_link, bucket_date, dimension, value, value_code, clicks)
+		SELECT a.user_uid, a.short_link, a.click_timestamp::date, d.dimension, d.value, '', COUNT(*)
+		FROM analytics a
+		CROSS JOIN LATERAL (VALUES
+			('referrer', COALESCE(a.referrer_host, '')),
+			('source', COALESCE(a.referrer_source, ''))
+		) AS d(dimension, value)
+		WHERE a.user_uid IS NOT NULL AND a.click_timestamp < $1
+		GROUP BY a.user_uid, a.short_link, a.click_timestamp::date, d.dimension, d.value
+		ON CONFLICT (user_uid, short_link, bucket_date, dimension, value) DO UPDATE SET
+			clicks = EXCLUDED.clicks
+	`, watermark); err != nil {
+		return fmt.Errorf("failed to roll up referrers: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO analytics_rollup_state (name, watermark) VALUES ($1, $2)",
+		referrerBackfillState, watermark); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Report frequencies
+const (
+	ReportDaily   = "daily"
+	ReportWeekly  = "weekly"
+	ReportMonthly = "monthly"
+)
+
+// Reports are sent at this local hour of the user's time zone, once the period has closed
+const reportSendHour = 7
+
+// ErrReportSubscriptionNotFound is returned when a subscription doesn't exist or belongs to another user
+var ErrReportSubscriptionNotFound = errors.New("report subscription not found")
+
+// ReportSubscription is a scheduled email report of an account, or of a single link when ShortLink is set.
+// An empty Email sends to the account's email.
+type ReportSubscription struct {
+	ID         string     `json:"id"`
+	UserUID    string     `json:"-"`
+	ShortLink  string     `json:"short_link,omitempty"`
+	Frequency  string     `json:"frequency"`
+	Email      string     `json:"email,omitempty"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+	LastSentAt *time.Time `json:"last_sent_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const reportSubscriptionColumns = `id, user_uid, COALESCE(short_link, ''), frequency, COALESCE(email, ''), next_run_at, last_sent_at, created_at`
+
+func scanReportSubscription(row interface{ Scan(...any) error }) (ReportSubscription, error) {
+	var s ReportSubscription
+	err := row.Scan(&s.ID, &s.UserUID, &s.ShortLink, &s.Frequency, &s.Email, &s.NextRunAt, &s.LastSentAt, &s.CreatedAt)
+	return s, err
+}
+
+// ValidateReportFrequency checks a report frequency
+func ValidateReportFrequency(frequency string) error {
+	switch frequency {
+	case ReportDaily, ReportWeekly, ReportMonthly:
+		return nil
+	}
+	return fmt.Errorf("invalid report frequency: %s", frequency)
+}
+
+// ReportPeriod returns the dates, inclusive, covered by a report run at runAt: the previous
+// day, the previous Monday to Sunday week or the previous calendar month in loc
+func ReportPeriod(frequency string, runAt time.Time, loc *time.Location) (string, string) {
+	local := runAt.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	var start, end time.Time
+	switch frequency {
+	case ReportWeekly:
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		start, end = monday.AddDate(0, 0, -7), monday.AddDate(0, 0, -1)
+	case ReportMonthly:
+		first := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, loc)
+		start, end = first.AddDate(0, -1, 0), first.AddDate(0, 0, -1)
+	default:
+		start, end = today.AddDate(0, 0, -1), today.AddDate(0, 0, -1)
+	}
+	return start.Format("2006-01-02"), end.Format("2006-01-02")
+}
+
+// NextReportRun returns when the first report period starting after `after` closes
+func NextReportRun(frequency string, after time.Time, loc *time.Location) time.Time {
+	local := after.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	var next time.Time
+	switch frequency {
+	case ReportWeekly:
+		next = today.AddDate(0, 0, 7-((int(today.Weekday())+6)%7))
+	case ReportMonthly:
+		next = time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, loc)
+	default:
+		next = today.AddDate(0, 0, 1)
+	}
+	return time.Date(next.Year(), next.Month(), next.Day(), reportSendHour, 0, 0, 0, loc).UTC()
+}
+
+// CreateReportSubscription subscribes a user to a report. Subscribing again to the same report
+// only updates its recipient.
+func CreateReportSubscription(userUID, shortLink, frequency, email string) (ReportSubscription, error) {
+	if err := ValidateReportFrequency(frequency); err != nil {
+		return ReportSubscription{}, err
+	}
+	tz, err := ResolveTimeZone("", userUID)
+	if err != nil {
+		return ReportSubscription{}, err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return ReportSubscription{}, err
+	}
+
+	row, err := postgres.FindOne(`
+		INSERT INTO report_subscriptions (user_uid, short_link, frequency, email, next_run_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT (user_uid, short_link, frequency) DO UPDATE SET email = EXCLUDED.email
+		RETURNING `+reportSubscriptionColumns,
+		userUID, shortLink, frequency, email, NextReportRun(frequency, time.Now(), loc),
+	)
+	if err != nil {
+		return ReportSubscription{}, err
+	}
+	return scanReportSubscription(row)
+}
+
+// ListReportSubscriptions returns the report subscriptions of a user
+func ListReportSubscriptions(userUID string) ([]ReportSubscription, error) {
+	rows, err := postgres.FindMany("SELECT "+reportSubscriptionColumns+" FROM report_subscriptions WHERE user_uid = $1 ORDER BY created_at", userUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []ReportSubscription{}
+	for rows.Next() {
+		s, err := scanReportSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// DeleteReportSubscription unsubscribes a user from a report
+func DeleteReportSubscription(userUID, id string) error {
+	res, err := postgres.DeleteOne("DELETE FROM report_subscriptions WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrReportSubscriptionNotFound
+	}
+	return nil
+}
+
+// DueReportSubscriptions returns up to limit subscriptions whose report is due
+func DueReportSubscriptions(now time.Time, limit int) ([]ReportSubscription, error) {
+	rows, err := postgres.FindMany(`
+		SELECT `+reportSubscriptionColumns+` FROM report_subscriptions
+		WHERE next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2
+	`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []ReportSubscription
+	for rows.Next() {
+		s, err := scanReportSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// ClaimReportRun moves a due subscription to its next run. It returns false when another
+// worker already claimed this run, so each report is sent once.
+func ClaimReportRun(s ReportSubscription, nextRunAt, sentAt time.Time) (bool, error) {
+	res, err := postgres.UpdateOne(`
+		UPDATE report_subscriptions SET next_run_at = $1, last_sent_at = $2
+		WHERE id = $3 AND next_run_at = $4
+	`, nextRunAt, sentAt, s.ID, s.NextRunAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
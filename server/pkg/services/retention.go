@@ -0,0 +1,207 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Bounds of a retention period in days
+const (
+	MinRetentionDays = 30
+	MaxRetentionDays = 3650
+)
+
+const (
+	// Server defaults, overridable with ANALYTICS_RAW_RETENTION_DAYS and ANALYTICS_ROLLUP_RETENTION_DAYS
+	defaultRawRetentionDays    = 395
+	defaultRollupRetentionDays = 1825
+	// Raw clicks deleted per statement when purging accounts with a shorter retention
+	retentionDeleteBatch = 10000
+)
+
+// RetentionPolicy is how long an account's analytics are kept. Raw click events back the click
+// API, exports and recent activity; rollups back every chart and breakdown, so they are kept longer.
+type RetentionPolicy struct {
+	RawDays    int `json:"raw_retention_days"`
+	RollupDays int `json:"rollup_retention_days"`
+}
+
+func envDays(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= MinRetentionDays && v <= MaxRetentionDays {
+		return v
+	}
+	return fallback
+}
+
+// DefaultRetentionPolicy returns the retention of accounts that haven't set their own
+func DefaultRetentionPolicy() RetentionPolicy {
+	p := RetentionPolicy{
+		RawDays:    envDays("ANALYTICS_RAW_RETENTION_DAYS", defaultRawRetentionDays),
+		RollupDays: envDays("ANALYTICS_ROLLUP_RETENTION_DAYS", defaultRollupRetentionDays),
+	}
+	if p.RollupDays < p.RawDays {
+		p.RollupDays = p.RawDays
+	}
+	return p
+}
+
+// Validate checks both periods are within bounds and rollups outlive raw events
+func (p RetentionPolicy) Validate() error {
+	if p.RawDays < MinRetentionDays || p.RawDays > MaxRetentionDays {
+		return fmt.Errorf("raw_retention_days must be between %d and %d", MinRetentionDays, MaxRetentionDays)
+	}
+	if p.RollupDays < p.RawDays || p.RollupDays > MaxRetentionDays {
+		return fmt.Errorf("rollup_retention_days must be between raw_retention_days and %d", MaxRetentionDays)
+	}
+	return nil
+}
+
+// GetRetentionPolicy returns the retention of a user, falling back to the defaults
+func GetRetentionPolicy(userUID string) (RetentionPolicy, error) {
+	p := DefaultRetentionPolicy()
+	var raw, rollup sql.NullInt64
+	row, err := postgres.FindOne("SELECT raw_retention_days, rollup_retention_days FROM users WHERE uid = $1", userUID)
+	if err != nil {
+		return p, err
+	}
+	if err := row.Scan(&raw, &rollup); err != nil {
+		return p, err
+	}
+	if raw.Valid {
+		p.RawDays = int(raw.Int64)
+	}
+	if rollup.Valid {
+		p.RollupDays = int(rollup.Int64)
+	}
+	return p, nil
+}
+
+// SaveRetentionPolicy stores the retention of a user. A nil policy restores the defaults.
+func SaveRetentionPolicy(userUID string, p *RetentionPolicy) error {
+	var raw, rollup *int
+	if p != nil {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		raw, rollup = &p.RawDays, &p.RollupDays
+	}
+	_, err := postgres.UpdateOne("UPDATE users SET raw_retention_days = $1, rollup_retention_days = $2 WHERE uid = $3", raw, rollup, userUID)
+	return err
+}
+
+// RunAnalyticsMaintenance creates the upcoming analytics partitions and applies every account's
+// retention: raw clicks past their retention are deleted, whole partitions are dropped once no
+// account keeps them, and expired rollups are deleted. Clicks that aren't rolled up yet are never removed.
+func RunAnalyticsMaintenance() error {
+	if err := postgres.EnsureUpcomingAnalyticsPartitions(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	watermark, err := RollupWatermark()
+	if err != nil || watermark.IsZero() {
+		return err
+	}
+	defaults := DefaultRetentionPolicy()
+
+	if err := purgeRawAnalytics(now, watermark, defaults.RawDays); err != nil {
+		return fmt.Errorf("failed to purge raw analytics: %v", err)
+	}
+
+	// Partitions can go once they are past the longest raw retention of any account
+	var longest sql.NullInt64
+	row, err := postgres.FindOne("SELECT MAX(raw_retention_days) FROM users")
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&longest); err != nil {
+		return err
+	}
+	keepDays := defaults.RawDays
+	if longest.Valid && int(longest.Int64) > keepDays {
+		keepDays = int(longest.Int64)
+	}
+	cutoff := now.AddDate(0, 0, -keepDays)
+	if watermark.Before(cutoff) {
+		cutoff = watermark
+	}
+	dropped, err := postgres.DropAnalyticsPartitionsBefore(cutoff)
+	if len(dropped) > 0 {
+		log.Printf("Dropped expired analytics partitions: %v", dropped)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := purgeRollups(now, defaults.RollupDays); err != nil {
+		return fmt.Errorf("failed to purge analytics rollups: %v", err)
+	}
+	return nil
+}
+
+// purgeRawAnalytics deletes the raw clicks older than their account's retention in batches.
+// Only clicks older than the shortest retention of any account are looked at, so Postgres
+// skips every partition still kept in full.
+func purgeRawAnalytics(now, watermark time.Time, defaultDays int) error {
+	var shortest sql.NullInt64
+	row, err := postgres.FindOne("SELECT MIN(raw_retention_days) FROM users")
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&shortest); err != nil {
+		return err
+	}
+	minDays := defaultDays
+	if shortest.Valid && int(shortest.Int64) < minDays {
+		minDays = int(shortest.Int64)
+	}
+	floor := now.AddDate(0, 0, -minDays)
+	if watermark.Before(floor) {
+		floor = watermark
+	}
+
+	for {
+		res, err := postgres.DeleteOne(`
+			DELETE FROM analytics WHERE (id, click_timestamp) IN (
+				SELECT a.id, a.click_timestamp FROM analytics a
+				LEFT JOIN users u ON u.uid = a.user_uid
+				WHERE a.click_timestamp < $2
+					AND a.click_timestamp < $1::timestamp - make_interval(days => COALESCE(u.raw_retention_days, $3::int))
+				LIMIT $4
+			)
+		`, now, floor, defaultDays, retentionDeleteBatch)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n < retentionDeleteBatch {
+			return nil
+		}
+	}
+}
+
+// purgeRollups deletes the rollup rows older than their account's rollup retention
+func purgeRollups(now time.Time, defaultDays int) error {
+	for _, stmt := range []string{
+		`DELETE FROM analytics_rollup_hourly r USING users u
+		WHERE r.user_uid = u.uid AND r.bucket_start < $1::timestamp - make_interval(days => COALESCE(u.rollup_retention_days, $2::int))`,
+		`DELETE FROM analytics_rollup_daily r USING users u
+		WHERE r.user_uid = u.uid AND r.bucket_date < ($1::timestamp - make_interval(days => COALESCE(u.rollup_retention_days, $2::int)))::date`,
+		`DELETE FROM analytics_rollup_dimensions r USING users u
+		WHERE r.user_uid = u.uid AND r.bucket_date < ($1::timestamp - make_interval(days => COALESCE(u.rollup_retention_days, $2::int)))::date`,
+	} {
+		if _, err := postgres.DeleteOne(stmt, now, defaultDays); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// ErrTagNotFound is returned when none of the user's links has the tag
+var ErrTagNotFound = errors.New("tag not found")
+
+// Tag is a tag of an account with how many live links have it
+type Tag struct {
+	Name  string `json:"name"`
+	Links int    `json:"links"`
+}
+
+// TagClicks contains the clicks on the links having a tag within a scope. A link with several
+// tags counts towards each of them.
+type TagClicks struct {
+	Tag         string `json:"tag"`
+	Links       int    `json:"links"`
+	TotalClicks int64  `json:"total_clicks"`
+}
+
+// TagChange reports how many links a tag operation changed
+type TagChange struct {
+	Links int `json:"links"`
+}
+
+// ListTags returns the tags of a user's live links by name
+func ListTags(userUID string) ([]Tag, error) {
+	rows, err := postgres.FindMany(`
+		SELECT t, COUNT(*)
+		FROM links l, jsonb_array_elements_text(COALESCE(l.tags, '[]'::jsonb)) t
+		WHERE l.user_uid = $1 AND l.deleted = FALSE
+		GROUP BY t
+		ORDER BY lower(t), t
+	`, userUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Links); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// RenameTag renames a tag on every link of the user. Links that already have the new name
+// keep it once, so renaming to an existing tag merges the two.
+func RenameTag(userUID, tag, newName string) (TagChange, error) {
+	return MergeTags(userUID, []string{tag}, newName)
+}
+
+// MergeTags replaces the given tags with into on every link of the user, including links in
+// the trash, keeping each link's tags in their order without repeats
+func MergeTags(userUID string, tags []string, into string) (TagChange, error) {
+	tags = cleanTags(tags)
+	into = strings.TrimSpace(into)
+	if len(tags) == 0 {
+		return TagChange{}, errors.New("tags are required")
+	}
+	if into == "" {
+		return TagChange{}, errors.New("the new tag name is required")
+	}
+	from, err := json.Marshal(tags)
+	if err != nil {
+		return TagChange{}, err
+	}
+	return updateTags(`
+		UPDATE links SET tags = (
+			SELECT COALESCE(jsonb_agg(t ORDER BY pos), '[]'::jsonb) FROM (
+				SELECT CASE WHEN e IN (SELECT jsonb_array_elements_text($2::jsonb)) THEN $3 ELSE e END AS t, MIN(pos) AS pos
+				FROM jsonb_array_elements_text(tags) WITH ORDINALITY AS x(e, pos)
+				GROUP BY 1
+			) s
+		), updated_at = NOW()
+		WHERE user_uid = $1 AND tags ?| ARRAY(SELECT jsonb_array_elements_text($2::jsonb))
+		RETURNING short_link, original_link, expiry_date, tags, COALESCE(deleted, FALSE)
+	`, userUID, string(from), into)
+}
+
+// DeleteTag removes a tag from every link of the user, including links in the trash
+func DeleteTag(userUID, tag string) (TagChange, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return TagChange{}, errors.New("tag is required")
+	}
+	return updateTags(`
+		UPDATE links SET tags = tags - $2::text, updated_at = NOW()
+		WHERE user_uid = $1 AND tags ? $2
+		RETURNING short_link, original_link, expiry_date, tags, COALESCE(deleted, FALSE)
+	`, userUID, tag)
+}
+
+// updateTags runs a tag update returning the changed links, then refreshes their cache and
+// queues a webhook event for each live one
+func updateTags(query, userUID string, args ...any) (TagChange, error) {
+	rows, err := postgres.FindMany(query, append([]any{userUID}, args...)...)
+	if err != nil {
+		return TagChange{}, err
+	}
+	var changed []bulkLink
+	for rows.Next() {
+		var l bulkLink
+		var tagsJSON []byte
+		if err := rows.Scan(&l.shortLink, &l.originalURL, &l.expiryDate, &tagsJSON, &l.deleted); err != nil {
+			rows.Close()
+			return TagChange{}, err
+		}
+		if len(tagsJSON) > 0 {
+			if err := json.Unmarshal(tagsJSON, &l.tags); err != nil {
+				rows.Close()
+				return TagChange{}, err
+			}
+		}
+		changed = append(changed, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return TagChange{}, err
+	}
+	if len(changed) == 0 {
+		return TagChange{}, ErrTagNotFound
+	}
+
+	for _, l := range changed {
+		refreshLinkCache(l, l.deleted)
+		if l.deleted {
+			continue
+		}
+		expiry := l.expiryDate.UTC()
+		EmitLinkEvent(userUID, EventLinkUpdated, LinkEvent{
+			ShortLink:   l.shortLink,
+			OriginalURL: l.originalURL,
+			ExpiryDate:  &expiry,
+			Tags:        l.tags,
+		})
+	}
+	return TagChange{Links: len(changed)}, nil
+}
+
+// FetchTagClicks returns the clicks on the live links of each tag of the scope, most clicked first
+func FetchTagClicks(s StatsScope) ([]TagClicks, error) {
+	var args queryArgs
+	userArg := args.add(s.UserUID)
+	rollupWhere := s.rollupFilter(&args, sourceDaily)
+	rawWhere := s.rawFilter(&args, sourceDaily)
+	folder := ""
+	if s.FolderID != "" {
+		folder = "AND l.folder_id::text = " + args.add(s.FolderID)
+	}
+	query := fmt.Sprintf(`
+		SELECT tag, COUNT(*), COALESCE(SUM(t.clicks), 0)::bigint
+		FROM links l
+		CROSS JOIN LATERAL jsonb_array_elements_text(COALESCE(l.tags, '[]'::jsonb)) tag
+		LEFT JOIN (
+			SELECT short_link, SUM(clicks) AS clicks FROM (
+				SELECT short_link, clicks
+				FROM analytics_rollup_daily
+				WHERE %s
+				UNION ALL
+				SELECT a.short_link, COUNT(*)
+				FROM analytics a
+				WHERE %s
+				GROUP BY a.short_link
+			) u
+			GROUP BY short_link
+		) t ON t.short_link = l.short_link
+		WHERE l.user_uid = %s AND l.deleted = FALSE %s
+		GROUP BY tag
+		ORDER BY 3 DESC, lower(tag)
+	`, rollupWhere, rawWhere, userArg, folder)
+
+	rows, err := postgres.FindMany(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagClicks{}
+	for rows.Next() {
+		var t TagClicks
+		if err := rows.Scan(&t.Tag, &t.Links, &t.TotalClicks); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
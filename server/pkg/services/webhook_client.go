@@ -0,0 +1,84 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// NewPublicClient returns an HTTP client for user supplied URLs. It only connects to public
+// addresses, checked on the address actually dialed so a changed DNS answer can't reach internal
+// hosts, and it doesn't follow redirects.
+func NewPublicClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: func(network, address string, _ syscall.RawConn) error {
+					host, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					ip, err := netip.ParseAddr(host)
+					if err != nil || !isPublicAddr(ip) {
+						return fmt.Errorf("address %s is not public", host)
+					}
+					return nil
+				},
+			}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookClient delivers webhooks to user supplied URLs
+var webhookClient = NewPublicClient(10 * time.Second)
+
+// Carrier-grade NAT addresses aren't reported as private but are just as internal
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether an address may be reached for a user supplied URL: not loopback, private (RFC 1918
+// or IPv6 ULA), link-local, shared, unspecified or multicast
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// ValidateWebhookURL checks that a webhook URL is an absolute http(s) URL that doesn't name an
+// internal host. Hostnames are checked again on every delivery once resolved.
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url: %s", raw)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook url must not point to an internal host: %s", raw)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !isPublicAddr(ip) {
+		return fmt.Errorf("webhook url must not point to an internal host: %s", raw)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the value of the X-SOT-Signature header of a webhook body
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
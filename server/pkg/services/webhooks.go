@@ -0,0 +1,518 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+)
+
+// Webhook events
+const (
+	EventLinkCreated   = "link.created"
+	EventLinkUpdated   = "link.updated"
+	EventLinkDeleted   = "link.deleted"
+	EventLinkExpired   = "link.expired"
+	EventClickRecorded = "click.recorded"
+	// Traffic alerts are sent as alert.<kind>
+	EventAlertSpike     = "alert." + AlertSpike
+	EventAlertDrop      = "alert." + AlertDrop
+	EventAlertGeography = "alert." + AlertGeography
+	EventAlertBotShare  = "alert." + AlertBotShare
+)
+
+// WebhookEvents are the events an endpoint can subscribe to
+var WebhookEvents = []string{EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkExpired, EventClickRecorded,
+	EventAlertSpike, EventAlertDrop, EventAlertGeography, EventAlertBotShare}
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// A claimed delivery is retried by another worker if it isn't completed within the lease
+	webhookLease          = 2 * time.Minute
+	webhookWorkers        = 8
+	webhookResponseLimit  = 2048
+	webhookLinkExpiryName = "link_expiry_webhooks"
+)
+
+// Delays before each retry; a delivery fails for good once they are used up
+var webhookRetryDelays = []time.Duration{
+	time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// ErrWebhookNotFound is returned when a webhook endpoint or delivery doesn't exist or belongs to another user
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookEndpoint is a URL receiving the events it subscribed to. The secret signs every delivery.
+type WebhookEndpoint struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Secret      string    `json:"secret,omitempty"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is an entry of the delivery log of an endpoint
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	EndpointID     string          `json:"endpoint_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	ResponseStatus *int            `json:"response_status"`
+	Error          *string         `json:"error"`
+	ReplayOf       *string         `json:"replay_of,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// webhookEvent is the JSON body of every delivery. ID stays the same across endpoints and
+// replays so receivers can drop duplicates.
+type webhookEvent struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// LinkEvent is the data of the link.* events
+type LinkEvent struct {
+	ShortLink         string     `json:"short_link"`
+	PreviousShortLink string     `json:"previous_short_link,omitempty"`
+	OriginalURL       string     `json:"original_url,omitempty"`
+	ExpiryDate        *time.Time `json:"expiry_date,omitempty"`
+	Tags              []string   `json:"tags,omitempty"`
+}
+
+// ValidateWebhookEvents checks that events is a non-empty list of known events
+func ValidateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, e := range events {
+		known := false
+		for _, w := range WebhookEvents {
+			if e == w {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown webhook event: %s", e)
+		}
+	}
+	return nil
+}
+
+const webhookEndpointColumns = `id, url, COALESCE(description, ''), secret, events, COALESCE(active, TRUE), created_at, updated_at`
+
+func scanWebhookEndpoint(row interface{ Scan(...any) error }) (WebhookEndpoint, error) {
+	var e WebhookEndpoint
+	var events []byte
+	if err := row.Scan(&e.ID, &e.URL, &e.Description, &e.Secret, &events, &e.Active, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return e, err
+	}
+	err := json.Unmarshal(events, &e.Events)
+	return e, err
+}
+
+// CreateWebhookEndpoint registers an endpoint with a new signing secret
+func CreateWebhookEndpoint(userUID, url, description string, events []string) (WebhookEndpoint, error) {
+	secret, err := GenerateAPIKey(32)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	eventsJSON, err := json.Marshal(events)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	row, err := postgres.FindOne(`
+		INSERT INTO webhook_endpoints (user_uid, url, description, secret, events)
+		VALUES ($1, $2, $3, $4, $5::jsonb)
+		RETURNING `+webhookEndpointColumns,
+		userUID, url, description, secret, string(eventsJSON),
+	)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	return scanWebhookEndpoint(row)
+}
+
+// ListWebhookEndpoints returns the endpoints of a user without their secrets
+func ListWebhookEndpoints(userUID string) ([]WebhookEndpoint, error) {
+	rows, err := postgres.FindMany("SELECT "+webhookEndpointColumns+" FROM webhook_endpoints WHERE user_uid = $1 ORDER BY created_at", userUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		e.Secret = ""
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+// GetWebhookEndpoint returns an endpoint of a user, including its secret
+func GetWebhookEndpoint(userUID, id string) (WebhookEndpoint, error) {
+	row, err := postgres.FindOne("SELECT "+webhookEndpointColumns+" FROM webhook_endpoints WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	e, err := scanWebhookEndpoint(row)
+	if err == sql.ErrNoRows {
+		return e, ErrWebhookNotFound
+	}
+	return e, err
+}
+
+// UpdateWebhookEndpoint replaces the URL, description, events and active flag of an endpoint
+func UpdateWebhookEndpoint(userUID string, e WebhookEndpoint) (WebhookEndpoint, error) {
+	eventsJSON, err := json.Marshal(e.Events)
+	if err != nil {
+		return e, err
+	}
+	row, err := postgres.FindOne(`
+		UPDATE webhook_endpoints SET url = $1, description = $2, events = $3::jsonb, active = $4, updated_at = $5
+		WHERE id::text = $6 AND user_uid = $7
+		RETURNING `+webhookEndpointColumns,
+		e.URL, e.Description, string(eventsJSON), e.Active, time.Now().UTC(), e.ID, userUID,
+	)
+	if err != nil {
+		return e, err
+	}
+	updated, err := scanWebhookEndpoint(row)
+	if err == sql.ErrNoRows {
+		return updated, ErrWebhookNotFound
+	}
+	updated.Secret = ""
+	return updated, err
+}
+
+// DeleteWebhookEndpoint removes an endpoint and its delivery log
+func DeleteWebhookEndpoint(userUID, id string) error {
+	res, err := postgres.DeleteOne("DELETE FROM webhook_endpoints WHERE id::text = $1 AND user_uid = $2", id, userUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent queues a delivery of the event to every active endpoint of the user subscribed to it
+func EnqueueWebhookEvent(userUID, event string, data any) error {
+	_, err := enqueueWebhookEvent(userUID, event, data)
+	return err
+}
+
+// enqueueWebhookEvent queues the event and returns how many endpoints it was queued for
+func enqueueWebhookEvent(userUID, event string, data any) (int64, error) {
+	id, err := GenerateAPIKey(16)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := json.Marshal(webhookEvent{ID: id, Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return 0, err
+	}
+	res, err := postgres.UpdateOne(`
+		INSERT INTO webhook_deliveries (endpoint_id, user_uid, event, payload, next_attempt_at)
+		SELECT id, user_uid, $2, $3::jsonb, $4 FROM webhook_endpoints
+		WHERE user_uid = $1 AND active = TRUE AND events @> jsonb_build_array($2::text)
+	`, userUID, event, string(payload), time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// EmitLinkEvent queues a link.* event. Webhooks never fail the request that triggered them.
+func EmitLinkEvent(userUID, event string, link LinkEvent) {
+	if err := EnqueueWebhookEvent(userUID, event, link); err != nil {
+		log.Printf("Failed to queue %s webhook for %s: %v", event, link.ShortLink, err)
+	}
+}
+
+// ListWebhookDeliveries returns the most recent deliveries of an endpoint, optionally of one status
+func ListWebhookDeliveries(userUID, endpointID, status string, limit int) ([]WebhookDelivery, error) {
+	rows, err := postgres.FindMany(`
+		SELECT d.id, d.endpoint_id, d.event, d.payload, d.status, d.attempts,
+			CASE WHEN d.status = 'pending' THEN d.next_attempt_at END,
+			d.response_status, d.error, d.replay_of, d.created_at, d.delivered_at
+		FROM webhook_deliveries d
+		WHERE d.endpoint_id::text = $1 AND d.user_uid = $2 AND ($3 = '' OR d.status = $3)
+		ORDER BY d.created_at DESC
+		LIMIT $4
+	`, endpointID, userUID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.ResponseStatus, &d.Error, &d.ReplayOf, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ReplayWebhookDelivery queues the payload of a past delivery again as a new delivery
+func ReplayWebhookDelivery(userUID, endpointID, deliveryID string) (string, error) {
+	row, err := postgres.FindOne(`
+		INSERT INTO webhook_deliveries (endpoint_id, user_uid, event, payload, next_attempt_at, replay_of)
+		SELECT endpoint_id, user_uid, event, payload, $4, id FROM webhook_deliveries
+		WHERE id::text = $1 AND endpoint_id::text = $2 AND user_uid = $3
+		RETURNING id
+	`, deliveryID, endpointID, userUID, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	var id string
+	if err := row.Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrWebhookNotFound
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+// pendingDelivery is a claimed delivery with its endpoint
+type pendingDelivery struct {
+	id       string
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+	active   bool
+}
+
+// ProcessWebhookDeliveries queues link.expired events and sends every due delivery. Deliveries are
+// claimed with a lease, so one left behind by a crashed worker is retried once the lease ends.
+func ProcessWebhookDeliveries() error {
+	if err := enqueueExpiredLinks(); err != nil {
+		log.Printf("Failed to queue link.expired webhooks: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, webhookWorkers)
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				d, err := claimWebhookDelivery()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if d == nil {
+					return
+				}
+				if err := completeWebhookDelivery(*d, sendWebhookDelivery(*d)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func claimWebhookDelivery() (*pendingDelivery, error) {
+	now := time.Now().UTC()
+	row, err := postgres.FindOne(`
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = $1
+			WHERE id = (
+				SELECT id FROM webhook_deliveries
+				WHERE status = $2 AND next_attempt_at <= $3
+				ORDER BY next_attempt_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, endpoint_id, event, payload, attempts
+		)
+		SELECT c.id, c.event, c.payload, c.attempts, e.url, e.secret, COALESCE(e.active, TRUE)
+		FROM claimed c JOIN webhook_endpoints e ON e.id = c.endpoint_id
+	`, now.Add(webhookLease), DeliveryPending, now)
+	if err != nil {
+		return nil, err
+	}
+	var d pendingDelivery
+	if err := row.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret, &d.active); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// deliveryResult is the outcome of one delivery attempt
+type deliveryResult struct {
+	status int
+	err    error
+}
+
+func sendWebhookDelivery(d pendingDelivery) deliveryResult {
+	if !d.active {
+		return deliveryResult{err: errors.New("endpoint is disabled")}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return deliveryResult{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SOT-Webhooks/1.0")
+	req.Header.Set("X-SOT-Event", d.event)
+	req.Header.Set("X-SOT-Delivery", d.id)
+	req.Header.Set("X-SOT-Signature", SignWebhookPayload(d.secret, d.payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return deliveryResult{err: err}
+	}
+	defer resp.Body.Close()
+	// Only the status is kept. The body is drained, up to a limit, so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+
+	result := deliveryResult{status: resp.StatusCode}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.err = fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return result
+}
+
+// completeWebhookDelivery records an attempt and schedules the next retry with backoff
+func completeWebhookDelivery(d pendingDelivery, r deliveryResult) error {
+	now := time.Now().UTC()
+	status := DeliverySucceeded
+	var deliveredAt, nextAttempt *time.Time
+	var errMsg *string
+	var respStatus *int
+	if r.status != 0 {
+		respStatus = &r.status
+	}
+
+	if r.err == nil {
+		deliveredAt = &now
+	} else {
+		msg := r.err.Error()
+		errMsg = &msg
+		status = DeliveryFailed
+		if d.active && d.attempts <= len(webhookRetryDelays) {
+			status = DeliveryPending
+			next := now.Add(webhookRetryDelays[d.attempts-1])
+			nextAttempt = &next
+		}
+	}
+
+	_, err := postgres.UpdateOne(`
+		UPDATE webhook_deliveries SET status = $1, next_attempt_at = COALESCE($2, next_attempt_at),
+			response_status = $3, error = $4, delivered_at = $5
+		WHERE id = $6
+	`, status, nextAttempt, respStatus, errMsg, deliveredAt, d.id)
+	return err
+}
+
+// enqueueExpiredLinks queues link.expired for the links whose expiry date passed since the last run
+func enqueueExpiredLinks() error {
+	now := time.Now().UTC()
+	var since sql.NullTime
+	row, err := postgres.FindOne("SELECT watermark FROM webhook_state WHERE name = $1", webhookLinkExpiryName)
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&since); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if !since.Valid {
+		// First run: start from now rather than announcing every link that ever expired
+		since.Time = now
+	}
+
+	rows, err := postgres.FindMany(`
+		SELECT l.user_uid, l.short_link, l.original_link, l.expiry_date
+		FROM links l
+		WHERE l.expiry_date > $1 AND l.expiry_date <= $2 AND l.deleted = false
+			AND EXISTS (
+				SELECT 1 FROM webhook_endpoints e
+				WHERE e.user_uid = l.user_uid AND e.active = TRUE AND e.events @> jsonb_build_array($3::text)
+			)
+	`, since.Time, now, EventLinkExpired)
+	if err != nil {
+		return err
+	}
+	type expired struct {
+		userUID string
+		link    LinkEvent
+	}
+	var links []expired
+	for rows.Next() {
+		var e expired
+		var expiry time.Time
+		if err := rows.Scan(&e.userUID, &e.link.ShortLink, &e.link.OriginalURL, &expiry); err != nil {
+			rows.Close()
+			return err
+		}
+		e.link.ExpiryDate = &expiry
+		links = append(links, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range links {
+		if err := EnqueueWebhookEvent(e.userUID, EventLinkExpired, e.link); err != nil {
+			return err
+		}
+	}
+
+	_, err = postgres.UpdateOne(`
+		INSERT INTO webhook_state (name, watermark) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET watermark = EXCLUDED.watermark
+	`, webhookLinkExpiryName, now)
+	return err
+}
@@ -0,0 +1,248 @@
+package batches
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RishiKendai/sot/api/v1/controllers/links"
+	"github.com/RishiKendai/sot/pkg/services"
+)
+
+const (
+	// Rows of a batch created concurrently
+	batchWorkers = 8
+	// Progress is saved every this many rows
+	progressEvery  = 100
+	maxAliasLength = 255
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var resultHeader = []string{"row", "original_url", "custom_backoff", "status", "short_link", "short_url", "flagged", "flag_reason", "error"}
+
+// ProcessLinkBatches removes expired result files and runs queued batches one after another
+func ProcessLinkBatches() error {
+	if err := services.ExpireLinkBatchFiles(); err != nil {
+		log.Printf("Failed to expire link batch files: %v", err)
+	}
+
+	for {
+		batch, rows, err := services.ClaimLinkBatch()
+		if err != nil {
+			return err
+		}
+		if batch == nil {
+			return nil
+		}
+
+		if err := runBatch(batch, rows); err != nil {
+			log.Printf("Link batch %s failed: %v", batch.ID, err)
+			if err := services.FailLinkBatch(*batch, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runBatch creates the links of a batch and writes the result file. A row that fails only
+// fails itself; the batch fails when the result file can't be written.
+func runBatch(batch *services.LinkBatch, rows []services.BatchLinkRow) error {
+	prefix, err := links.ShortLinkURL(batch.UserUID, "")
+	if err != nil {
+		return err
+	}
+
+	results := make([]services.BatchRowResult, len(rows))
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < batchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := createRow(*batch, prefix, i+1, rows[i])
+				results[i] = result
+
+				mu.Lock()
+				batch.ProcessedRows++
+				if result.Error == "" {
+					batch.CreatedRows++
+				} else {
+					batch.FailedRows++
+				}
+				if result.Conflict {
+					batch.ConflictRows++
+				}
+				if batch.ProcessedRows%progressEvery == 0 {
+					if err := services.UpdateLinkBatchProgress(*batch); err != nil {
+						log.Printf("Failed to save progress of link batch %s: %v", batch.ID, err)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range rows {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	path := services.LinkBatchFile(*batch)
+	if err := writeResults(path, results); err != nil {
+		return err
+	}
+	if err := services.CompleteLinkBatch(*batch, path); err != nil {
+		return fmt.Errorf("failed to complete link batch: %v", err)
+	}
+	return nil
+}
+
+// createRow validates an input row and creates its link. Imported links also keep what they
+// brought from the previous shortener.
+func createRow(batch services.LinkBatch, prefix string, n int, row services.BatchLinkRow) services.BatchRowResult {
+	result := services.BatchRowResult{
+		Row:           n,
+		OriginalURL:   row.OriginalURL,
+		CustomBackoff: row.CustomBackoff,
+	}
+
+	payload, err := rowPayload(row)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var details services.ImportBaseline
+	if batch.Source == services.BatchSourceImport {
+		if details, err = services.ParseImportDetails(row); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	link, err := links.CreateLink(batch.UserUID, payload)
+	if err != nil {
+		if err == links.ErrAliasInUse {
+			result.Conflict = true
+			result.Error = "alias is already in use"
+		} else {
+			log.Printf("Failed to create link for row %d: %v", n, err)
+			result.Error = "failed to create link"
+		}
+		return result
+	}
+
+	if batch.Source == services.BatchSourceImport {
+		// The link exists either way, so a lost baseline doesn't fail the row
+		if err := services.RecordLinkImport(batch, link.Uid, details); err != nil {
+			log.Printf("Failed to record import details for row %d: %v", n, err)
+		}
+	}
+
+	result.ShortLink = link.ShortLink
+	result.ShortURL = prefix + link.ShortLink
+	result.Flagged = link.IsFlagged
+	result.FlagReason = link.FlagReason
+	return result
+}
+
+// rowPayload checks an input row the way the create endpoint would and converts it to a payload
+func rowPayload(row services.BatchLinkRow) (links.CreateShortURLPayload, error) {
+	payload := links.CreateShortURLPayload{
+		Original_url:   strings.TrimSpace(row.OriginalURL),
+		Custom_backoff: strings.TrimSpace(row.CustomBackoff),
+		Password:       row.Password,
+	}
+	if payload.Original_url == "" {
+		return payload, errors.New("URL is required")
+	}
+	if err := services.ValidateDestination(payload.Original_url); err != nil {
+		return payload, err
+	}
+	if payload.Custom_backoff != "" {
+		if len(payload.Custom_backoff) > maxAliasLength || !aliasPattern.MatchString(payload.Custom_backoff) {
+			return payload, fmt.Errorf("alias must be at most %d letters, digits, dashes or underscores", maxAliasLength)
+		}
+	}
+	if payload.Password != nil && *payload.Password == "" {
+		payload.Password = nil
+	}
+	if row.ExpiryDate != "" {
+		expiry, err := parseExpiry(row.ExpiryDate)
+		if err != nil {
+			return payload, err
+		}
+		if !expiry.After(time.Now()) {
+			return payload, errors.New("expiry_date must be in the future")
+		}
+		payload.Expiry_date = expiry
+	}
+	for _, tag := range row.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			payload.Tags = append(payload.Tags, tag)
+		}
+	}
+	return payload, nil
+}
+
+// parseExpiry accepts an RFC 3339 time or a date, which expires at the end of that day in UTC
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.Parse(time.DateOnly, value); err == nil {
+		return d.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, errors.New("expiry_date must be an RFC 3339 time or YYYY-MM-DD")
+}
+
+// writeResults writes the result CSV, one line per input row in input order
+func writeResults(path string, results []services.BatchRowResult) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file so a crash never leaves a truncated result behind
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(resultHeader)
+	for _, r := range results {
+		status := "created"
+		if r.Conflict {
+			status = "conflict"
+		} else if r.Error != "" {
+			status = "failed"
+		}
+		w.Write([]string{
+			strconv.Itoa(r.Row), r.OriginalURL, r.CustomBackoff, status,
+			r.ShortLink, r.ShortURL, strconv.FormatBool(r.Flagged), r.FlagReason, r.Error,
+		})
+	}
+	w.Flush()
+	err = w.Error()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
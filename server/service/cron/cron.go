@@ -38,3 +38,28 @@ func RunWithInterval(interval time.Duration) {
 		}
 	}
 }
+
+// Every runs job in the background at the given interval, logging its errors under name
+func Every(name string, interval time.Duration, job func() error) {
+	log.Printf("Starting %s cron service with interval: %v", name, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := job(); err != nil {
+			log.Printf("Error running %s: %v", name, err)
+		}
+	}
+}
+
+// Once runs a one-off job in the background, logging its error under name
+func Once(name string, job func() error) {
+	log.Printf("Starting %s", name)
+
+	if err := job(); err != nil {
+		log.Printf("Error running %s: %v", name, err)
+		return
+	}
+	log.Printf("Finished %s", name)
+}
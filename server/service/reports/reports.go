@@ -0,0 +1,178 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/RishiKendai/sot/api/v1/controllers/analytics"
+	"github.com/RishiKendai/sot/pkg/database/postgres"
+	"github.com/RishiKendai/sot/pkg/mailer"
+	"github.com/RishiKendai/sot/pkg/services"
+)
+
+const (
+	// Subscriptions handled per batch of SendDueReports
+	reportBatchSize = 100
+	// Rows listed per section of a report
+	reportTopLimit = 5
+)
+
+// templateDir holds the report templates, relative to the working directory like the page templates
+var templateDir = filepath.Join("templates", "email")
+
+// Report is the data the report templates are rendered with
+type Report struct {
+	Title          string
+	Frequency      string
+	ShortLink      string
+	StartDate      string
+	EndDate        string
+	TimeZone       string
+	TotalClicks    services.MetricChange
+	UniqueVisitors services.MetricChange
+	TopCountries   []analytics.GeographicData
+	TopReferrers   []analytics.ReferrerData
+	TopLinks       []analytics.TopPerformingLink
+}
+
+// SendDueReports emails every report whose period has closed. Each run is claimed before
+// it is sent, so a report is sent at most once even with several workers.
+func SendDueReports() error {
+	if !mailer.Enabled() {
+		return nil
+	}
+
+	for {
+		now := time.Now().UTC()
+		due, err := services.DueReportSubscriptions(now, reportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, sub := range due {
+			if err := sendReport(sub, now); err != nil {
+				log.Printf("Failed to send %s report %s: %v", sub.Frequency, sub.ID, err)
+			}
+		}
+		if len(due) < reportBatchSize {
+			return nil
+		}
+	}
+}
+
+func sendReport(sub services.ReportSubscription, now time.Time) error {
+	tz, err := services.ResolveTimeZone("", sub.UserUID)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return err
+	}
+
+	// Catch up on a missed run with a single report instead of one per missed period
+	next := services.NextReportRun(sub.Frequency, sub.NextRunAt, loc)
+	if !next.After(now) {
+		next = services.NextReportRun(sub.Frequency, now, loc)
+	}
+	claimed, err := services.ClaimReportRun(sub, next, now)
+	if err != nil || !claimed {
+		return err
+	}
+
+	startDate, endDate := services.ReportPeriod(sub.Frequency, sub.NextRunAt, loc)
+	summary, err := analytics.GetAnalyticsSummary(sub.UserUID, sub.ShortLink, "", startDate, endDate, tz, services.ComparePreviousPeriod)
+	if err != nil {
+		return err
+	}
+	report := buildReport(sub, summary, startDate, endDate, tz)
+
+	to := sub.Email
+	if to == "" {
+		row, err := postgres.FindOne("SELECT email FROM users WHERE uid = $1", sub.UserUID)
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&to); err != nil {
+			return err
+		}
+	}
+
+	text, html, err := render(report)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(mailer.Message{To: []string{to}, Subject: report.Title, Text: text, HTML: html})
+}
+
+func buildReport(sub services.ReportSubscription, summary *analytics.AnalyticsSummary, startDate, endDate, tz string) Report {
+	subject := "all links"
+	if sub.ShortLink != "" {
+		subject = "/" + sub.ShortLink
+	}
+	report := Report{
+		Title:        strings.ToUpper(sub.Frequency[:1]) + sub.Frequency[1:] + " report for " + subject,
+		Frequency:    sub.Frequency,
+		ShortLink:    sub.ShortLink,
+		StartDate:    startDate,
+		EndDate:      endDate,
+		TimeZone:     tz,
+		TopCountries: head(summary.AnalyticsStats.GeographicData, reportTopLimit),
+		TopReferrers: head(summary.AnalyticsStats.TopReferrers, reportTopLimit),
+	}
+	if sub.ShortLink == "" {
+		report.TopLinks = head(summary.TopPerformingLinks, reportTopLimit)
+	}
+	if summary.Comparison != nil {
+		report.TotalClicks = summary.Comparison.TotalClicks
+		report.UniqueVisitors = summary.Comparison.UniqueVisitors
+	}
+	return report
+}
+
+func head[T any](items []T, n int) []T {
+	if len(items) > n {
+		return items[:n]
+	}
+	return items
+}
+
+// funcs are the helpers available to the report templates
+var funcs = map[string]any{
+	// change formats the change of a counter against the previous period
+	"change": func(m services.MetricChange) string {
+		if m.ChangePercent == nil {
+			if m.Current == 0 {
+				return "no change"
+			}
+			return "new"
+		}
+		return fmt.Sprintf("%+.1f%%", *m.ChangePercent)
+	},
+}
+
+// render renders the plain text and HTML bodies of a report
+func render(report Report) (string, string, error) {
+	text, err := texttemplate.New("report.txt").Funcs(funcs).ParseFiles(filepath.Join(templateDir, "report.txt"))
+	if err != nil {
+		return "", "", err
+	}
+	html, err := htmltemplate.New("report.html").Funcs(funcs).ParseFiles(filepath.Join(templateDir, "report.html"))
+	if err != nil {
+		return "", "", err
+	}
+
+	var textBuf, htmlBuf bytes.Buffer
+	if err := text.Execute(&textBuf, report); err != nil {
+		return "", "", err
+	}
+	if err := html.Execute(&htmlBuf, report); err != nil {
+		return "", "", err
+	}
+	return textBuf.String(), htmlBuf.String(), nil
+}